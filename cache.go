@@ -0,0 +1,442 @@
+package circleci
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is how long mutable responses are cached when DefaultClient.CacheTTL is not set
+	DefaultCacheTTL = 30 * time.Second
+	// revalidateTTL is how long a stale response carrying an ETag or Last-Modified is kept to revalidate it
+	revalidateTTL = 24 * time.Hour
+)
+
+// CachedResponse is a response body kept by a ResponseCache. A response is fresh until Expires,
+// a zero Expires never goes stale. Stale responses with an ETag or Last-Modified are revalidated.
+type CachedResponse struct {
+	StatusCode   int       `json:"status_code"`
+	Body         []byte    `json:"body"`
+	StoredAt     time.Time `json:"stored_at"`
+	Expires      time.Time `json:"expires,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+}
+
+// Fresh reports whether the response can be served without asking the server
+func (r CachedResponse) Fresh() bool {
+	return r.Expires.IsZero() || time.Now().Before(r.Expires)
+}
+
+// conditionalHeader returns the If-None-Match and If-Modified-Since headers revalidating r
+func (r CachedResponse) conditionalHeader() http.Header {
+	header := http.Header{}
+	if r.ETag != "" {
+		header.Set("If-None-Match", r.ETag)
+	}
+	if r.LastModified != "" {
+		header.Set("If-Modified-Since", r.LastModified)
+	}
+
+	return header
+}
+
+// ResponseCache stores GET responses keyed by endpoint. A ttl of 0 keeps the entry until it is evicted.
+// MemoryCache is the in-process backend, pkg/cache provides a Redis backend shared between processes.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) (CachedResponse, bool, error)
+	Set(ctx context.Context, key string, r CachedResponse, ttl time.Duration) error
+}
+
+const (
+	// DefaultMemoryCacheEntries bounds the entries of a MemoryCache returned by NewMemoryCache
+	DefaultMemoryCacheEntries = 10000
+	// DefaultMemoryCacheBytes bounds the body bytes of a MemoryCache returned by NewMemoryCache
+	DefaultMemoryCacheBytes = 256 * 1024 * 1024
+	// memorySweepInterval is how often Set drops expired entries that were not read again
+	memorySweepInterval = time.Minute
+)
+
+// MemoryCache is a ResponseCache that lives in memory. Once it holds more than MaxEntries entries or
+// MaxBytes of bodies the least recently used entries are evicted, a bound of 0 is not enforced.
+// Expired entries are dropped when read and swept once a minute on Set.
+type MemoryCache struct {
+	MaxEntries int
+	MaxBytes   int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	swept   time.Time
+}
+
+type memoryCacheEntry struct {
+	key      string
+	response CachedResponse
+	expires  time.Time
+}
+
+func (e *memoryCacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.response.Body))
+}
+
+func (e *memoryCacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// NewMemoryCache returns an empty MemoryCache bounded by DefaultMemoryCacheEntries and DefaultMemoryCacheBytes
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		MaxEntries: DefaultMemoryCacheEntries,
+		MaxBytes:   DefaultMemoryCacheBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		swept:      time.Now(),
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (CachedResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return CachedResponse{}, false, nil
+	}
+	e := el.Value.(*memoryCacheEntry)
+	if e.expired(time.Now()) {
+		c.remove(el)
+		return CachedResponse{}, false, nil
+	}
+	c.lru.MoveToFront(el)
+
+	return e.response, true, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, r CachedResponse, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	now := time.Now()
+	if now.Sub(c.swept) >= memorySweepInterval {
+		c.sweep(now)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	e := &memoryCacheEntry{key: key, response: r}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	// a body larger than the whole cache is not stored
+	if c.MaxBytes > 0 && e.size() > c.MaxBytes {
+		return nil
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += e.size()
+
+	for c.lru.Len() > 0 && ((c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries) || (c.MaxBytes > 0 && c.size > c.MaxBytes)) {
+		c.remove(c.lru.Back())
+	}
+
+	return nil
+}
+
+// Len returns the number of entries held, expired ones not swept yet included
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// sweep drops every expired entry
+func (c *MemoryCache) sweep(now time.Time) {
+	c.swept = now
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*memoryCacheEntry).expired(now) {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*memoryCacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size()
+}
+
+var (
+	cacheJobDetails = regexp.MustCompile(`^api/v2/project/([^/]+/[^/]+/[^/]+)/job/(\d+)$`)
+	cacheBuild      = regexp.MustCompile(`^api/v1\.1/project/([^/]+/[^/]+/[^/]+)/(\d+)$`)
+	cacheJobChild   = regexp.MustCompile(`^api/v(?:2|1\.1)/project/([^/]+/[^/]+/[^/]+)/(\d+)/(?:output|tests|artifacts)\b`)
+	cacheConfig     = regexp.MustCompile(`^api/v2/pipeline/[^/]+/config$`)
+)
+
+// cacheKey keys responses by server, token and endpoint so several servers can share one backend
+// and a response is never served to a token that may not read it. Only a hash of the token is kept.
+func (s *DefaultClient) cacheKey(endpoint string) string {
+	token := sha256.Sum256([]byte(s.Token))
+	return s.Host + "/" + hex.EncodeToString(token[:8]) + "/" + endpoint
+}
+
+// noteFinished records the jobs whose details say they finished, their outputs, tests and
+// artifacts can no longer change
+func (s *DefaultClient) noteFinished(path string, body []byte) bool {
+	var job struct {
+		Status    string `json:"status"`
+		Lifecycle string `json:"lifecycle"`
+	}
+	m := cacheJobDetails.FindStringSubmatch(path)
+	if m == nil {
+		m = cacheBuild.FindStringSubmatch(path)
+	}
+	if m == nil || json.Unmarshal(body, &job) != nil {
+		return false
+	}
+	if job.Lifecycle == "finished" || (job.Lifecycle == "" && isTerminalStatus(job.Status)) {
+		s.finishedJobs.add(m[1] + "/" + m[2])
+		return true
+	}
+
+	return false
+}
+
+// jobFinished reports whether an output, tests or artifacts endpoint belongs to a finished job
+func (s *DefaultClient) jobFinished(path string) bool {
+	m := cacheJobChild.FindStringSubmatch(path)
+	if m == nil {
+		return false
+	}
+
+	return s.finishedJobs.contains(m[1] + "/" + m[2])
+}
+
+// maxFinishedJobs bounds the finished jobs a DefaultClient remembers, the least recently used are
+// forgotten and their outputs are cached for CacheTTL again until their details are fetched
+const maxFinishedJobs = 10000
+
+// jobSet is a set of jobs bounded by maxFinishedJobs, the zero value is empty
+type jobSet struct {
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+func (s *jobSet) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.items == nil {
+		s.items = make(map[string]*list.Element)
+		s.order = list.New()
+	}
+	if el, ok := s.items[key]; ok {
+		s.order.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.order.PushFront(key)
+	if s.order.Len() > maxFinishedJobs {
+		delete(s.items, s.order.Remove(s.order.Back()).(string))
+	}
+}
+
+func (s *jobSet) contains(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if ok {
+		s.order.MoveToFront(el)
+	}
+
+	return ok
+}
+
+// cacheTTL decides how long a response stays fresh: compiled configs and finished jobs never change, and
+// neither do the outputs, tests and artifacts of a job this client has seen finish. Everything else
+// is fresh for CacheTTL, DefaultCacheTTL when it is not set, and not at all with Revalidate.
+func (s *DefaultClient) cacheTTL(endpoint string, body []byte) (fresh time.Duration, immutable bool) {
+	path, _, _ := strings.Cut(endpoint, "?")
+	if cacheConfig.MatchString(path) || s.noteFinished(path, body) || s.jobFinished(path) {
+		return 0, true
+	}
+	if s.Revalidate {
+		return 0, false
+	}
+	if s.CacheTTL > 0 {
+		return s.CacheTTL, false
+	}
+
+	return DefaultCacheTTL, false
+}
+
+// cachedGet serves a GET from Cache when possible and stores successful responses. A stale response
+// with an ETag or Last-Modified is revalidated with a conditional request and a 304 serves it again.
+// Cache errors are ignored so an unavailable backend only costs the request.
+func (s *DefaultClient) cachedGet(ctx context.Context, endpoint string, fetch func(header http.Header) ([]byte, *http.Response, error)) ([]byte, *http.Response, error) {
+	if s.Cache == nil {
+		return fetch(nil)
+	}
+
+	key := s.cacheKey(endpoint)
+	path, _, _ := strings.Cut(endpoint, "?")
+	cached, ok, err := s.Cache.Get(ctx, key)
+	ok = ok && err == nil
+	if ok && cached.Fresh() {
+		s.noteFinished(path, cached.Body)
+		return cached.Body, cachedHTTPResponse(cached, "HIT"), nil
+	}
+
+	var header http.Header
+	if ok {
+		header = cached.conditionalHeader()
+	}
+	body, resp, err := fetch(header)
+	if err != nil || resp == nil {
+		return body, resp, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		s.storeResponse(ctx, key, path, cached)
+		return cached.Body, cachedHTTPResponse(cached, "REVALIDATED"), nil
+	case resp.StatusCode == http.StatusOK:
+		s.storeResponse(ctx, key, path, CachedResponse{
+			StatusCode:   resp.StatusCode,
+			Body:         body,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		})
+	}
+
+	return body, resp, err
+}
+
+// storeResponse stores r fresh for its cache TTL, revalidatable responses are kept longer so they
+// can still be revalidated once stale. A mutable response that is stale at once and cannot be
+// revalidated is not stored.
+func (s *DefaultClient) storeResponse(ctx context.Context, key string, path string, r CachedResponse) {
+	fresh, immutable := s.cacheTTL(path, r.Body)
+	r.StoredAt = time.Now()
+	r.Expires = time.Time{}
+	if immutable {
+		s.Cache.Set(ctx, key, r, 0)
+		return
+	}
+
+	r.Expires = r.StoredAt.Add(fresh)
+	ttl := fresh
+	if r.ETag != "" || r.LastModified != "" {
+		ttl = max(ttl, revalidateTTL)
+	}
+	if ttl > 0 {
+		s.Cache.Set(ctx, key, r, ttl)
+	}
+}
+
+// revalidating returns a client sharing the cache of ci that revalidates mutable responses on every
+// request, clients other than the ones returned by New and NewWithCache are returned unchanged
+func revalidating(ci CI) CI {
+	c, ok := ci.(*ciClient)
+	if !ok || c.Cache == nil || c.Revalidate {
+		return ci
+	}
+	r := new(ciClient)
+	r.ServerInfo = c.ServerInfo
+	r.Debug = c.Debug
+	r.Cache = c.Cache
+	r.CacheTTL = c.CacheTTL
+	r.Revalidate = true
+
+	return r
+}
+
+// cachedOpen serves a streamed GET from Cache. Only outputs of finished jobs are stored, they are
+// buffered while the caller reads them and stored once the body was read to the end.
+func (s *DefaultClient) cachedOpen(ctx context.Context, endpoint string, open func() (io.ReadCloser, *http.Response, error)) (io.ReadCloser, *http.Response, error) {
+	if s.Cache == nil {
+		return open()
+	}
+
+	key := s.cacheKey(endpoint)
+	if cached, ok, err := s.Cache.Get(ctx, key); err == nil && ok && cached.Fresh() {
+		return io.NopCloser(bytes.NewReader(cached.Body)), cachedHTTPResponse(cached, "HIT"), nil
+	}
+
+	body, resp, err := open()
+	path, _, _ := strings.Cut(endpoint, "?")
+	if err != nil || !s.jobFinished(path) {
+		return body, resp, err
+	}
+
+	return &cachingReader{ReadCloser: body, ctx: ctx, cache: s.Cache, key: key, status: resp.StatusCode}, resp, nil
+}
+
+// cachingReader copies what is read into a buffer and stores it when the body is exhausted.
+// Bodies larger than maxCachedBody are passed through without being stored.
+type cachingReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cache  ResponseCache
+	key    string
+	status int
+	buf    bytes.Buffer
+	skip   bool
+}
+
+const maxCachedBody = 16 * 1024 * 1024
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if !r.skip {
+		r.buf.Write(p[:n])
+		if r.buf.Len() > maxCachedBody {
+			// give up on caching and release what was buffered so far
+			r.skip = true
+			r.buf = bytes.Buffer{}
+		}
+	}
+	if err == io.EOF && !r.skip {
+		r.cache.Set(r.ctx, r.key, CachedResponse{StatusCode: r.status, Body: r.buf.Bytes(), StoredAt: time.Now()}, 0)
+		r.skip = true
+	}
+
+	return n, err
+}
+
+func cachedHTTPResponse(cached CachedResponse, state string) *http.Response {
+	header := http.Header{}
+	header.Set("X-Cache", state)
+	if cached.ETag != "" {
+		header.Set("ETag", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		header.Set("Last-Modified", cached.LastModified)
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", cached.StatusCode, http.StatusText(cached.StatusCode)),
+		StatusCode: cached.StatusCode,
+		Header:     header,
+	}
+}
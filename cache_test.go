@@ -0,0 +1,174 @@
+package circleci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+	c.MaxEntries = 2
+
+	c.Set(ctx, "a", CachedResponse{Body: []byte("a")}, 0)
+	c.Set(ctx, "b", CachedResponse{Body: []byte("b")}, 0)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", CachedResponse{Body: []byte("c")}, 0)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := c.Get(ctx, key); ok != want {
+			t.Errorf("Get(%q) found = %t, want %t", key, ok, want)
+		}
+	}
+}
+
+func TestMemoryCacheMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+	c.MaxBytes = 10
+
+	c.Set(ctx, "a", CachedResponse{Body: []byte("12345")}, 0)
+	c.Set(ctx, "b", CachedResponse{Body: []byte("12345")}, 0)
+	if c.Len() != 1 {
+		t.Errorf("Len = %d, want 1 once the bodies exceed MaxBytes", c.Len())
+	}
+	c.Set(ctx, "big", CachedResponse{Body: make([]byte, 20)}, 0)
+	if _, ok, _ := c.Get(ctx, "big"); ok {
+		t.Error("a body larger than MaxBytes was stored")
+	}
+}
+
+func TestMemoryCacheSweepsExpired(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+
+	for i := 0; i < 10; i++ {
+		c.Set(ctx, strconv.Itoa(i), CachedResponse{}, time.Nanosecond)
+	}
+	time.Sleep(time.Millisecond)
+	c.swept = time.Now().Add(-memorySweepInterval)
+	c.Set(ctx, "kept", CachedResponse{}, 0)
+
+	if c.Len() != 1 {
+		t.Errorf("Len = %d after a sweep, want 1", c.Len())
+	}
+}
+
+func TestFinishedJobsBounded(t *testing.T) {
+	var s jobSet
+	for i := 0; i < maxFinishedJobs+10; i++ {
+		s.add(strconv.Itoa(i))
+	}
+	if len(s.items) != maxFinishedJobs {
+		t.Errorf("remembered %d jobs, want %d", len(s.items), maxFinishedJobs)
+	}
+	if s.contains("0") || !s.contains(strconv.Itoa(maxFinishedJobs+9)) {
+		t.Error("the oldest jobs should be forgotten first")
+	}
+}
+
+func TestRevalidateSendsConditionalRequests(t *testing.T) {
+	ctx := context.Background()
+	s := &DefaultClient{Cache: NewMemoryCache(), Revalidate: true}
+	headers := make([]http.Header, 0)
+	fetch := func(header http.Header) ([]byte, *http.Response, error) {
+		headers = append(headers, header)
+		if header.Get("If-None-Match") == `"v1"` {
+			return nil, &http.Response{StatusCode: http.StatusNotModified, Header: http.Header{}}, nil
+		}
+		return []byte(`{"items":[]}`), &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Etag": {`"v1"`}}}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		body, _, err := s.cachedGet(ctx, "api/v2/workflow/w1/job", fetch)
+		if err != nil || string(body) != `{"items":[]}` {
+			t.Fatalf("cachedGet = %q, %v", body, err)
+		}
+	}
+	if len(headers) != 3 {
+		t.Fatalf("fetched %d times, want every request revalidated", len(headers))
+	}
+	for i, h := range headers[1:] {
+		if h.Get("If-None-Match") != `"v1"` {
+			t.Errorf("request %d sent If-None-Match %q", i+2, h.Get("If-None-Match"))
+		}
+	}
+}
+
+func TestCacheTTLServesFreshResponses(t *testing.T) {
+	ctx := context.Background()
+	s := &DefaultClient{Cache: NewMemoryCache()}
+	fetched := 0
+	fetch := func(header http.Header) ([]byte, *http.Response, error) {
+		fetched++
+		return []byte(`{}`), &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil
+	}
+
+	s.cachedGet(ctx, "api/v2/workflow/w1/job", fetch)
+	s.cachedGet(ctx, "api/v2/workflow/w1/job", fetch)
+	if fetched != 1 {
+		t.Errorf("fetched %d times, want the second request served from the cache", fetched)
+	}
+}
+
+func TestCacheKeySeparatesTokens(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+	a := &DefaultClient{ServerInfo: ServerInfo{Host: "https://circleci.example.com", Token: "a"}, Cache: cache}
+	b := &DefaultClient{ServerInfo: ServerInfo{Host: "https://circleci.example.com", Token: "b"}, Cache: cache}
+	fetched := 0
+	fetch := func(header http.Header) ([]byte, *http.Response, error) {
+		fetched++
+		return []byte(`{}`), &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil
+	}
+
+	a.cachedGet(ctx, "api/v2/workflow/w1/job", fetch)
+	b.cachedGet(ctx, "api/v2/workflow/w1/job", fetch)
+	if fetched != 2 {
+		t.Errorf("fetched %d times, want a response cached for one token not served to another", fetched)
+	}
+	if key := a.cacheKey("api/v2/workflow/w1/job"); strings.Contains(key, "/a/") {
+		t.Errorf("cache key %q holds the token", key)
+	}
+}
+
+func TestCachingReaderDropsOversizedBodies(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+	body := io.NopCloser(io.LimitReader(zeroReader{}, maxCachedBody+1024))
+	r := &cachingReader{ReadCloser: body, ctx: ctx, cache: cache, key: "big", status: http.StatusOK}
+
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.buf.Cap() != 0 {
+		t.Errorf("buffer still holds %d bytes after giving up on caching", r.buf.Cap())
+	}
+	if _, ok, _ := cache.Get(ctx, "big"); ok {
+		t.Error("a body larger than maxCachedBody was cached")
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestRevalidatingWatcherClient(t *testing.T) {
+	ci, _ := NewWithCache("https://circleci.example.com", "token", "", NewMemoryCache())
+	w := NewWatcher(ci, "bldmgr")
+	c, ok := w.ci.(*ciClient)
+	if !ok || !c.Revalidate || c.Cache == nil {
+		t.Errorf("watcher client does not revalidate through the shared cache")
+	}
+	if ci.(*ciClient).Revalidate {
+		t.Errorf("the client passed to the watcher was changed")
+	}
+}
@@ -16,3 +16,14 @@ func New(host, token, project string) (CI, error) {
 
 	return ci, nil
 }
+
+// NewWithCache returns a client that caches GET responses in cache
+func NewWithCache(host, token, project string, cache ResponseCache) (CI, error) {
+	ci := new(ciClient)
+	ci.Host = host
+	ci.Token = token
+	ci.Project = project
+	ci.Cache = cache
+
+	return ci, nil
+}
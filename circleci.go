@@ -1,6 +1,9 @@
 package circleci
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +11,8 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
+	neturl "net/url"
+	"strings"
 	"time"
 )
 
@@ -25,10 +30,37 @@ type Client interface {
 	Info() ServerInfo
 }
 
-// DefaultClient provides an HTTP wrapper with optimized for communicating with a Circle server
+// ContextClient is implemented by clients whose GET requests are cancelled with a context.
+// DefaultClient implements it, other clients fall back to Get.
+type ContextClient interface {
+	GetWithContext(ctx context.Context, endpoint string) ([]byte, *http.Response, error)
+}
+
+// StreamClient is implemented by clients that stream response bodies instead of reading them into
+// memory. DefaultClient implements it, other clients fall back to a buffered Get.
+type StreamClient interface {
+	Open(ctx context.Context, endpoint string) (io.ReadCloser, *http.Response, error)
+}
+
+// openIdleTimeout aborts a streamed response when no data arrived for that long, streams have no
+// overall timeout as large logs take longer than the 30s of buffered requests
+const openIdleTimeout = 30 * time.Second
+
+var errOpenIdle = errors.New("no data received within the idle timeout")
+
+// DefaultClient provides an HTTP wrapper with optimized for communicating with a Circle server.
+// When Cache is set GET responses are cached, mutable ones for CacheTTL after which they are
+// revalidated with If-None-Match or If-Modified-Since when the server sent an ETag or Last-Modified.
+// With Revalidate mutable responses are revalidated on every request, an unchanged response then
+// costs a 304 without a body.
 type DefaultClient struct {
 	ServerInfo
-	Debug bool
+	Debug      bool
+	Cache      ResponseCache
+	CacheTTL   time.Duration
+	Revalidate bool
+
+	finishedJobs jobSet
 }
 
 // NewRequest created an http.Request object based on an endpoint and fills in basic auth
@@ -104,10 +136,149 @@ func (s *DefaultClient) Info() ServerInfo {
 
 // Get performs an HTTP GET against the indicated endpoint
 func (s *DefaultClient) Get(endpoint string) ([]byte, *http.Response, error) {
-	return s.http(http.MethodGet, endpoint, nil)
+	return s.GetWithContext(context.Background(), endpoint)
+}
+
+// GetWithContext performs an HTTP GET against the indicated endpoint which is cancelled with ctx
+func (s *DefaultClient) GetWithContext(ctx context.Context, endpoint string) ([]byte, *http.Response, error) {
+	return s.cachedGet(ctx, endpoint, func(header http.Header) ([]byte, *http.Response, error) {
+		request, err := s.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range header {
+			request.Header[k] = v
+		}
+
+		return s.Do(request.WithContext(ctx))
+	})
+}
+
+// Open performs an HTTP GET against the indicated endpoint and returns the body unread so large
+// responses can be streamed. The request is aborted when ctx is cancelled or no data arrived for
+// openIdleTimeout. The caller closes the body.
+func (s *DefaultClient) Open(ctx context.Context, endpoint string) (io.ReadCloser, *http.Response, error) {
+	return s.cachedOpen(ctx, endpoint, func() (io.ReadCloser, *http.Response, error) {
+		request, err := s.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if s.Debug {
+			dump, _ := httputil.DumpRequest(request, false)
+			log.Println("debug: http request:")
+			log.Printf("%q\n", dump)
+		}
+
+		ctx, cancel := context.WithCancelCause(ctx)
+		idle := time.AfterFunc(openIdleTimeout, func() { cancel(errOpenIdle) })
+		resp, err := http.DefaultClient.Do(request.WithContext(ctx))
+		if err != nil {
+			idle.Stop()
+			cancel(nil)
+			return nil, nil, idleError(ctx, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			idle.Stop()
+			cancel(nil)
+			resp.Body.Close()
+			return nil, resp, errors.New(resp.Status)
+		}
+
+		return &idleReader{ReadCloser: resp.Body, ctx: ctx, cancel: cancel, idle: idle}, resp, nil
+	})
+}
+
+// idleReader restarts the idle timer of a streamed body on every read
+type idleReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	idle   *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.idle.Reset(openIdleTimeout)
+	if err != nil && err != io.EOF {
+		err = idleError(r.ctx, err)
+	}
+
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.idle.Stop()
+	r.cancel(nil)
+	return r.ReadCloser.Close()
+}
+
+// idleError reports an aborted idle stream as errOpenIdle instead of a cancelled context
+func idleError(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), errOpenIdle) {
+		return errOpenIdle
+	}
+
+	return err
+}
+
+// getWithContext performs a GET with GetWithContext when ci implements ContextClient
+func getWithContext(ctx context.Context, ci Client, endpoint string) ([]byte, *http.Response, error) {
+	if c, ok := ci.(ContextClient); ok {
+		return c.GetWithContext(ctx, endpoint)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return ci.Get(endpoint)
+}
+
+// openEndpoint streams a GET with Open when ci implements StreamClient and buffers it otherwise
+func openEndpoint(ctx context.Context, ci Client, endpoint string) (io.ReadCloser, *http.Response, error) {
+	if c, ok := ci.(StreamClient); ok {
+		return c.Open(ctx, endpoint)
+	}
+
+	body, resp, err := getWithContext(ctx, ci, endpoint)
+	if err != nil {
+		return nil, resp, err
+	}
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		status := "no response"
+		if resp != nil {
+			status = resp.Status
+		}
+		return nil, resp, errors.New(status)
+	}
+
+	return io.NopCloser(bytes.NewReader(body)), resp, nil
+}
+
+// getPage fetches url and decodes the JSON body into v
+func getPage(ctx context.Context, ci CI, url string, v interface{}) error {
+	body, resp, err := getWithContext(ctx, ci, url)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+
+	return json.Unmarshal(body, v)
 }
 
 // Post performs an HTTP POST against the indicated endpoint
 func (s *DefaultClient) Post(endpoint string, payload io.Reader) ([]byte, *http.Response, error) {
 	return s.http(http.MethodPost, endpoint, payload)
 }
+
+// withPageToken adds the page-token parameter of the v2 API to endpoint
+func withPageToken(endpoint string, token string) string {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+
+	return endpoint + sep + "page-token=" + neturl.QueryEscape(token)
+}
@@ -0,0 +1,284 @@
+package circleci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/bldmgr/circleci/pkg/logs"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FailureTest           = "test_failure"
+	FailureOOM            = "oom"
+	FailureTimeout        = "timeout"
+	FailureDependency     = "dependency_download"
+	FailureDockerPull     = "docker_pull"
+	FailureInfrastructure = "infrastructure"
+	FailureConfig         = "config_error"
+	FailureUnknown        = "unknown"
+
+	// maxFailureMatches bounds the matches kept per rule so a log repeating an error does not grow without limit
+	maxFailureMatches = 5
+)
+
+// FailureRule tags a failure category when Pattern matches a line of step output.
+// Step optionally restricts the rule to steps whose name matches it.
+type FailureRule struct {
+	Category    string  `yaml:"category" json:"category"`
+	Pattern     string  `yaml:"pattern" json:"pattern"`
+	Step        string  `yaml:"step" json:"step,omitempty"`
+	Confidence  float64 `yaml:"confidence" json:"confidence"`
+	Description string  `yaml:"description" json:"description"`
+
+	pattern *regexp.Regexp
+	step    *regexp.Regexp
+}
+
+// FailureRules is an ordered set of compiled rules
+type FailureRules []FailureRule
+
+// DefaultFailureRules are used when no rule file is supplied
+var DefaultFailureRules = mustCompileRules(FailureRules{
+	{Category: FailureOOM, Pattern: `(?i)exit(ed with)? (code|status) (exit status )?137\b`, Confidence: 0.9, Description: "exit code 137"},
+	{Category: FailureOOM, Pattern: `(?i)\b(out of memory|oomkilled|cannot allocate memory)\b`, Confidence: 0.8, Description: "out of memory"},
+	{Category: FailureOOM, Pattern: `JavaScript heap out of memory|java\.lang\.OutOfMemoryError`, Confidence: 0.9, Description: "runtime heap exhausted"},
+	{Category: FailureOOM, Pattern: `(?i)received 'killed' signal|^Killed$`, Confidence: 0.6, Description: "process killed"},
+	{Category: FailureTimeout, Pattern: `Too long with no output`, Confidence: 0.95, Description: "no output timeout"},
+	{Category: FailureTimeout, Pattern: `(?i)(build|job) timed out|context deadline exceeded|exceeded the maximum (run )?time`, Confidence: 0.7, Description: "timed out"},
+	{Category: FailureDockerPull, Pattern: `(?i)(error|failed) pulling image|pull access denied|manifest (for .* )?unknown|toomanyrequests`, Confidence: 0.9, Description: "docker pull failed"},
+	{Category: FailureDockerPull, Pattern: `(?i)failed to pull image|image .* not found`, Step: `(?i)spin up`, Confidence: 0.85, Description: "executor image pull failed"},
+	{Category: FailureDependency, Pattern: `(?i)could not resolve host|temporary failure in name resolution|tls handshake timeout|connection reset by peer|ETIMEDOUT|ECONNRESET`, Confidence: 0.6, Description: "network error"},
+	{Category: FailureDependency, Pattern: `(?i)npm ERR! (network|code E(AI_AGAIN|TIMEDOUT|CONNRESET|404))|failed to download|could not resolve dependencies|ReadTimeoutError|unable to access 'https?://`, Confidence: 0.8, Description: "dependency download failed"},
+	{Category: FailureInfrastructure, Pattern: `(?i)unexpected error|there was an error while starting the build|lost contact with|machine was lost|agent shut down unexpectedly|no space left on device`, Confidence: 0.7, Description: "infrastructure error"},
+	{Category: FailureInfrastructure, Pattern: `(?i).`, Step: `(?i)^spin up environment$`, Confidence: 0.1, Description: "failed while spinning up"},
+	{Category: FailureConfig, Pattern: `(?i)config does not conform to schema|cannot find a definition for (command|job|executor)|unknown variable\(s\)|missing required argument|error calling (workflow|job)`, Confidence: 0.9, Description: "config error"},
+	{Category: FailureTest, Pattern: `^--- FAIL: |^FAIL\s|\b[1-9]\d* (tests? )?failed\b|Tests? failed|AssertionError|^rspec \./|^FAILED `, Confidence: 0.6, Description: "test runner reported failures"},
+})
+
+func mustCompileRules(rules FailureRules) FailureRules {
+	compiled, err := rules.Compile()
+	if err != nil {
+		panic(err)
+	}
+
+	return compiled
+}
+
+// Compile validates every rule and compiles its patterns
+func (rules FailureRules) Compile() (FailureRules, error) {
+	out := make(FailureRules, len(rules))
+	for i, r := range rules {
+		var err error
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, r.Category, err)
+		}
+		if r.Step != "" {
+			if r.step, err = regexp.Compile(r.Step); err != nil {
+				return nil, fmt.Errorf("rule %d (%s) step: %w", i, r.Category, err)
+			}
+		}
+		if r.Confidence <= 0 || r.Confidence > 1 {
+			r.Confidence = 0.5
+		}
+		out[i] = r
+	}
+
+	return out, nil
+}
+
+// ParseFailureRules decodes a YAML or JSON rule file of the form `rules: [{category, pattern, step, confidence}]`
+func ParseFailureRules(data []byte) (FailureRules, error) {
+	var file struct {
+		Rules FailureRules `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	return file.Rules.Compile()
+}
+
+// LoadFailureRules reads a rule file from disk
+func LoadFailureRules(path string) (FailureRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFailureRules(data)
+}
+
+// FailureMatch is a rule that matched a line of step output or a test result
+type FailureMatch struct {
+	Category    string  `json:"category"`
+	Description string  `json:"description"`
+	Confidence  float64 `json:"confidence"`
+	Step        string  `json:"step"`
+	Line        int     `json:"line,omitempty"`
+	Text        string  `json:"text"`
+}
+
+// FailureClassification is the verdict for a failed job. Scores combines the confidence of every
+// match of a category, the highest scoring category wins.
+type FailureClassification struct {
+	Job        JobRef             `json:"job"`
+	Category   string             `json:"category"`
+	Confidence float64            `json:"confidence"`
+	Scores     map[string]float64 `json:"scores"`
+	Matches    []FailureMatch     `json:"matches"`
+}
+
+// FailureClassifier accumulates evidence from step outputs and test results
+type FailureClassifier struct {
+	Rules   FailureRules
+	matches []FailureMatch
+}
+
+// NewFailureClassifier returns a classifier using rules, or DefaultFailureRules when rules is empty
+func NewFailureClassifier(rules FailureRules) *FailureClassifier {
+	if len(rules) == 0 {
+		rules = DefaultFailureRules
+	}
+
+	return &FailureClassifier{Rules: rules, matches: make([]FailureMatch, 0)}
+}
+
+// AddOutput matches every normalized line of a step's output against the rules
+func (c *FailureClassifier) AddOutput(step string, r io.Reader) error {
+	counts := make(map[int]int)
+	return ForEachLine(r, func(n int, raw string) bool {
+		line := logs.Normalize(raw)
+		for i, rule := range c.Rules {
+			if counts[i] >= maxFailureMatches || (rule.step != nil && !rule.step.MatchString(step)) {
+				continue
+			}
+			if rule.pattern.MatchString(line) {
+				counts[i]++
+				c.matches = append(c.matches, FailureMatch{
+					Category:    rule.Category,
+					Description: rule.Description,
+					Confidence:  rule.Confidence,
+					Step:        step,
+					Line:        n,
+					Text:        line,
+				})
+			}
+		}
+		return true
+	})
+}
+
+// AddExitCode records the exit code of a failed step
+func (c *FailureClassifier) AddExitCode(step string, code int) {
+	switch code {
+	case 137:
+		c.matches = append(c.matches, FailureMatch{Category: FailureOOM, Description: "exit code 137", Confidence: 0.85, Step: step, Text: "exit code 137"})
+	case 124:
+		c.matches = append(c.matches, FailureMatch{Category: FailureTimeout, Description: "exit code 124", Confidence: 0.6, Step: step, Text: "exit code 124"})
+	}
+}
+
+// AddTimedOut records a step CircleCI reported as timed out
+func (c *FailureClassifier) AddTimedOut(step string) {
+	c.matches = append(c.matches, FailureMatch{Category: FailureTimeout, Description: "step timed out", Confidence: 0.95, Step: step, Text: "timedout"})
+}
+
+// AddTests records failed test results, the more tests fail the more confident the verdict
+func (c *FailureClassifier) AddTests(tests []TestMetadata) {
+	failed := 0
+	first := ""
+	for _, t := range tests {
+		if t.Failed() {
+			if failed == 0 {
+				first = t.Classname + " " + t.Name
+			}
+			failed++
+		}
+	}
+	if failed == 0 {
+		return
+	}
+
+	confidence := 0.8
+	if failed > 1 {
+		confidence = 0.9
+	}
+	c.matches = append(c.matches, FailureMatch{
+		Category:    FailureTest,
+		Description: strconv.Itoa(failed) + " failed tests",
+		Confidence:  confidence,
+		Step:        "tests",
+		Text:        first,
+	})
+}
+
+// Result scores every category with the noisy-or of its match confidences and picks the highest
+func (c *FailureClassifier) Result() FailureClassification {
+	result := FailureClassification{
+		Category: FailureUnknown,
+		Scores:   make(map[string]float64),
+		Matches:  c.matches,
+	}
+
+	miss := make(map[string]float64)
+	for _, m := range c.matches {
+		if _, ok := miss[m.Category]; !ok {
+			miss[m.Category] = 1
+		}
+		miss[m.Category] *= 1 - m.Confidence
+	}
+	categories := make([]string, 0, len(miss))
+	for category, p := range miss {
+		result.Scores[category] = 1 - p
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		if result.Scores[category] > result.Confidence {
+			result.Category = category
+			result.Confidence = result.Scores[category]
+		}
+	}
+
+	return result
+}
+
+// ClassifyJobFailure classifies why a job failed from the output and exit codes of its failed steps
+// and its test results. rules may be nil to use DefaultFailureRules.
+func ClassifyJobFailure(ctx context.Context, ci CI, job JobRef, rules FailureRules) FailureClassification {
+	c := NewFailureClassifier(rules)
+
+	for _, step := range GetBuildDetails(ci, job, "none").Steps {
+		for _, action := range step.Actions {
+			failed := action.Status == "failed" || action.Status == "timedout" || (action.Failed != nil && *action.Failed)
+			if !failed {
+				continue
+			}
+			if action.ExitCode != nil {
+				c.AddExitCode(action.Name, *action.ExitCode)
+			}
+			if action.Status == "timedout" || (action.Timedout != nil && *action.Timedout) {
+				c.AddTimedOut(action.Name)
+			}
+			r, err := OpenStepOutput(ctx, ci, job, strconv.Itoa(action.Step), action.Index)
+			if err != nil {
+				continue
+			}
+			c.AddOutput(action.Name, r)
+			r.Close()
+		}
+	}
+
+	c.AddTests(GetTestMetadataRef(ci, job))
+
+	result := c.Result()
+	result.Job = job
+
+	return result
+}
@@ -0,0 +1,49 @@
+package circleci
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultFailureRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   string
+		output string
+		want   string
+	}{
+		{"exit code 137", "run tests", "Exited with code exit status 137", FailureOOM},
+		{"node heap", "build", "FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory", FailureOOM},
+		{"java heap", "build", "java.lang.OutOfMemoryError: Java heap space", FailureOOM},
+		{"no output", "run tests", "Too long with no output (exceeded 10m0s): context deadline exceeded", FailureTimeout},
+		{"docker pull", "build image", "Error response from daemon: pull access denied for acme/app", FailureDockerPull},
+		{"spin up pull", "Spin up environment", "Failed to pull image cimg/go:9.9", FailureDockerPull},
+		{"npm network", "npm install", "npm ERR! code ETIMEDOUT", FailureDependency},
+		{"git host", "checkout", "fatal: unable to access 'https://github.com/acme/app.git/': Could not resolve host: github.com", FailureDependency},
+		{"disk full", "build", "write /tmp/x: no space left on device", FailureInfrastructure},
+		{"config", "Preparing environment variables", "ERROR IN CONFIG FILE: Cannot find a definition for command named deploy", FailureConfig},
+		{"go test", "run tests", "--- FAIL: TestParse (0.00s)", FailureTest},
+		{"jest summary", "run tests", "Tests:       3 failed, 120 passed, 123 total", FailureTest},
+		{"pytest summary", "run tests", "===== 2 failed, 98 passed in 12.3s =====", FailureTest},
+		{"nothing failed", "run tests", "Tests: 0 failed, 120 passed, 120 total", FailureUnknown},
+		{"passing summary then network error", "run tests", "===== 98 passed, 0 failed in 12.3s =====\nnpm ERR! code ECONNRESET", FailureDependency},
+		{"clean output", "build", "compiled 42 packages", FailureUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFailureClassifier(nil)
+			if err := c.AddOutput(tt.step, strings.NewReader(tt.output)); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Result(); got.Category != tt.want {
+				t.Errorf("category = %s, want %s (matches %+v)", got.Category, tt.want, got.Matches)
+			}
+			for _, m := range c.Result().Matches {
+				if tt.want != FailureTest && m.Category == FailureTest {
+					t.Errorf("unexpected test failure match on %q", m.Text)
+				}
+			}
+		})
+	}
+}
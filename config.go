@@ -0,0 +1,170 @@
+package circleci
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CompiledConfig is a typed view over a pipeline's compiled config.yml
+type CompiledConfig struct {
+	Version    string                     `yaml:"version" json:"version"`
+	Setup      bool                       `yaml:"setup" json:"setup"`
+	Orbs       map[string]interface{}     `yaml:"orbs" json:"orbs"`
+	Parameters map[string]ConfigParameter `yaml:"parameters" json:"parameters"`
+	Executors  map[string]ConfigExecutor  `yaml:"executors" json:"executors"`
+	Commands   map[string]interface{}     `yaml:"commands" json:"commands"`
+	Jobs       map[string]ConfigJob       `yaml:"jobs" json:"jobs"`
+	Workflows  ConfigWorkflows            `yaml:"workflows" json:"workflows"`
+}
+
+// ConfigParameter is a pipeline, job or command parameter declaration
+type ConfigParameter struct {
+	Type        string      `yaml:"type" json:"type"`
+	Default     interface{} `yaml:"default" json:"default"`
+	Description string      `yaml:"description" json:"description"`
+	Enum        []string    `yaml:"enum" json:"enum"`
+}
+
+// ConfigDocker is one image of a docker executor
+type ConfigDocker struct {
+	Image string `yaml:"image" json:"image"`
+	Name  string `yaml:"name" json:"name"`
+}
+
+// ConfigMachine is a machine executor, declared either as `machine: true` or as a map
+type ConfigMachine struct {
+	Enabled            bool   `json:"enabled"`
+	Image              string `json:"image"`
+	DockerLayerCaching bool   `json:"docker_layer_caching"`
+}
+
+// UnmarshalYAML accepts both the boolean and the map form of machine
+func (m *ConfigMachine) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var enabled bool
+		if err := value.Decode(&enabled); err != nil {
+			return err
+		}
+		m.Enabled = enabled
+		return nil
+	}
+
+	var raw struct {
+		Image              string `yaml:"image"`
+		DockerLayerCaching bool   `yaml:"docker_layer_caching"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	m.Enabled = true
+	m.Image = raw.Image
+	m.DockerLayerCaching = raw.DockerLayerCaching
+
+	return nil
+}
+
+// ConfigMacos is a macOS executor
+type ConfigMacos struct {
+	Xcode string `yaml:"xcode" json:"xcode"`
+}
+
+// ConfigExecutor holds the executor keys shared by executors and jobs
+type ConfigExecutor struct {
+	Docker           []ConfigDocker `yaml:"docker" json:"docker"`
+	Machine          *ConfigMachine `yaml:"machine" json:"machine"`
+	Macos            *ConfigMacos   `yaml:"macos" json:"macos"`
+	ResourceClass    string         `yaml:"resource_class" json:"resource_class"`
+	Shell            string         `yaml:"shell" json:"shell"`
+	WorkingDirectory string         `yaml:"working_directory" json:"working_directory"`
+}
+
+// Type returns docker, machine or macos depending on the declared executor
+func (e ConfigExecutor) Type() string {
+	switch {
+	case len(e.Docker) > 0:
+		return "docker"
+	case e.Machine != nil && e.Machine.Enabled:
+		return "machine"
+	case e.Macos != nil:
+		return "macos"
+	}
+
+	return ""
+}
+
+// Image returns the primary image of the executor: the first docker image,
+// the machine image or the Xcode version
+func (e ConfigExecutor) Image() string {
+	switch e.Type() {
+	case "docker":
+		return e.Docker[0].Image
+	case "machine":
+		return e.Machine.Image
+	case "macos":
+		return e.Macos.Xcode
+	}
+
+	return ""
+}
+
+// ConfigJob is a job definition under the top level jobs key
+type ConfigJob struct {
+	ConfigExecutor `yaml:",inline"`
+	Executor       interface{}                `yaml:"executor" json:"executor_ref"`
+	Parallelism    string                     `yaml:"parallelism" json:"parallelism"`
+	Parameters     map[string]ConfigParameter `yaml:"parameters" json:"parameters"`
+	Environment    map[string]interface{}     `yaml:"environment" json:"environment"`
+	Steps          []interface{}              `yaml:"steps" json:"steps"`
+}
+
+// ParseConfig decodes a source or compiled config.yml
+func ParseConfig(data []byte) (*CompiledConfig, error) {
+	c := new(CompiledConfig)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// JobExecutor resolves the executor of a job, following a named `executor:` reference when present
+func (c *CompiledConfig) JobExecutor(name string) (e ConfigExecutor, ok bool) {
+	job, ok := c.Jobs[name]
+	if !ok {
+		return e, false
+	}
+
+	e = job.ConfigExecutor
+	if ref := executorRefName(job.Executor); ref != "" {
+		if named, found := c.Executors[ref]; found {
+			resourceClass := e.ResourceClass
+			e = named
+			if resourceClass != "" {
+				e.ResourceClass = resourceClass
+			}
+		}
+	}
+
+	return e, true
+}
+
+// executorRefName returns the executor name from either `executor: name` or `executor: {name: name}`
+func executorRefName(ref interface{}) string {
+	switch v := ref.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if name, ok := v["name"]; ok {
+			return fmt.Sprintf("%v", name)
+		}
+	}
+
+	return ""
+}
+
+// isRunnerClass reports whether a resource class belongs to a self-hosted runner (namespace/name)
+func isRunnerClass(resourceClass string) bool {
+	return strings.Contains(resourceClass, "/")
+}
@@ -0,0 +1,90 @@
+package circleci
+
+import "testing"
+
+const testConfig = `
+version: 2.1
+executors:
+  go:
+    docker:
+      - image: cimg/go:1.22
+      - image: cimg/postgres:16.2
+    resource_class: medium
+  mac:
+    macos:
+      xcode: 15.4.0
+jobs:
+  build:
+    executor: go
+  test:
+    executor:
+      name: go
+    resource_class: large
+  integration:
+    machine:
+      image: ubuntu-2204:2024.05.1
+      docker_layer_caching: true
+    resource_class: bldmgr/runner
+  legacy:
+    machine: true
+  ios:
+    executor: mac
+  orphan:
+    executor: missing
+    resource_class: small
+`
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != "2.1" || len(c.Executors) != 2 || len(c.Jobs) != 6 {
+		t.Errorf("parsed version %q, %d executors and %d jobs", c.Version, len(c.Executors), len(c.Jobs))
+	}
+	if m := c.Jobs["integration"].Machine; m == nil || !m.Enabled || m.Image != "ubuntu-2204:2024.05.1" || !m.DockerLayerCaching {
+		t.Errorf("machine map = %+v", m)
+	}
+	if m := c.Jobs["legacy"].Machine; m == nil || !m.Enabled || m.Image != "" {
+		t.Errorf("machine: true = %+v", m)
+	}
+
+	if _, err := ParseConfig([]byte("jobs: [")); err == nil {
+		t.Error("ParseConfig accepted invalid YAML")
+	}
+}
+
+func TestJobExecutor(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		job           string
+		ok            bool
+		executorType  string
+		image         string
+		resourceClass string
+	}{
+		{job: "build", ok: true, executorType: "docker", image: "cimg/go:1.22", resourceClass: "medium"},
+		{job: "test", ok: true, executorType: "docker", image: "cimg/go:1.22", resourceClass: "large"},
+		{job: "integration", ok: true, executorType: "machine", image: "ubuntu-2204:2024.05.1", resourceClass: "bldmgr/runner"},
+		{job: "legacy", ok: true, executorType: "machine"},
+		{job: "ios", ok: true, executorType: "macos", image: "15.4.0"},
+		{job: "orphan", ok: true, resourceClass: "small"},
+		{job: "unknown", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.job, func(t *testing.T) {
+			e, ok := c.JobExecutor(tt.job)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, want %t", ok, tt.ok)
+			}
+			if e.Type() != tt.executorType || e.Image() != tt.image || e.ResourceClass != tt.resourceClass {
+				t.Errorf("executor = %s %q %q, want %s %q %q", e.Type(), e.Image(), e.ResourceClass, tt.executorType, tt.image, tt.resourceClass)
+			}
+		})
+	}
+}
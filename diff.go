@@ -0,0 +1,339 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ConfigChange is one semantic difference between two pipeline configs
+type ConfigChange struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Field  string `json:"field,omitempty"`
+	Change string `json:"change"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// ConfigDiff is the result of DiffPipelineConfigs
+type ConfigDiff struct {
+	PipelineA string         `json:"pipeline_a"`
+	PipelineB string         `json:"pipeline_b"`
+	Changes   []ConfigChange `json:"changes"`
+}
+
+func (d ConfigDiff) String() string {
+	var sb strings.Builder
+	for _, c := range d.Changes {
+		target := c.Target
+		if c.Field != "" {
+			target += "." + c.Field
+		}
+		switch c.Change {
+		case ChangeAdded:
+			fmt.Fprintf(&sb, "+ %s %s: %s\n", c.Kind, target, c.After)
+		case ChangeRemoved:
+			fmt.Fprintf(&sb, "- %s %s: %s\n", c.Kind, target, c.Before)
+		default:
+			fmt.Fprintf(&sb, "~ %s %s: %s -> %s\n", c.Kind, target, c.Before, c.After)
+		}
+	}
+
+	return sb.String()
+}
+
+// DiffPipelineConfigs compares the configs of two pipelines and reports jobs added or removed,
+// step changes, executor changes, orb versions and parameter defaults
+func DiffPipelineConfigs(ctx context.Context, ci CI, pipelineA string, pipelineB string) (diff ConfigDiff, err error) {
+	a, err := fetchPipelineConfig(ctx, ci, pipelineA)
+	if err != nil {
+		return diff, err
+	}
+	b, err := fetchPipelineConfig(ctx, ci, pipelineB)
+	if err != nil {
+		return diff, err
+	}
+
+	diff, err = DiffConfigs(a, b)
+	diff.PipelineA = pipelineA
+	diff.PipelineB = pipelineB
+
+	return diff, err
+}
+
+// DiffConfigs compares two already fetched pipeline configs
+func DiffConfigs(a PipelineConfig, b PipelineConfig) (diff ConfigDiff, err error) {
+	diff.Changes = make([]ConfigChange, 0)
+
+	compiledA, err := ParseConfig([]byte(a.Compiled))
+	if err != nil {
+		return diff, fmt.Errorf("compiled config a: %w", err)
+	}
+	compiledB, err := ParseConfig([]byte(b.Compiled))
+	if err != nil {
+		return diff, fmt.Errorf("compiled config b: %w", err)
+	}
+	// orbs and pipeline parameters are only kept in the source config
+	sourceA, err := ParseConfig([]byte(a.Source))
+	if err != nil {
+		return diff, fmt.Errorf("source config a: %w", err)
+	}
+	sourceB, err := ParseConfig([]byte(b.Source))
+	if err != nil {
+		return diff, fmt.Errorf("source config b: %w", err)
+	}
+
+	diff.Changes = append(diff.Changes, diffOrbs(sourceA.Orbs, sourceB.Orbs)...)
+	diff.Changes = append(diff.Changes, diffParameters("parameter", "pipeline", sourceA.Parameters, sourceB.Parameters)...)
+	diff.Changes = append(diff.Changes, diffJobs(compiledA, compiledB)...)
+
+	return diff, nil
+}
+
+func diffOrbs(a map[string]interface{}, b map[string]interface{}) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	for _, alias := range unionKeys(a, b) {
+		before, after := orbRefString(a[alias]), orbRefString(b[alias])
+		if c, ok := compareValue("orb", alias, "", before, after, a[alias] != nil, b[alias] != nil); ok {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+// orbRefString renders an orb reference, inline orb definitions are reported as embedded
+func orbRefString(v interface{}) string {
+	switch o := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return o
+	}
+
+	return "embedded"
+}
+
+func diffParameters(kind string, target string, a map[string]ConfigParameter, b map[string]ConfigParameter) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	for _, name := range unionKeys(a, b) {
+		pa, inA := a[name]
+		pb, inB := b[name]
+		if !inA || !inB {
+			if c, ok := compareValue(kind, target, name, parameterString(pa), parameterString(pb), inA, inB); ok {
+				changes = append(changes, c)
+			}
+			continue
+		}
+		if c, ok := compareValue(kind, target, name+".default", fmt.Sprint(pa.Default), fmt.Sprint(pb.Default), true, true); ok {
+			changes = append(changes, c)
+		}
+		if c, ok := compareValue(kind, target, name+".type", pa.Type, pb.Type, true, true); ok {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+func parameterString(p ConfigParameter) string {
+	if p.Type == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s (default %v)", p.Type, p.Default)
+}
+
+func diffJobs(a *CompiledConfig, b *CompiledConfig) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	for _, name := range unionKeys(a.Jobs, b.Jobs) {
+		ja, inA := a.Jobs[name]
+		jb, inB := b.Jobs[name]
+		if !inA {
+			changes = append(changes, ConfigChange{Kind: "job", Target: name, Change: ChangeAdded, After: fmt.Sprintf("%d steps", len(jb.Steps))})
+			continue
+		}
+		if !inB {
+			changes = append(changes, ConfigChange{Kind: "job", Target: name, Change: ChangeRemoved, Before: fmt.Sprintf("%d steps", len(ja.Steps))})
+			continue
+		}
+
+		ea, _ := a.JobExecutor(name)
+		eb, _ := b.JobExecutor(name)
+		fields := []struct {
+			field, before, after string
+		}{
+			{"executor", ea.Type(), eb.Type()},
+			{"image", ea.Image(), eb.Image()},
+			{"resource_class", ea.ResourceClass, eb.ResourceClass},
+			{"parallelism", ja.Parallelism, jb.Parallelism},
+		}
+		for _, f := range fields {
+			if c, ok := compareValue("executor", name, f.field, f.before, f.after, true, true); ok {
+				changes = append(changes, c)
+			}
+		}
+		changes = append(changes, diffParameters("job_parameter", name, ja.Parameters, jb.Parameters)...)
+		changes = append(changes, diffSteps(name, ja.Steps, jb.Steps)...)
+	}
+
+	return changes
+}
+
+// diffSteps aligns two step lists on their longest common subsequence.
+// A removal directly followed by an addition at the same position is reported as a change.
+func diffSteps(job string, a []interface{}, b []interface{}) []ConfigChange {
+	ka := make([]string, len(a))
+	kb := make([]string, len(b))
+	for i := range a {
+		ka[i] = stepKey(a[i])
+	}
+	for i := range b {
+		kb[i] = stepKey(b[i])
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if ka[i] == kb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	changes := make([]ConfigChange, 0)
+	removed := make([]int, 0)
+	added := make([]int, 0)
+	flush := func() {
+		n := 0
+		for ; n < len(removed) && n < len(added); n++ {
+			changes = append(changes, ConfigChange{
+				Kind:   "step",
+				Target: job,
+				Field:  fmt.Sprintf("steps[%d]", added[n]),
+				Change: ChangeChanged,
+				Before: StepLabel(a[removed[n]]),
+				After:  StepLabel(b[added[n]]),
+			})
+		}
+		for _, i := range removed[n:] {
+			changes = append(changes, ConfigChange{Kind: "step", Target: job, Field: fmt.Sprintf("steps[%d]", i), Change: ChangeRemoved, Before: StepLabel(a[i])})
+		}
+		for _, j := range added[n:] {
+			changes = append(changes, ConfigChange{Kind: "step", Target: job, Field: fmt.Sprintf("steps[%d]", j), Change: ChangeAdded, After: StepLabel(b[j])})
+		}
+		removed = removed[:0]
+		added = added[:0]
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && ka[i] == kb[j]:
+			flush()
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, j)
+			j++
+		default:
+			removed = append(removed, i)
+			i++
+		}
+	}
+	flush()
+
+	return changes
+}
+
+// stepKey returns a canonical representation of a step used for comparison
+func stepKey(step interface{}) string {
+	out, err := json.Marshal(step)
+	if err != nil {
+		return fmt.Sprint(step)
+	}
+
+	return string(out)
+}
+
+// StepLabel returns a short human readable description of a config step,
+// e.g. "checkout", "run: make test" or "save_cache: v1-deps"
+func StepLabel(step interface{}) string {
+	switch v := step.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		for kind, value := range v {
+			switch d := value.(type) {
+			case string:
+				return fmt.Sprintf("%s: %s", kind, firstLine(d))
+			case map[string]interface{}:
+				for _, key := range []string{"name", "command", "key", "path"} {
+					if s, ok := d[key]; ok {
+						return fmt.Sprintf("%s: %s", kind, firstLine(fmt.Sprint(s)))
+					}
+				}
+			}
+			return kind
+		}
+	}
+
+	return fmt.Sprint(step)
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+
+	return s
+}
+
+func compareValue(kind string, target string, field string, before string, after string, inA bool, inB bool) (ConfigChange, bool) {
+	c := ConfigChange{Kind: kind, Target: target, Field: field, Before: before, After: after}
+	switch {
+	case !inA && inB:
+		c.Change = ChangeAdded
+	case inA && !inB:
+		c.Change = ChangeRemoved
+	case before != after:
+		c.Change = ChangeChanged
+	default:
+		return c, false
+	}
+
+	return c, true
+}
+
+// unionKeys returns the sorted keys present in either map
+func unionKeys[V any](a map[string]V, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
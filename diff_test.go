@@ -0,0 +1,185 @@
+package circleci
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSteps(t *testing.T) {
+	checkout := "checkout"
+	build := map[string]interface{}{"run": map[string]interface{}{"name": "build", "command": "make build"}}
+	test := map[string]interface{}{"run": map[string]interface{}{"name": "test", "command": "make test"}}
+	lint := map[string]interface{}{"run": "make lint"}
+	cache := map[string]interface{}{"save_cache": map[string]interface{}{"key": "v1-deps", "paths": []interface{}{"vendor"}}}
+
+	tests := []struct {
+		name string
+		a    []interface{}
+		b    []interface{}
+		want []ConfigChange
+	}{
+		{
+			name: "identical",
+			a:    []interface{}{checkout, build},
+			b:    []interface{}{checkout, build},
+			want: []ConfigChange{},
+		},
+		{
+			name: "added",
+			a:    []interface{}{checkout, test},
+			b:    []interface{}{checkout, build, test},
+			want: []ConfigChange{
+				{Kind: "step", Target: "job", Field: "steps[1]", Change: ChangeAdded, After: "run: build"},
+			},
+		},
+		{
+			name: "added at the end",
+			a:    []interface{}{checkout},
+			b:    []interface{}{checkout, build, cache},
+			want: []ConfigChange{
+				{Kind: "step", Target: "job", Field: "steps[1]", Change: ChangeAdded, After: "run: build"},
+				{Kind: "step", Target: "job", Field: "steps[2]", Change: ChangeAdded, After: "save_cache: v1-deps"},
+			},
+		},
+		{
+			name: "removed",
+			a:    []interface{}{checkout, lint, test},
+			b:    []interface{}{checkout, test},
+			want: []ConfigChange{
+				{Kind: "step", Target: "job", Field: "steps[1]", Change: ChangeRemoved, Before: "run: make lint"},
+			},
+		},
+		{
+			name: "changed in place",
+			a:    []interface{}{checkout, lint, test},
+			b:    []interface{}{checkout, build, test},
+			want: []ConfigChange{
+				{Kind: "step", Target: "job", Field: "steps[1]", Change: ChangeChanged, Before: "run: make lint", After: "run: build"},
+			},
+		},
+		{
+			name: "reordered",
+			a:    []interface{}{checkout, build, test},
+			b:    []interface{}{checkout, test, build},
+			want: []ConfigChange{
+				// the moved step shows up as added at its new position and removed from its old one
+				{Kind: "step", Target: "job", Field: "steps[1]", Change: ChangeAdded, After: "run: test"},
+				{Kind: "step", Target: "job", Field: "steps[2]", Change: ChangeRemoved, Before: "run: test"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffSteps("job", tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffSteps =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const diffSourceA = `version: 2.1
+orbs:
+  node: circleci/node@5.0.2
+parameters:
+  deploy:
+    type: boolean
+    default: false
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.21
+    steps:
+      - checkout
+`
+
+const diffSourceB = `version: 2.1
+orbs:
+  node: circleci/node@5.1.0
+  slack: circleci/slack@4.12.5
+parameters:
+  deploy:
+    type: boolean
+    default: true
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.22
+    steps:
+      - checkout
+`
+
+const diffCompiledA = `version: 2
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.21
+    resource_class: medium
+    steps:
+      - checkout
+      - run:
+          name: test
+          command: go test ./...
+  lint:
+    docker:
+      - image: cimg/go:1.21
+    steps:
+      - checkout
+`
+
+const diffCompiledB = `version: 2
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.22
+    resource_class: large
+    steps:
+      - checkout
+      - run:
+          name: test
+          command: go test ./...
+      - store_test_results:
+          path: results
+  deploy:
+    machine:
+      image: ubuntu-2204:2023.07.2
+    steps:
+      - checkout
+`
+
+func TestDiffPipelineConfigs(t *testing.T) {
+	config := func(source string, compiled string) string {
+		return `{"source":` + jsonString(source) + `,"compiled":` + jsonString(compiled) + `}`
+	}
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline/a/config": config(diffSourceA, diffCompiledA),
+		"api/v2/pipeline/b/config": config(diffSourceB, diffCompiledB),
+	}}
+
+	diff, err := DiffPipelineConfigs(context.Background(), ci, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfigChange{
+		{Kind: "orb", Target: "node", Change: ChangeChanged, Before: "circleci/node@5.0.2", After: "circleci/node@5.1.0"},
+		{Kind: "orb", Target: "slack", Change: ChangeAdded, After: "circleci/slack@4.12.5"},
+		{Kind: "parameter", Target: "pipeline", Field: "deploy.default", Change: ChangeChanged, Before: "false", After: "true"},
+		{Kind: "executor", Target: "build", Field: "image", Change: ChangeChanged, Before: "cimg/go:1.21", After: "cimg/go:1.22"},
+		{Kind: "executor", Target: "build", Field: "resource_class", Change: ChangeChanged, Before: "medium", After: "large"},
+		{Kind: "step", Target: "build", Field: "steps[2]", Change: ChangeAdded, After: "store_test_results: results"},
+		{Kind: "job", Target: "deploy", Change: ChangeAdded, After: "1 steps"},
+		{Kind: "job", Target: "lint", Change: ChangeRemoved, Before: "1 steps"},
+	}
+	if diff.PipelineA != "a" || diff.PipelineB != "b" {
+		t.Errorf("pipelines = %q, %q", diff.PipelineA, diff.PipelineB)
+	}
+	if !reflect.DeepEqual(diff.Changes, want) {
+		t.Errorf("changes =\n%s", diff)
+	}
+
+	if _, err := DiffPipelineConfigs(context.Background(), ci, "a", "missing"); err == nil {
+		t.Error("expected an error for a pipeline without config")
+	}
+}
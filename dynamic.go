@@ -0,0 +1,253 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PhaseSetup     = "setup"
+	PhaseContinued = "continued"
+)
+
+// ContinuationParameter is a pipeline parameter handed from a setup workflow to the continued config
+type ContinuationParameter struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"`
+	Value   interface{} `json:"value,omitempty"`
+	Source  string      `json:"source"`
+	Mapping string      `json:"mapping,omitempty"`
+}
+
+// DynamicWorkflow is a workflow of the pipeline tagged with the config it came from
+type DynamicWorkflow struct {
+	PipelineWorkflows
+	Phase string `json:"phase"`
+}
+
+// DynamicConfig describes a setup (dynamic config) pipeline and the config it continued with
+type DynamicConfig struct {
+	PipelineID        string                  `json:"pipeline_id"`
+	Dynamic           bool                    `json:"dynamic"`
+	SetupSource       string                  `json:"setup_source"`
+	SetupCompiled     string                  `json:"setup_compiled"`
+	ContinuedSource   string                  `json:"continued_source"`
+	ContinuedCompiled string                  `json:"continued_compiled"`
+	ConfigurationPath string                  `json:"configuration_path,omitempty"`
+	Parameters        []ContinuationParameter `json:"parameters"`
+	Workflows         []DynamicWorkflow       `json:"workflows"`
+	Setup             *CompiledConfig         `json:"-"`
+	Continued         *CompiledConfig         `json:"-"`
+}
+
+// GetDynamicConfig fetches the config of a pipeline and splits it into its setup and continued parts.
+// Pipelines that do not use dynamic config are returned with Dynamic false and only the continued config set.
+func GetDynamicConfig(ci CI, pipelineId string, output string) (d DynamicConfig) {
+	p, err := fetchPipelineConfig(context.Background(), ci, pipelineId)
+	if err != nil {
+		fmt.Printf("could not read items from response: %v", err)
+	}
+
+	d = NewDynamicConfig(pipelineId, p)
+	for _, w := range GetPipelineWorkflows(ci, pipelineId, "none") {
+		d.Workflows = append(d.Workflows, DynamicWorkflow{PipelineWorkflows: w, Phase: d.WorkflowPhase(w.Name)})
+	}
+
+	if output == "json" {
+		out, err := json.Marshal(d)
+		if err != nil {
+			fmt.Printf("could not marshal dynamic config: %v", err)
+		}
+		fmt.Printf(string(out) + "\n")
+	}
+
+	if output == "status" {
+		fmt.Printf("Pipeline Id: %s dynamic -> %t \n", pipelineId, d.Dynamic)
+		for _, w := range d.Workflows {
+			fmt.Printf("%s: %s -> %s \n", w.Phase, w.Name, w.Status)
+		}
+		for _, param := range d.Parameters {
+			fmt.Printf("parameter %s = %v (%s)\n", param.Name, param.Value, param.Source)
+		}
+	}
+
+	return d
+}
+
+// NewDynamicConfig builds a DynamicConfig from an already fetched PipelineConfig
+func NewDynamicConfig(pipelineId string, p PipelineConfig) (d DynamicConfig) {
+	d = DynamicConfig{
+		PipelineID:        pipelineId,
+		Dynamic:           p.SetupConfig != "",
+		SetupSource:       p.SetupConfig,
+		SetupCompiled:     p.CompiledSetupConfig,
+		ContinuedSource:   p.Source,
+		ContinuedCompiled: p.Compiled,
+		Parameters:        make([]ContinuationParameter, 0),
+		Workflows:         make([]DynamicWorkflow, 0),
+	}
+
+	if c, err := ParseConfig([]byte(p.Compiled)); err == nil {
+		d.Continued = c
+	}
+	if !d.Dynamic {
+		return d
+	}
+	if c, err := ParseConfig([]byte(p.CompiledSetupConfig)); err == nil {
+		d.Setup = c
+	}
+
+	values, mappings, configurationPath := continuationArguments([]byte(p.SetupConfig))
+	d.ConfigurationPath = configurationPath
+
+	declared := make(map[string]ConfigParameter)
+	if source, err := ParseConfig([]byte(p.Source)); err == nil {
+		declared = source.Parameters
+	}
+	for _, name := range sortedKeys(declared) {
+		param := ContinuationParameter{
+			Name:    name,
+			Type:    declared[name].Type,
+			Default: declared[name].Default,
+			Source:  "default",
+		}
+		if v, ok := values[name]; ok {
+			param.Value = v
+			param.Source = "continuation"
+		} else if m, ok := mappings[name]; ok {
+			param.Mapping = m
+			param.Source = "path-filtering"
+		}
+		d.Parameters = append(d.Parameters, param)
+	}
+
+	return d
+}
+
+// WorkflowPhase reports whether a workflow belongs to the setup or the continued config
+func (d DynamicConfig) WorkflowPhase(name string) string {
+	if d.Setup != nil {
+		if _, ok := d.Setup.Workflows[name]; ok {
+			return PhaseSetup
+		}
+	}
+
+	return PhaseContinued
+}
+
+// ResolveJob traces a job of the pipeline back to the generated config it was defined in
+func (d DynamicConfig) ResolveJob(name string) (job ResolvedJob, phase string, ok bool) {
+	if d.Continued != nil {
+		if job, ok = d.Continued.ResolveJob(name); ok {
+			return job, PhaseContinued, true
+		}
+	}
+	if d.Setup != nil {
+		if job, ok = d.Setup.ResolveJob(name); ok {
+			return job, PhaseSetup, true
+		}
+	}
+
+	return job, "", false
+}
+
+// continuationArguments scans the setup config for continuation/continue and path-filtering/filter
+// steps and returns the literal parameters they pass, the path-filtering mappings and the continued config path
+func continuationArguments(setup []byte) (values map[string]interface{}, mappings map[string]string, configurationPath string) {
+	values = make(map[string]interface{})
+	mappings = make(map[string]string)
+
+	var raw struct {
+		Jobs map[string]struct {
+			Steps []interface{} `yaml:"steps"`
+		} `yaml:"jobs"`
+		Workflows map[string]interface{} `yaml:"workflows"`
+	}
+	if err := yaml.Unmarshal(setup, &raw); err != nil {
+		return values, mappings, ""
+	}
+
+	args := make([]map[string]interface{}, 0)
+	for _, job := range raw.Jobs {
+		for _, step := range job.Steps {
+			if m, ok := step.(map[string]interface{}); ok {
+				for kind, v := range m {
+					if a, ok := v.(map[string]interface{}); ok && isContinuationStep(kind) {
+						args = append(args, a)
+					}
+				}
+			}
+		}
+	}
+	// orb jobs such as path-filtering/filter are invoked directly from the workflow
+	for _, w := range raw.Workflows {
+		wm, ok := w.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		jobs, _ := wm["jobs"].([]interface{})
+		for _, j := range jobs {
+			if m, ok := j.(map[string]interface{}); ok {
+				for kind, v := range m {
+					if a, ok := v.(map[string]interface{}); ok && isContinuationStep(kind) {
+						args = append(args, a)
+					}
+				}
+			}
+		}
+	}
+
+	for _, a := range args {
+		for _, key := range []string{"configuration_path", "config-path"} {
+			if v, ok := a[key].(string); ok && configurationPath == "" {
+				configurationPath = v
+			}
+		}
+		if s, ok := a["parameters"].(string); ok {
+			var parsed map[string]interface{}
+			if json.Unmarshal([]byte(s), &parsed) == nil {
+				for k, v := range parsed {
+					values[k] = v
+				}
+			}
+		}
+		if s, ok := a["mapping"].(string); ok {
+			// each line is "<regex> <parameter> <value> [config]"
+			for _, line := range strings.Split(s, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) >= 3 {
+					mappings[fields[1]] = strings.TrimSpace(line)
+				}
+			}
+		}
+	}
+
+	return values, mappings, configurationPath
+}
+
+func isContinuationStep(kind string) bool {
+	return strings.HasSuffix(kind, "/continue") || strings.HasSuffix(kind, "/filter") || strings.HasSuffix(kind, "/generate-config")
+}
+
+// selectCompiledConfig returns the compiled config a job was defined in, falling back to the
+// compiled setup config for the jobs of a dynamic config pipeline's setup workflow
+func selectCompiledConfig(p PipelineConfig, jobName string) []byte {
+	if p.CompiledSetupConfig == "" {
+		return []byte(p.Compiled)
+	}
+	if _, phase, ok := NewDynamicConfig("", p).ResolveJob(jobName); ok && phase == PhaseSetup {
+		return []byte(p.CompiledSetupConfig)
+	}
+
+	return []byte(p.Compiled)
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	return unionKeys(m, nil)
+}
@@ -0,0 +1,133 @@
+package circleci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContinuationArguments(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    string
+		values   map[string]interface{}
+		mappings map[string]string
+		path     string
+	}{
+		{name: "invalid", setup: "jobs: [", values: map[string]interface{}{}, mappings: map[string]string{}},
+		{
+			name: "continuation step",
+			setup: `
+jobs:
+  setup:
+    steps:
+      - checkout
+      - run: ./generate.sh
+      - continuation/continue:
+          configuration_path: .circleci/generated.yml
+          parameters: '{"deploy": true, "region": "us-east-1"}'
+`,
+			values:   map[string]interface{}{"deploy": true, "region": "us-east-1"},
+			mappings: map[string]string{},
+			path:     ".circleci/generated.yml",
+		},
+		{
+			name: "path filtering job",
+			setup: `
+workflows:
+  setup:
+    jobs:
+      - path-filtering/filter:
+          base-revision: main
+          config-path: .circleci/continue.yml
+          mapping: |
+            api/.* run-api true
+            web/.* run-web true .circleci/web.yml
+            incomplete
+`,
+			values: map[string]interface{}{},
+			mappings: map[string]string{
+				"run-api": "api/.* run-api true",
+				"run-web": "web/.* run-web true .circleci/web.yml",
+			},
+			path: ".circleci/continue.yml",
+		},
+		{
+			name: "parameters that are not JSON",
+			setup: `
+jobs:
+  setup:
+    steps:
+      - continuation/continue:
+          configuration_path: generated.yml
+          parameters: << pipeline.parameters.args >>
+      - other/continuation:
+          configuration_path: ignored.yml
+`,
+			values:   map[string]interface{}{},
+			mappings: map[string]string{},
+			path:     "generated.yml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, mappings, path := continuationArguments([]byte(tt.setup))
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("values = %v, want %v", values, tt.values)
+			}
+			if !reflect.DeepEqual(mappings, tt.mappings) {
+				t.Errorf("mappings = %v, want %v", mappings, tt.mappings)
+			}
+			if path != tt.path {
+				t.Errorf("configuration path = %q, want %q", path, tt.path)
+			}
+		})
+	}
+}
+
+func TestSelectCompiledConfig(t *testing.T) {
+	setup := `
+version: 2.1
+setup: true
+jobs:
+  generate:
+    docker:
+      - image: cimg/base:current
+workflows:
+  setup:
+    jobs:
+      - generate
+`
+	continued := `
+version: 2.1
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.22
+workflows:
+  ci:
+    jobs:
+      - build
+`
+	dynamic := PipelineConfig{Compiled: continued, CompiledSetupConfig: setup, SetupConfig: setup}
+
+	tests := []struct {
+		name   string
+		config PipelineConfig
+		job    string
+		want   string
+	}{
+		{name: "static config", config: PipelineConfig{Compiled: continued}, job: "generate", want: continued},
+		{name: "setup job", config: dynamic, job: "generate", want: setup},
+		{name: "continued job", config: dynamic, job: "build", want: continued},
+		{name: "unknown job", config: dynamic, job: "missing", want: continued},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(selectCompiledConfig(tt.config, tt.job)); got != tt.want {
+				t.Errorf("selectCompiledConfig(%q) = %q, want %q", tt.job, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bldmgr/circleci/pkg/logs"
+)
+
+const (
+	restContexts         = "api/v2/context?owner-slug=%s"
+	restContextVariables = "api/v2/context/%s/environment-variable"
+)
+
+// JobEnvironment is the environment one job ran with: the values of the built-in CIRCLE_* variables
+// and the names of the project and context variables injected into it
+type JobEnvironment struct {
+	Job      JobRef            `json:"job"`
+	Name     string            `json:"name"`
+	Workflow string            `json:"workflow"`
+	Contexts []string          `json:"contexts"`
+	Injected []string          `json:"injected"`
+	BuiltIn  map[string]string `json:"built_in"`
+}
+
+// ContextExposure lists the variable names a context exposed and the jobs that received them.
+// Confirmed is false when the variables of the context could not be listed, Variables then holds
+// every name its jobs received that is neither a project variable nor held by another listed context
+// of the job: possible exposures, not certain ones.
+type ContextExposure struct {
+	Context   string   `json:"context"`
+	Variables []string `json:"variables"`
+	Jobs      []string `json:"jobs"`
+	Confirmed bool     `json:"confirmed"`
+}
+
+// EnvironmentAudit reports which variable names reached which jobs of a pipeline
+type EnvironmentAudit struct {
+	PipelineID string            `json:"pipeline_id"`
+	Jobs       []JobEnvironment  `json:"jobs"`
+	Project    []string          `json:"project"`
+	Contexts   []ContextExposure `json:"contexts"`
+	Errors     []ScanError       `json:"errors"`
+}
+
+// GetJobEnvironment parses the "Preparing environment variables" step of a job
+func GetJobEnvironment(ctx context.Context, ci CI, job JobRef) (logs.Environment, error) {
+	for _, step := range GetBuildDetails(ci, job, "none").Steps {
+		action, ok := nodeAction(step, 0)
+		if !ok || action.Name != StepPrepareEnv {
+			continue
+		}
+		r, err := OpenStepOutput(ctx, ci, job, strconv.Itoa(action.Step), action.Index)
+		if err != nil {
+			return logs.Environment{}, err
+		}
+		defer r.Close()
+
+		return logs.ParseEnvironment(r)
+	}
+
+	return logs.Environment{}, fmt.Errorf("job %d has no %q step", job.JobNumber, StepPrepareEnv)
+}
+
+// GetContextVariables returns the variable names of every context of an owner such as gh/bldmgr,
+// keyed by context name. Listing them needs a token allowed to read the contexts. Contexts whose
+// variables could not be listed are left out and reported in the error next to the others.
+func GetContextVariables(ctx context.Context, ci CI, ownerSlug string) (map[string][]string, error) {
+	type item struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Variable string `json:"variable"`
+	}
+	list := func(url string) ([]item, error) {
+		items := make([]item, 0)
+		pageToken := ""
+		for {
+			pageURL := url
+			if pageToken != "" {
+				pageURL = withPageToken(url, pageToken)
+			}
+			body, resp, err := getWithContext(ctx, ci, pageURL)
+			if err != nil {
+				return nil, err
+			}
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("%s: %s", pageURL, resp.Status)
+			}
+			var page struct {
+				Items         []item `json:"items"`
+				NextPageToken string `json:"next_page_token"`
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return nil, err
+			}
+			items = append(items, page.Items...)
+			if page.NextPageToken == "" {
+				return items, nil
+			}
+			pageToken = page.NextPageToken
+		}
+	}
+
+	contexts, err := list(fmt.Sprintf(restContexts, neturl.QueryEscape(ownerSlug)))
+	if err != nil {
+		return nil, err
+	}
+	variables := make(map[string][]string, len(contexts))
+	errs := make([]error, 0)
+	for _, c := range contexts {
+		items, err := list(fmt.Sprintf(restContextVariables, c.ID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("context %s: %w", c.Name, err))
+			continue
+		}
+		names := make([]string, 0, len(items))
+		for _, v := range items {
+			names = append(names, v.Variable)
+		}
+		sort.Strings(names)
+		variables[c.Name] = names
+	}
+
+	return variables, errors.Join(errs...)
+}
+
+// GetEnvironmentAudit collects the environment of every job of a pipeline. The step output does not say
+// which context a variable came from, so the variables of each context are listed with
+// GetContextVariables and a name is only attributed to a context holding it. When a context cannot be
+// listed its exposure is reported unconfirmed, holding every name of its jobs that is neither a project
+// variable nor held by another context of the job.
+func GetEnvironmentAudit(ctx context.Context, ci CI, pipelineId string, output string) EnvironmentAudit {
+	audit := EnvironmentAudit{
+		PipelineID: pipelineId,
+		Jobs:       make([]JobEnvironment, 0),
+		Project:    make([]string, 0),
+		Contexts:   make([]ContextExposure, 0),
+		Errors:     make([]ScanError, 0),
+	}
+
+	for _, workflow := range GetPipelineWorkflows(ci, pipelineId, "none") {
+		for _, job := range GetWorkflowJob(ci, workflow.ID, "none", "", "") {
+			if job.JobNumber == 0 {
+				// approval jobs do not run
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				audit.Errors = append(audit.Errors, ScanError{ProjectSlug: job.ProjectSlug, PipelineID: pipelineId, Message: err.Error()})
+				return audit
+			}
+			env, err := GetJobEnvironment(ctx, ci, job.Ref())
+			if err != nil {
+				audit.Errors = append(audit.Errors, ScanError{ProjectSlug: job.ProjectSlug, PipelineID: pipelineId, Message: err.Error()})
+				continue
+			}
+
+			contexts := make([]string, 0)
+			for _, c := range GetJobDetailsRef(ci, job.Ref()).Contexts {
+				contexts = append(contexts, c.Name)
+			}
+			sort.Strings(contexts)
+
+			audit.Jobs = append(audit.Jobs, JobEnvironment{
+				Job:      job.Ref(),
+				Name:     job.Name,
+				Workflow: workflow.Name,
+				Contexts: contexts,
+				Injected: env.Injected,
+				BuiltIn:  env.Circle(),
+			})
+		}
+	}
+	members := make(map[string][]string)
+	owners := make(map[string]bool)
+	for _, j := range audit.Jobs {
+		if len(j.Contexts) == 0 {
+			continue
+		}
+		owner := ownerSlug(j.Job.ProjectSlug)
+		if owners[owner] {
+			continue
+		}
+		owners[owner] = true
+		// the contexts that were listed stay confirmed when others fail
+		variables, err := GetContextVariables(ctx, ci, owner)
+		if err != nil {
+			audit.Errors = append(audit.Errors, ScanError{ProjectSlug: j.Job.ProjectSlug, PipelineID: pipelineId, Message: err.Error()})
+		}
+		for c, names := range variables {
+			members[c] = names
+		}
+	}
+	audit.Project, audit.Contexts = attributeVariables(audit.Jobs, members)
+
+	if output == "json" {
+		out, err := json.Marshal(audit)
+		if err != nil {
+			fmt.Printf("could not marshal environment audit: %v", err)
+		}
+		fmt.Printf(string(out) + "\n")
+	}
+
+	if output == "status" {
+		for _, c := range audit.Contexts {
+			possible := ""
+			if !c.Confirmed {
+				possible = " (possible)"
+			}
+			fmt.Printf("%s -> %v in %v%s\n", c.Context, c.Variables, c.Jobs, possible)
+		}
+	}
+
+	return audit
+}
+
+// attributeVariables splits injected names into project variables and per context exposures.
+// members holds the variable names of the contexts that could be listed.
+func attributeVariables(jobs []JobEnvironment, members map[string][]string) (project []string, exposures []ContextExposure) {
+	inContext := func(c string, name string) (found bool, known bool) {
+		names, known := members[c]
+		if !known {
+			return false, false
+		}
+		i := sort.SearchStrings(names, name)
+		return i < len(names) && names[i] == name, true
+	}
+
+	// a name is a project variable when a job without contexts received it, or when every context
+	// of the job receiving it is known and none holds it
+	projectNames := make(map[string]bool)
+	for _, j := range jobs {
+		for _, name := range j.Injected {
+			fromContext := false
+			for _, c := range j.Contexts {
+				if found, known := inContext(c, name); found || !known {
+					fromContext = true
+				}
+			}
+			if !fromContext {
+				projectNames[name] = true
+			}
+		}
+	}
+
+	// names a listed context of the job holds are not possible exposures of its unlisted contexts
+	confirmed := func(j JobEnvironment, name string) bool {
+		for _, c := range j.Contexts {
+			if found, _ := inContext(c, name); found {
+				return true
+			}
+		}
+		return false
+	}
+
+	variables := make(map[string][]string)
+	jobNames := make(map[string][]string)
+	for _, j := range jobs {
+		for _, c := range j.Contexts {
+			if _, ok := variables[c]; !ok {
+				variables[c] = make([]string, 0)
+				jobNames[c] = make([]string, 0)
+			}
+			jobNames[c] = appendUniqueString(jobNames[c], j.Name)
+			for _, name := range j.Injected {
+				found, known := inContext(c, name)
+				if found || (!known && !projectNames[name] && !confirmed(j, name)) {
+					variables[c] = appendUniqueString(variables[c], name)
+				}
+			}
+		}
+	}
+
+	project = sortedKeys(projectNames)
+	exposures = make([]ContextExposure, 0, len(variables))
+	for _, c := range sortedKeys(variables) {
+		sort.Strings(variables[c])
+		sort.Strings(jobNames[c])
+		_, known := members[c]
+		exposures = append(exposures, ContextExposure{Context: c, Variables: variables[c], Jobs: jobNames[c], Confirmed: known})
+	}
+
+	return project, exposures
+}
+
+// ownerSlug returns the owner part of a project slug, gh/bldmgr for gh/bldmgr/circleci
+func ownerSlug(projectSlug string) string {
+	parts := strings.SplitN(projectSlug, "/", 3)
+	if len(parts) < 2 {
+		return projectSlug
+	}
+
+	return parts[0] + "/" + parts[1]
+}
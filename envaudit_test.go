@@ -0,0 +1,72 @@
+package circleci
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAttributeVariables(t *testing.T) {
+	jobs := []JobEnvironment{
+		{Name: "lint", Injected: []string{"GITHUB_TOKEN"}},
+		{Name: "build", Contexts: []string{"aws", "npm"}, Injected: []string{"AWS_ACCESS_KEY_ID", "GITHUB_TOKEN", "NPM_TOKEN", "SENTRY_DSN"}},
+		{Name: "deploy", Contexts: []string{"aws", "slack"}, Injected: []string{"AWS_ACCESS_KEY_ID", "SLACK_WEBHOOK"}},
+	}
+	// slack could not be listed
+	members := map[string][]string{
+		"aws": {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"},
+		"npm": {"NPM_TOKEN"},
+	}
+
+	project, exposures := attributeVariables(jobs, members)
+
+	// SENTRY_DSN reached build through no context of it, so it is a project variable
+	if want := []string{"GITHUB_TOKEN", "SENTRY_DSN"}; !reflect.DeepEqual(project, want) {
+		t.Errorf("project = %v, want %v", project, want)
+	}
+	want := []ContextExposure{
+		{Context: "aws", Variables: []string{"AWS_ACCESS_KEY_ID"}, Jobs: []string{"build", "deploy"}, Confirmed: true},
+		{Context: "npm", Variables: []string{"NPM_TOKEN"}, Jobs: []string{"build"}, Confirmed: true},
+		// AWS_ACCESS_KEY_ID came from aws, only SLACK_WEBHOOK is left for the unlisted context
+		{Context: "slack", Variables: []string{"SLACK_WEBHOOK"}, Jobs: []string{"deploy"}, Confirmed: false},
+	}
+	if !reflect.DeepEqual(exposures, want) {
+		t.Errorf("exposures = %+v, want %+v", exposures, want)
+	}
+}
+
+func TestGetContextVariables(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/context?owner-slug=gh%2Fbldmgr":                `{"items":[{"id":"c1","name":"aws"}],"next_page_token":"p2"}`,
+		"api/v2/context?owner-slug=gh%2Fbldmgr&page-token=p2":  `{"items":[{"id":"c2","name":"npm"}]}`,
+		"api/v2/context/c1/environment-variable":               `{"items":[{"variable":"AWS_SECRET_ACCESS_KEY"}],"next_page_token":"v2"}`,
+		"api/v2/context/c1/environment-variable?page-token=v2": `{"items":[{"variable":"AWS_ACCESS_KEY_ID"}]}`,
+		"api/v2/context/c2/environment-variable":               `{"items":[{"variable":"NPM_TOKEN"}]}`,
+	}}
+
+	got, err := GetContextVariables(context.Background(), ci, "gh/bldmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"aws": {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"},
+		"npm": {"NPM_TOKEN"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetContextVariables = %v, want %v", got, want)
+	}
+
+	if _, err := GetContextVariables(context.Background(), ci, "gh/other"); err == nil {
+		t.Error("expected an error for an owner whose contexts cannot be listed")
+	}
+
+	// the variables of c3 cannot be listed, the other contexts are still returned
+	ci.routes["api/v2/context?owner-slug=gh%2Fbldmgr&page-token=p2"] = `{"items":[{"id":"c2","name":"npm"},{"id":"c3","name":"slack"}]}`
+	got, err = GetContextVariables(context.Background(), ci, "gh/bldmgr")
+	if err == nil {
+		t.Error("expected an error for the context whose variables cannot be listed")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetContextVariables with an unlisted context = %v, want %v", got, want)
+	}
+}
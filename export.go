@@ -0,0 +1,274 @@
+package circleci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	ExportKindPipeline = "pipeline"
+	ExportKindWorkflow = "workflow"
+	ExportKindJob      = "job"
+	ExportKindStep     = "step"
+	ExportKindTest     = "test"
+	ExportKindArtifact = "artifact"
+	ExportKindError    = "error"
+	ExportKindCursor   = "cursor"
+)
+
+// ExportRecord is one line of an NDJSON export. Kind tells which type Data holds:
+//
+//	pipeline  PipelineItem
+//	workflow  PipelineWorkflows
+//	job       ExportJob
+//	step      JobDataSteps
+//	test      TestMetadata
+//	artifact  ArtifactsItem
+//	error     SnapshotError
+//	cursor    ExportCursor
+type ExportRecord struct {
+	Kind       string      `json:"kind"`
+	PipelineID string      `json:"pipeline_id"`
+	WorkflowID string      `json:"workflow_id,omitempty"`
+	JobNumber  int         `json:"job_number,omitempty"`
+	Data       interface{} `json:"data"`
+}
+
+// ExportJob is a job of a workflow with its details, nil for approval jobs
+type ExportJob struct {
+	WorkflowItem
+	Details *JobDetails `json:"details,omitempty"`
+}
+
+// ExportCursor marks the last pipeline written completely. Pipelines are listed newest first,
+// passing it back in ExportOptions continues with the pipeline listed after it, pipelines created in
+// the same instant and listed before it are not exported again. Since and Until are the bounds of the
+// export it belongs to, Until is the start of the export when no upper bound was given.
+// Complete is set once the export reached Since or the oldest pipeline, the next export then starts
+// at Until.
+type ExportCursor struct {
+	PageToken  string    `json:"page_token"`
+	PipelineID string    `json:"pipeline_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Complete   bool      `json:"complete,omitempty"`
+}
+
+// ExportOptions selects the pipelines ExportNDJSON writes. Pipelines created in [Since, Until) are
+// exported, a zero bound is open. Snapshot selects what is fetched for each pipeline.
+type ExportOptions struct {
+	Since    time.Time
+	Until    time.Time
+	Cursor   *ExportCursor
+	Snapshot SnapshotOptions
+}
+
+// ExportNDJSON streams the pipelines of an org as newline-delimited JSON, one ExportRecord per line.
+// The records of a pipeline are followed by a cursor record and a finished export ends with a complete
+// one. LastExportCursor reads it back with the offset past it: truncating the file there drops the
+// records of a pipeline cut off by an interruption, which is written again on resume.
+func ExportNDJSON(ctx context.Context, ci CI, w io.Writer, org string, opts ExportOptions) (ExportCursor, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	writeCursor := func(cursor ExportCursor) error {
+		return enc.Encode(ExportRecord{Kind: ExportKindCursor, PipelineID: cursor.PipelineID, Data: cursor})
+	}
+
+	cursor, err := ExportPipelines(ctx, ci, org, opts, func(snapshot PipelineSnapshot, cursor ExportCursor) error {
+		for _, r := range snapshotRecords(snapshot) {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+
+		return writeCursor(cursor)
+	})
+	if err != nil {
+		return cursor, err
+	}
+
+	return cursor, writeCursor(cursor)
+}
+
+// ExportPipelines snapshots the pipelines of an org selected by opts, newest first, and passes each
+// one to write with the cursor marking it. It stops at the first error of write and returns the
+// cursor of the last pipeline written, marked complete when no pipeline is left.
+func ExportPipelines(ctx context.Context, ci CI, org string, opts ExportOptions, write func(PipelineSnapshot, ExportCursor) error) (ExportCursor, error) {
+	if opts.Until.IsZero() {
+		opts.Until = time.Now()
+	}
+	cursor := ExportCursor{Since: opts.Since, Until: opts.Until}
+	pageToken := ""
+	if opts.Cursor != nil {
+		cursor = *opts.Cursor
+		cursor.Since, cursor.Until, cursor.Complete = opts.Since, opts.Until, false
+		pageToken = cursor.PageToken
+	}
+
+	// on resume everything listed up to the cursor pipeline was written, including pipelines created
+	// in the same instant, so they are skipped until it or an older pipeline comes up
+	resuming := opts.Cursor != nil
+	for {
+		page, err := listOrgPipelines(ctx, ci, org, pageToken)
+		if err != nil {
+			return cursor, err
+		}
+
+		for _, p := range page.Items {
+			if resuming {
+				if p.ID == opts.Cursor.PipelineID {
+					resuming = false
+					continue
+				}
+				if !p.CreatedAt.Before(opts.Cursor.CreatedAt) {
+					continue
+				}
+				resuming = false
+			}
+			if !p.CreatedAt.Before(opts.Until) {
+				continue
+			}
+			if !opts.Since.IsZero() && p.CreatedAt.Before(opts.Since) {
+				cursor.Complete = true
+				return cursor, nil
+			}
+
+			snapshot, err := snapshotPipeline(ctx, ci, p.ID, opts.Snapshot)
+			if err != nil {
+				return cursor, err
+			}
+			snapshot.Pipeline = p
+
+			next := ExportCursor{PageToken: pageToken, PipelineID: p.ID, CreatedAt: p.CreatedAt, Since: opts.Since, Until: opts.Until}
+			if err := write(snapshot, next); err != nil {
+				return cursor, err
+			}
+			cursor = next
+		}
+
+		if page.ContinuationToken == "" {
+			cursor.Complete = true
+			return cursor, nil
+		}
+		pageToken = page.ContinuationToken
+	}
+}
+
+// LastExportCursor returns the last cursor record of an NDJSON export and the byte offset past its
+// line, 0 when the export holds no cursor. Truncating the export at the offset drops what was written
+// after the cursor, such as a line cut off when the export was interrupted, before appending to it.
+func LastExportCursor(r io.Reader) (ExportCursor, int64, error) {
+	var cursor ExportCursor
+	var offset, read int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		read += int64(len(line))
+		// a line without its newline was cut off
+		if bytes.HasSuffix(line, []byte("\n")) && bytes.Contains(line, []byte(`"kind":"`+ExportKindCursor+`"`)) {
+			var record struct {
+				Kind string       `json:"kind"`
+				Data ExportCursor `json:"data"`
+			}
+			if json.Unmarshal(line, &record) == nil && record.Kind == ExportKindCursor {
+				cursor, offset = record.Data, read
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return cursor, offset, nil
+		}
+		if err != nil {
+			return cursor, offset, err
+		}
+	}
+}
+
+// snapshotRecords flattens a snapshot into records, parents before their children
+func snapshotRecords(s PipelineSnapshot) []ExportRecord {
+	id := s.Pipeline.ID
+	records := []ExportRecord{{Kind: ExportKindPipeline, PipelineID: id, Data: s.Pipeline}}
+	for _, w := range s.Workflows {
+		records = append(records, ExportRecord{Kind: ExportKindWorkflow, PipelineID: id, WorkflowID: w.ID, Data: w.Workflow()})
+		for _, j := range w.WorkflowPipeline {
+			job := ExportRecord{PipelineID: id, WorkflowID: w.ID, JobNumber: j.JobNumber}
+			records = append(records, withKind(job, ExportKindJob, ExportJob{WorkflowItem: j.Item(), Details: j.Details}))
+			for _, step := range j.JobDataSteps {
+				records = append(records, withKind(job, ExportKindStep, step))
+			}
+			for _, t := range j.Tests {
+				records = append(records, withKind(job, ExportKindTest, t))
+			}
+			for _, a := range j.Artifacts {
+				records = append(records, withKind(job, ExportKindArtifact, a))
+			}
+		}
+	}
+	for _, e := range s.Errors {
+		records = append(records, ExportRecord{Kind: ExportKindError, PipelineID: id, WorkflowID: e.WorkflowID, JobNumber: e.JobNumber, Data: e})
+	}
+
+	return records
+}
+
+func withKind(r ExportRecord, kind string, data interface{}) ExportRecord {
+	r.Kind = kind
+	r.Data = data
+	return r
+}
+
+// Workflow returns the workflow without its jobs
+func (d AllData) Workflow() PipelineWorkflows {
+	return PipelineWorkflows{
+		PipelineID:     d.PipelineID,
+		ID:             d.ID,
+		Name:           d.Name,
+		ProjectSlug:    d.ProjectSlug,
+		Status:         d.Status,
+		StartedBy:      d.StartedBy,
+		PipelineNumber: d.PipelineNumber,
+		CreatedAt:      d.CreatedAt,
+		StoppedAt:      d.StoppedAt,
+		Tag:            d.Tag,
+	}
+}
+
+// Item returns the job as listed by its workflow
+func (p WorkflowPipeline) Item() WorkflowItem {
+	return WorkflowItem{
+		JobNumber:   p.JobNumber,
+		Id:          p.Id,
+		StartedAt:   p.StartedAt,
+		Name:        p.Name,
+		ProjectSlug: p.ProjectSlug,
+		Status:      p.Status,
+		Type:        p.Type,
+		StoppedAt:   p.StoppedAt,
+	}
+}
+
+// listOrgPipelines fetches one page of the pipelines of an org, newest first
+func listOrgPipelines(ctx context.Context, ci CI, org string, pageToken string) (listGetPipeline, error) {
+	var page listGetPipeline
+	url := fmt.Sprintf(restPipeline, org) + "&mine=false"
+	if pageToken != "" {
+		url = withPageToken(url, pageToken)
+	}
+
+	body, resp, err := getWithContext(ctx, ci, url)
+	if err != nil {
+		return page, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("listing pipelines of %s: %s", org, resp.Status)
+	}
+
+	return page, json.Unmarshal(body, &page)
+}
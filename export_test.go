@@ -0,0 +1,93 @@
+package circleci
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLastExportCursor(t *testing.T) {
+	pipeline := `{"kind":"pipeline","pipeline_id":"p1","data":{}}` + "\n"
+	first := `{"kind":"cursor","pipeline_id":"p1","data":{"page_token":"","pipeline_id":"p1","created_at":"2024-06-20T17:42:50Z","since":"0001-01-01T00:00:00Z","until":"2024-06-21T00:00:00Z"}}` + "\n"
+	complete := `{"kind":"cursor","pipeline_id":"p2","data":{"page_token":"t2","pipeline_id":"p2","created_at":"2024-06-20T10:00:00Z","since":"0001-01-01T00:00:00Z","until":"2024-06-21T00:00:00Z","complete":true}}` + "\n"
+
+	tests := []struct {
+		name     string
+		export   string
+		pipeline string
+		offset   int
+		complete bool
+	}{
+		{name: "empty", export: "", offset: 0},
+		{name: "no cursor", export: pipeline, offset: 0},
+		{name: "interrupted between pipelines", export: pipeline + first, pipeline: "p1", offset: len(pipeline + first)},
+		{name: "interrupted within a line", export: pipeline + first + `{"kind":"pipeline","pipel`, pipeline: "p1", offset: len(pipeline + first)},
+		{name: "cursor cut off before its newline", export: pipeline + first + strings.TrimSuffix(complete, "\n"), pipeline: "p1", offset: len(pipeline + first)},
+		{name: "complete", export: pipeline + first + complete, pipeline: "p2", offset: len(pipeline + first + complete), complete: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, offset, err := LastExportCursor(strings.NewReader(tt.export))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if offset != int64(tt.offset) {
+				t.Errorf("offset = %d, want %d", offset, tt.offset)
+			}
+			if cursor.PipelineID != tt.pipeline {
+				t.Errorf("PipelineID = %q, want %q", cursor.PipelineID, tt.pipeline)
+			}
+			if cursor.Complete != tt.complete {
+				t.Errorf("Complete = %t, want %t", cursor.Complete, tt.complete)
+			}
+		})
+	}
+}
+
+func TestExportPipelinesResume(t *testing.T) {
+	pipeline := func(id string, created string) string {
+		return `{"id":"` + id + `","project_slug":"gh/bldmgr/circleci","state":"created","created_at":"` + created + `"}`
+	}
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline?org-slug=gh/bldmgr&mine=false": `{"items":[` + pipeline("p1", "2024-06-20T12:00:00Z") + `,` + pipeline("p2", "2024-06-20T11:00:00Z") + `,` +
+			pipeline("p3", "2024-06-20T11:00:00Z") + `],"next_page_token":"t2"}`,
+		"api/v2/pipeline?org-slug=gh/bldmgr&mine=false&page-token=t2": `{"items":[` + pipeline("p4", "2024-06-20T11:00:00Z") + `,` + pipeline("p5", "2024-06-20T10:00:00Z") + `],"next_page_token":"t3"}`,
+		"api/v2/pipeline?org-slug=gh/bldmgr&mine=false&page-token=t3": `{"items":[` + pipeline("p6", "2024-06-20T09:00:00Z") + `]}`,
+	}}
+	all := []string{"p1", "p2", "p3", "p4", "p5", "p6"}
+	opts := ExportOptions{Until: time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)}
+	interrupted := errors.New("interrupted")
+
+	for n := 1; n < len(all); n++ {
+		exported := make([]string, 0)
+		written := 0
+		cursor, err := ExportPipelines(context.Background(), ci, "bldmgr", opts, func(s PipelineSnapshot, _ ExportCursor) error {
+			if written == n {
+				return interrupted
+			}
+			written++
+			exported = append(exported, s.Pipeline.ID)
+			return nil
+		})
+		if !errors.Is(err, interrupted) || cursor.Complete {
+			t.Fatalf("interrupt after %d: err = %v, complete = %t", n, err, cursor.Complete)
+		}
+
+		resume := opts
+		resume.Cursor = &cursor
+		cursor, err = ExportPipelines(context.Background(), ci, "bldmgr", resume, func(s PipelineSnapshot, _ ExportCursor) error {
+			exported = append(exported, s.Pipeline.ID)
+			return nil
+		})
+		if err != nil || !cursor.Complete {
+			t.Fatalf("resume after %d: err = %v, complete = %t", n, err, cursor.Complete)
+		}
+		if !reflect.DeepEqual(exported, all) {
+			t.Errorf("interrupted after %d pipelines, exported %v, want every pipeline once", n, exported)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package circleci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// fakeCI serves canned response bodies keyed by endpoint, the longest matching prefix wins.
+// Endpoints without a route answer 404.
+type fakeCI struct {
+	routes map[string]string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (f *fakeCI) route(endpoint string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, endpoint)
+
+	best, body, ok := "", "", false
+	for prefix, b := range f.routes {
+		if strings.HasPrefix(endpoint, prefix) && len(prefix) >= len(best) {
+			best, body, ok = prefix, b, true
+		}
+	}
+
+	return body, ok
+}
+
+// requested returns how many requests were made for endpoints starting with prefix
+func (f *fakeCI) requested(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (f *fakeCI) Get(endpoint string) ([]byte, *http.Response, error) {
+	body, ok := f.route(endpoint)
+	if !ok {
+		return nil, &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Header: http.Header{}}, nil
+	}
+
+	return []byte(body), &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: http.Header{}}, nil
+}
+
+func (f *fakeCI) GetWithContext(ctx context.Context, endpoint string) ([]byte, *http.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return f.Get(endpoint)
+}
+
+func (f *fakeCI) Open(ctx context.Context, endpoint string) (io.ReadCloser, *http.Response, error) {
+	body, resp, err := f.GetWithContext(ctx, endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp, io.ErrUnexpectedEOF
+	}
+
+	return io.NopCloser(bytes.NewReader(body)), resp, nil
+}
+
+func (f *fakeCI) CurlRequest(method, endpoint string) (*http.Request, error) { return nil, nil }
+
+func (f *fakeCI) NewRequest(method, endpoint string, payload io.Reader) (*http.Request, error) {
+	return nil, nil
+}
+
+func (f *fakeCI) Post(endpoint string, payload io.Reader) ([]byte, *http.Response, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeCI) Info() ServerInfo { return ServerInfo{} }
+
+// jsonString quotes s as a JSON string
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
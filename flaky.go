@@ -0,0 +1,251 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	restInsightsFlakyTests = "api/v2/insights/%s/flaky-tests"
+)
+
+// InsightsFlakyTest is a test the Insights API flagged as flaky
+type InsightsFlakyTest struct {
+	TimeWasted        int       `json:"time-wasted"`
+	WorkflowCreatedAt time.Time `json:"workflow-created-at"`
+	WorkflowID        string    `json:"workflow-id"`
+	Classname         string    `json:"classname"`
+	PipelineNumber    int       `json:"pipeline-number"`
+	WorkflowName      string    `json:"workflow-name"`
+	TestName          string    `json:"test-name"`
+	JobName           string    `json:"job-name"`
+	JobNumber         int       `json:"job-number"`
+	TimesFlaked       int       `json:"times-flaked"`
+	Source            string    `json:"source"`
+	File              string    `json:"file"`
+}
+
+type listInsightsFlakyTests struct {
+	FlakyTests []InsightsFlakyTest `json:"flaky-tests"`
+	Total      int                 `json:"total-flaky-tests"`
+}
+
+// GetInsightsFlakyTests returns the flaky tests Insights reports for a project. ok is false when the
+// endpoint is unavailable, e.g. on server installs or plans without Insights.
+func GetInsightsFlakyTests(ci CI, projectSlug string, output string) (items []InsightsFlakyTest, ok bool) {
+	var p listInsightsFlakyTests
+	url := fmt.Sprintf(restInsightsFlakyTests, projectSlug)
+	body, resp, err := ci.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return make([]InsightsFlakyTest, 0), false
+	}
+
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		fmt.Printf("could not read items from response: %v", err)
+		return make([]InsightsFlakyTest, 0), false
+	}
+
+	if output == "json" {
+		fmt.Printf(string(body) + "\n")
+	}
+
+	return p.FlakyTests, true
+}
+
+// FlakyTest is a test whose result changed between runs of the same job on the same commit.
+// FlipRate is Flips divided by the number of consecutive run pairs on a shared revision.
+type FlakyTest struct {
+	JobName      string   `json:"job_name"`
+	Classname    string   `json:"classname"`
+	File         string   `json:"file"`
+	Name         string   `json:"name"`
+	Runs         int      `json:"runs"`
+	Passes       int      `json:"passes"`
+	Failures     int      `json:"failures"`
+	Flips        int      `json:"flips"`
+	FlipRate     float64  `json:"flip_rate"`
+	Revisions    []string `json:"revisions"`
+	LastMessage  string   `json:"last_message,omitempty"`
+	Insights     bool     `json:"insights"`
+	TimesFlaked  int      `json:"times_flaked,omitempty"`
+	InsightsOnly bool     `json:"insights_only,omitempty"`
+}
+
+// FlakyReport is the result of DetectFlakyTests, tests are ordered by flip rate
+type FlakyReport struct {
+	ProjectSlug       string      `json:"project_slug"`
+	Branch            string      `json:"branch"`
+	Pipelines         int         `json:"pipelines"`
+	InsightsAvailable bool        `json:"insights_available"`
+	Tests             []FlakyTest `json:"tests"`
+	Errors            []ScanError `json:"errors"`
+}
+
+// testRun is one result of a test in a job run
+type testRun struct {
+	revision string
+	at       time.Time
+	failed   bool
+	message  string
+}
+
+// FlakyHistory collects test results of job runs in any order and scores them with Result
+type FlakyHistory struct {
+	runs  map[string][]testRun
+	tests map[string]FlakyTest
+}
+
+// NewFlakyHistory returns an empty history
+func NewFlakyHistory() *FlakyHistory {
+	return &FlakyHistory{runs: make(map[string][]testRun), tests: make(map[string]FlakyTest)}
+}
+
+// Add records the results of one run of a job on revision. Skipped tests are ignored.
+func (h *FlakyHistory) Add(jobName string, revision string, at time.Time, tests []TestMetadata) {
+	for key, t := range testResults(tests) {
+		if t.Skipped() {
+			continue
+		}
+		key = jobName + "|" + key
+		if _, ok := h.tests[key]; !ok {
+			h.tests[key] = FlakyTest{JobName: jobName, Classname: t.Classname, File: t.File, Name: t.Name}
+		}
+		h.runs[key] = append(h.runs[key], testRun{revision: revision, at: at, failed: t.Failed(), message: t.Message})
+	}
+}
+
+// Result scores every test and returns those that flipped on a revision, highest flip rate first
+func (h *FlakyHistory) Result() []FlakyTest {
+	flaky := make([]FlakyTest, 0)
+	for _, key := range sortedKeys(h.runs) {
+		runs := h.runs[key]
+		sort.SliceStable(runs, func(i, j int) bool {
+			return runs[i].at.Before(runs[j].at)
+		})
+
+		t := h.tests[key]
+		t.Revisions = make([]string, 0)
+		last := make(map[string]testRun)
+		pairs := 0
+		for _, run := range runs {
+			t.Runs++
+			if run.failed {
+				t.Failures++
+				t.LastMessage = firstLine(run.message)
+			} else {
+				t.Passes++
+			}
+			if prev, ok := last[run.revision]; ok {
+				pairs++
+				if prev.failed != run.failed {
+					t.Flips++
+					t.Revisions = appendUniqueString(t.Revisions, run.revision)
+				}
+			}
+			last[run.revision] = run
+		}
+		if t.Flips == 0 {
+			continue
+		}
+		t.FlipRate = float64(t.Flips) / float64(pairs)
+		flaky = append(flaky, t)
+	}
+	sortFlakyTests(flaky)
+
+	return flaky
+}
+
+func sortFlakyTests(tests []FlakyTest) {
+	sort.SliceStable(tests, func(i, j int) bool {
+		if tests[i].FlipRate != tests[j].FlipRate {
+			return tests[i].FlipRate > tests[j].FlipRate
+		}
+		return tests[i].Flips > tests[j].Flips
+	})
+}
+
+// DetectFlakyTests walks the last n pipelines of a project branch, reruns included, and reports tests
+// that both passed and failed on the same revision. Tests Insights flags as flaky are marked, and
+// listed with InsightsOnly when the walked history did not show them flipping.
+func DetectFlakyTests(ctx context.Context, ci CI, projectSlug string, branch string, n int, output string) FlakyReport {
+	report := FlakyReport{
+		ProjectSlug: projectSlug,
+		Branch:      branch,
+		Tests:       make([]FlakyTest, 0),
+		Errors:      make([]ScanError, 0),
+	}
+
+	pipelines := GetProjectPipelines(ci, projectSlug, branch, "none", (n+19)/20)
+	if len(pipelines) > n {
+		pipelines = pipelines[:n]
+	}
+	report.Pipelines = len(pipelines)
+
+	history := NewFlakyHistory()
+	for _, pipeline := range pipelines {
+		if err := ctx.Err(); err != nil {
+			report.Errors = append(report.Errors, ScanError{ProjectSlug: projectSlug, PipelineID: pipeline.ID, Message: err.Error()})
+			break
+		}
+		for _, workflow := range GetPipelineWorkflows(ci, pipeline.ID, "none") {
+			for _, job := range GetWorkflowJob(ci, workflow.ID, "none", "", "") {
+				if job.JobNumber == 0 {
+					continue
+				}
+				at, err := time.Parse(time.RFC3339, job.StartedAt)
+				if err != nil {
+					at = workflow.CreatedAt
+				}
+				history.Add(job.Name, pipeline.Vcs.Revision, at, GetTestMetadataRef(ci, job.Ref()))
+			}
+		}
+	}
+	report.Tests = history.Result()
+
+	insights, ok := GetInsightsFlakyTests(ci, projectSlug, "none")
+	report.InsightsAvailable = ok
+	for _, it := range insights {
+		found := false
+		for i := range report.Tests {
+			t := &report.Tests[i]
+			if t.Classname == it.Classname && t.Name == it.TestName && (it.JobName == "" || t.JobName == it.JobName) {
+				t.Insights = true
+				t.TimesFlaked = it.TimesFlaked
+				found = true
+			}
+		}
+		if !found {
+			report.Tests = append(report.Tests, FlakyTest{
+				JobName:      it.JobName,
+				Classname:    it.Classname,
+				File:         it.File,
+				Name:         it.TestName,
+				Revisions:    make([]string, 0),
+				Insights:     true,
+				TimesFlaked:  it.TimesFlaked,
+				InsightsOnly: true,
+			})
+		}
+	}
+
+	if output == "json" {
+		out, err := json.Marshal(report)
+		if err != nil {
+			fmt.Printf("could not marshal flaky tests: %v", err)
+		}
+		fmt.Printf(string(out) + "\n")
+	}
+
+	if output == "status" {
+		for _, t := range report.Tests {
+			fmt.Printf("%s %s %s: %d flips in %d runs (%.2f)\n", t.JobName, t.Classname, t.Name, t.Flips, t.Runs, t.FlipRate)
+		}
+	}
+
+	return report
+}
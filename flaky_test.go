@@ -0,0 +1,100 @@
+package circleci
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlakyHistory(t *testing.T) {
+	at := func(minute int) time.Time {
+		return time.Date(2024, 6, 20, 10, minute, 0, 0, time.UTC)
+	}
+	test := func(name string, result string, message string) TestMetadata {
+		return TestMetadata{Classname: "pkg", File: "a_test.go", Name: name, Result: result, Message: message}
+	}
+
+	h := NewFlakyHistory()
+	// runs are added out of order, they are scored by start time
+	h.Add("test", "abc", at(3), []TestMetadata{test("TestFlip", TestSuccess, ""), test("TestHalf", TestFailure, "timeout")})
+	h.Add("test", "abc", at(1), []TestMetadata{test("TestFlip", TestSuccess, ""), test("TestHalf", TestSuccess, "")})
+	h.Add("test", "abc", at(2), []TestMetadata{test("TestFlip", TestFailure, "boom\nstack"), test("TestHalf", TestSuccess, "")})
+	// a different revision is a different change, not a flip
+	h.Add("test", "def", at(4), []TestMetadata{test("TestRevision", TestFailure, "")})
+	h.Add("test", "abc", at(4), []TestMetadata{test("TestRevision", TestSuccess, "")})
+	// skipped runs are ignored
+	h.Add("test", "abc", at(5), []TestMetadata{test("TestSkip", TestSuccess, "")})
+	h.Add("test", "abc", at(6), []TestMetadata{test("TestSkip", TestSkipped, "")})
+	h.Add("test", "abc", at(7), []TestMetadata{test("TestSkip", TestSuccess, "")})
+	// a run reporting a test twice counts as failed when one result failed
+	h.Add("lint", "abc", at(1), []TestMetadata{test("TestFlip", TestSuccess, "")})
+	h.Add("lint", "abc", at(2), []TestMetadata{test("TestFlip", TestSuccess, ""), test("TestFlip", TestFailure, "retry")})
+
+	got := h.Result()
+	want := []FlakyTest{
+		// equal flip rates are ordered by flips
+		{JobName: "test", Classname: "pkg", File: "a_test.go", Name: "TestFlip", Runs: 3, Passes: 2, Failures: 1, Flips: 2, FlipRate: 1, Revisions: []string{"abc"}, LastMessage: "boom ..."},
+		{JobName: "lint", Classname: "pkg", File: "a_test.go", Name: "TestFlip", Runs: 2, Passes: 1, Failures: 1, Flips: 1, FlipRate: 1, Revisions: []string{"abc"}, LastMessage: "retry"},
+		{JobName: "test", Classname: "pkg", File: "a_test.go", Name: "TestHalf", Runs: 3, Passes: 2, Failures: 1, Flips: 1, FlipRate: 0.5, Revisions: []string{"abc"}, LastMessage: "timeout"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Result =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDetectFlakyTests(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/pipeline?branch=main": `{"items":[` +
+			`{"id":"p2","number":2,"vcs":{"revision":"abc"}},{"id":"p1","number":1,"vcs":{"revision":"abc"}}]}`,
+		"api/v2/pipeline/p1/workflow": `{"items":[{"id":"w1"}]}`,
+		"api/v2/pipeline/p2/workflow": `{"items":[{"id":"w2"}]}`,
+		"api/v2/workflow/w1/job":      `{"items":[{"job_number":11,"name":"test","started_at":"2024-06-20T10:00:00Z","project_slug":"gh/bldmgr/circleci"}]}`,
+		"api/v2/workflow/w2/job":      `{"items":[{"job_number":12,"name":"test","started_at":"2024-06-20T11:00:00Z","project_slug":"gh/bldmgr/circleci"}]}`,
+
+		"api/v2/project/gh/bldmgr/circleci/11/tests": `{"items":[{"classname":"pkg","name":"TestA","result":"success"}]}`,
+		"api/v2/project/gh/bldmgr/circleci/12/tests": `{"items":[{"classname":"pkg","name":"TestA","result":"failure","message":"flaked"}]}`,
+
+		"api/v2/insights/gh/bldmgr/circleci/flaky-tests": `{"flaky-tests":[` +
+			`{"classname":"pkg","test-name":"TestA","job-name":"test","times-flaked":3},` +
+			`{"classname":"pkg","test-name":"TestB","job-name":"test","times-flaked":1}],"total-flaky-tests":2}`,
+	}}
+
+	report := DetectFlakyTests(context.Background(), ci, "gh/bldmgr/circleci", "main", 10, "none")
+	if report.Pipelines != 2 || !report.InsightsAvailable || len(report.Errors) != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Tests) != 2 {
+		t.Fatalf("tests = %+v, want TestA and the Insights only TestB", report.Tests)
+	}
+	if a := report.Tests[0]; a.Name != "TestA" || a.Flips != 1 || !a.Insights || a.TimesFlaked != 3 || a.InsightsOnly {
+		t.Errorf("TestA = %+v, want a flip marked by Insights", a)
+	}
+	if b := report.Tests[1]; b.Name != "TestB" || !b.InsightsOnly || b.Runs != 0 {
+		t.Errorf("TestB = %+v, want listed from Insights only", b)
+	}
+}
+
+func TestGetProjectPipelinesURL(t *testing.T) {
+	tests := []struct {
+		branch string
+		first  string
+		next   string
+	}{
+		{"", "api/v2/project/gh/bldmgr/circleci/pipeline", "api/v2/project/gh/bldmgr/circleci/pipeline?page-token=t%2B2"},
+		{"feature/x", "api/v2/project/gh/bldmgr/circleci/pipeline?branch=feature%2Fx", "api/v2/project/gh/bldmgr/circleci/pipeline?branch=feature%2Fx&page-token=t%2B2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			ci := &fakeCI{routes: map[string]string{
+				tt.first: `{"items":[{"id":"p2","number":2}],"next_page_token":"t+2"}`,
+				tt.next:  `{"items":[{"id":"p1","number":1}]}`,
+			}}
+			items := GetProjectPipelines(ci, "gh/bldmgr/circleci", tt.branch, "none", 5)
+			if len(items) != 2 {
+				t.Errorf("listed %+v, requests %v", items, ci.requests)
+			}
+		})
+	}
+}
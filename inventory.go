@@ -0,0 +1,180 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	InventoryDockerImage   = "docker_image"
+	InventoryMachineImage  = "machine_image"
+	InventoryMacosXcode    = "macos_xcode"
+	InventoryResourceClass = "resource_class"
+)
+
+// ExecutorUsage counts how often an image or resource class is declared in config and used by jobs
+type ExecutorUsage struct {
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	ExecutorType string    `json:"executor_type"`
+	SelfHosted   bool      `json:"self_hosted"`
+	ConfigCount  int       `json:"config_count"`
+	RunCount     int       `json:"run_count"`
+	Projects     []string  `json:"projects"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// ExecutorInventory is the org wide executor report returned by GetExecutorInventory
+type ExecutorInventory struct {
+	Org             string          `json:"org"`
+	DockerImages    []ExecutorUsage `json:"docker_images"`
+	MachineImages   []ExecutorUsage `json:"machine_images"`
+	MacosXcode      []ExecutorUsage `json:"macos_xcode"`
+	ResourceClasses []ExecutorUsage `json:"resource_classes"`
+	Errors          []ScanError     `json:"errors"`
+}
+
+type inventoryBuilder struct {
+	usages map[string]*ExecutorUsage
+}
+
+func (b *inventoryBuilder) add(kind string, name string, executorType string, project string, seen time.Time, run bool) {
+	if name == "" {
+		name = "default"
+	}
+	key := kind + "|" + executorType + "|" + name
+	u, ok := b.usages[key]
+	if !ok {
+		u = &ExecutorUsage{
+			Kind:         kind,
+			Name:         name,
+			ExecutorType: executorType,
+			SelfHosted:   kind == InventoryResourceClass && isRunnerClass(name),
+			Projects:     make([]string, 0),
+		}
+		b.usages[key] = u
+	}
+	if run {
+		u.RunCount++
+	} else {
+		u.ConfigCount++
+	}
+	u.Projects = appendUniqueString(u.Projects, project)
+	if seen.After(u.LastSeen) {
+		u.LastSeen = seen
+	}
+}
+
+// addConfig records every image and resource class declared by the jobs of a compiled config
+func (b *inventoryBuilder) addConfig(c *CompiledConfig, project string, seen time.Time) {
+	for name := range c.Jobs {
+		e, _ := c.JobExecutor(name)
+		executorType := e.Type()
+		switch executorType {
+		case "docker":
+			for _, d := range e.Docker {
+				b.add(InventoryDockerImage, d.Image, executorType, project, seen, false)
+			}
+		case "machine":
+			b.add(InventoryMachineImage, e.Machine.Image, executorType, project, seen, false)
+		case "macos":
+			b.add(InventoryMacosXcode, e.Macos.Xcode, executorType, project, seen, false)
+		}
+		b.add(InventoryResourceClass, e.ResourceClass, executorType, project, seen, false)
+	}
+}
+
+func (b *inventoryBuilder) list(kind string) []ExecutorUsage {
+	out := make([]ExecutorUsage, 0)
+	for _, u := range b.usages {
+		if u.Kind == kind {
+			sort.Strings(u.Projects)
+			out = append(out, *u)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].RunCount+out[i].ConfigCount != out[j].RunCount+out[j].ConfigCount {
+			return out[i].RunCount+out[i].ConfigCount > out[j].RunCount+out[j].ConfigCount
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// GetExecutorInventory reports the docker images, machine images and resource classes used across an org.
+// Declared executors come from the compiled config of each project's latest pipeline and
+// actual executors come from JobDetails of the jobs that pipeline ran.
+func GetExecutorInventory(ci CI, org string, output string, page int) (inventory ExecutorInventory) {
+	b := &inventoryBuilder{usages: make(map[string]*ExecutorUsage)}
+	inventory.Org = org
+	inventory.Errors = make([]ScanError, 0)
+
+	for _, pipeline := range latestPipelines(GetPipeline(ci, org, "none", page)) {
+		p, err := fetchPipelineConfig(context.Background(), ci, pipeline.ID)
+		if err == nil {
+			var c *CompiledConfig
+			c, err = ParseConfig([]byte(p.Compiled))
+			if err == nil {
+				b.addConfig(c, pipeline.ProjectSlug, pipeline.CreatedAt)
+			}
+		}
+		if err != nil {
+			inventory.Errors = append(inventory.Errors, ScanError{
+				ProjectSlug: pipeline.ProjectSlug,
+				PipelineID:  pipeline.ID,
+				Message:     err.Error(),
+			})
+		}
+
+		project, vcs, namespace := formatProjectSlug(pipeline.ProjectSlug)
+		for _, workflow := range GetPipelineWorkflows(ci, pipeline.ID, "none") {
+			for _, job := range GetWorkflowJob(ci, workflow.ID, "none", "", "") {
+				if job.JobNumber == 0 {
+					// approval jobs have no executor
+					continue
+				}
+				jd := GetJobDetails(ci, strconv.Itoa(job.JobNumber), vcs, namespace, project, "none")
+				if jd.Number == 0 {
+					// counting unavailable details would report a default resource class the job never ran on
+					inventory.Errors = append(inventory.Errors, ScanError{
+						ProjectSlug: pipeline.ProjectSlug,
+						PipelineID:  pipeline.ID,
+						Message:     fmt.Sprintf("job %d details unavailable", job.JobNumber),
+					})
+					continue
+				}
+				seen := jd.StartedAt
+				if seen.IsZero() {
+					seen = pipeline.CreatedAt
+				}
+				b.add(InventoryResourceClass, jd.Executor.ResourceClass, jd.Executor.Type, pipeline.ProjectSlug, seen, true)
+			}
+		}
+	}
+
+	inventory.DockerImages = b.list(InventoryDockerImage)
+	inventory.MachineImages = b.list(InventoryMachineImage)
+	inventory.MacosXcode = b.list(InventoryMacosXcode)
+	inventory.ResourceClasses = b.list(InventoryResourceClass)
+
+	if output == "json" {
+		out, err := json.Marshal(inventory)
+		if err != nil {
+			fmt.Printf("could not marshal executor inventory: %v", err)
+		}
+		fmt.Printf(string(out) + "\n")
+	}
+
+	if output == "status" {
+		for _, u := range inventory.ResourceClasses {
+			fmt.Printf("%s (%s): config %d, runs %d, last seen %v\n", u.Name, u.ExecutorType, u.ConfigCount, u.RunCount, u.LastSeen)
+		}
+	}
+
+	return inventory
+}
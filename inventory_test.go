@@ -0,0 +1,76 @@
+package circleci
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInventoryBuilder(t *testing.T) {
+	c, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2024, 6, 17, 9, 0, 0, 0, time.UTC)
+	b := &inventoryBuilder{usages: make(map[string]*ExecutorUsage)}
+	b.addConfig(c, "gh/bldmgr/b", monday)
+	b.addConfig(c, "gh/bldmgr/a", monday.Add(time.Hour))
+	b.add(InventoryResourceClass, "large", "docker", "gh/bldmgr/a", monday.Add(2*time.Hour), true)
+	b.add(InventoryResourceClass, "", "docker", "gh/bldmgr/a", monday, true)
+
+	docker := b.list(InventoryDockerImage)
+	if len(docker) != 2 || docker[0].Name != "cimg/go:1.22" || docker[0].ConfigCount != 4 || docker[1].Name != "cimg/postgres:16.2" {
+		t.Errorf("docker images = %+v, want every image of the executor counted per job and project", docker)
+	}
+	if p := docker[0].Projects; len(p) != 2 || p[0] != "gh/bldmgr/a" || p[1] != "gh/bldmgr/b" {
+		t.Errorf("projects = %v, want sorted and unique", p)
+	}
+	if !docker[0].LastSeen.Equal(monday.Add(time.Hour)) {
+		t.Errorf("LastSeen = %v, want the newest sighting", docker[0].LastSeen)
+	}
+
+	classes := make(map[string]ExecutorUsage)
+	for _, u := range b.list(InventoryResourceClass) {
+		classes[u.ExecutorType+"|"+u.Name] = u
+	}
+	if u := classes["docker|large"]; u.ConfigCount != 2 || u.RunCount != 1 || !u.LastSeen.Equal(monday.Add(2*time.Hour)) {
+		t.Errorf("large = %+v, want 2 declarations and 1 run", u)
+	}
+	if u := classes["docker|default"]; u.RunCount != 1 {
+		t.Errorf("a job without a resource class is not counted as default: %+v", u)
+	}
+	if u := classes["machine|bldmgr/runner"]; !u.SelfHosted {
+		t.Errorf("runner class not reported as self-hosted: %+v", u)
+	}
+	if u := classes["docker|medium"]; u.SelfHosted {
+		t.Errorf("cloud class reported as self-hosted: %+v", u)
+	}
+
+	if m := b.list(InventoryMachineImage); len(m) != 2 {
+		t.Errorf("machine images = %+v, want the image and the default one", m)
+	}
+	if m := b.list(InventoryMacosXcode); len(m) != 1 || m[0].Name != "15.4.0" || m[0].ConfigCount != 2 {
+		t.Errorf("xcode versions = %+v", m)
+	}
+}
+
+func TestGetExecutorInventorySkipsMissingJobDetails(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline?org-slug=gh/bldmgr":       `{"items":[{"id":"p1","number":3,"project_slug":"gh/bldmgr/circleci","created_at":"2024-06-20T10:00:00Z"}]}`,
+		"api/v2/pipeline/p1/config":                `{"compiled":` + jsonString("jobs:\n  build:\n    docker:\n      - image: cimg/go:1.22\n") + `}`,
+		"api/v2/pipeline/p1/workflow":              `{"items":[{"id":"w1"}]}`,
+		"api/v2/workflow/w1/job":                   `{"items":[{"id":"j1","job_number":11},{"id":"j2","job_number":12},{"id":"hold","job_number":0,"type":"approval"}]}`,
+		"api/v2/project/gh/bldmgr/circleci/job/11": `{"number":11,"executor":{"type":"docker","resource_class":"large"}}`,
+	}}
+
+	inventory := GetExecutorInventory(ci, "bldmgr", "none", 1)
+	runs := 0
+	for _, u := range inventory.ResourceClasses {
+		runs += u.RunCount
+	}
+	if runs != 1 {
+		t.Errorf("counted %d runs, want only the job with details: %+v", runs, inventory.ResourceClasses)
+	}
+	if len(inventory.Errors) != 1 || inventory.Errors[0].Message != "job 12 details unavailable" {
+		t.Errorf("errors = %+v, want the missing job details", inventory.Errors)
+	}
+}
@@ -1,9 +1,11 @@
 package circleci
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -11,8 +13,8 @@ const (
 	restGetJobDetails   = "api/v2/project/%s/%s/%s/job/%s"
 	restGetJobArtifacts = "api/v2/project/%s/%s/artifacts"
 	restGetTestMetadata = "api/v2/project/%s/%s/%s/%s/tests"
-	restGetJobData      = "api/v1.1/project/%s/%s/%s/%s/output/%s/0?file=true"
-	restGetProject      = "api/v1.1/project/github/Cloud/janus-rails/27993"
+	restGetJobData      = "api/v1.1/project/%s/%s/%s/%s/output/%s/%d?file=true"
+	restGetBuildDetail  = "api/v1.1/project/%s/%s/%s/%s"
 	//https://$CIRCLE_HOSTNAME/api/v1.1/project/github/$CIRCLE_PROJECT_USERNAME/$CIRCLE_PROJECT_REPONAME/$build_num
 )
 
@@ -24,11 +26,8 @@ type JobDetails struct {
 		Name        string `json:"name"`
 		ID          string `json:"id"`
 	} `json:"project"`
-	ParallelRuns []struct {
-		Index  int    `json:"index"`
-		Status string `json:"status"`
-	} `json:"parallel_runs"`
-	StartedAt      time.Time `json:"started_at"`
+	ParallelRuns   []ParallelRun `json:"parallel_runs"`
+	StartedAt      time.Time     `json:"started_at"`
 	LatestWorkflow struct {
 		Name string `json:"name"`
 		ID   string `json:"id"`
@@ -57,6 +56,28 @@ type JobDetails struct {
 	StoppedAt time.Time `json:"stopped_at"`
 }
 
+// ParallelRun is the status of one parallel node of a job
+type ParallelRun struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+}
+
+// NodeIndexes returns the parallel run indexes of a job, falling back to Parallelism
+// when the API did not report parallel runs
+func (j JobDetails) NodeIndexes() []int {
+	nodes := make([]int, 0, len(j.ParallelRuns))
+	for _, run := range j.ParallelRuns {
+		nodes = append(nodes, run.Index)
+	}
+	if len(nodes) == 0 {
+		for i := 0; i < j.Parallelism || i == 0; i++ {
+			nodes = append(nodes, i)
+		}
+	}
+
+	return nodes
+}
+
 type listTestMetadata struct {
 	Items             []TestMetadata `json:"items"`
 	ContinuationToken string         `json:"next_page_token"`
@@ -89,7 +110,7 @@ func GetJobsArtifacts(ci CI, jobId string, project string, output string) (items
 		url := fmt.Sprintf(restGetJobArtifacts, project, jobId)
 
 		if continuation != "" {
-			url += "&continuationToken=" + continuation
+			url = withPageToken(url, continuation)
 		}
 
 		body, resp, err := ci.Get(url)
@@ -126,6 +147,27 @@ func GetJobsArtifacts(ci CI, jobId string, project string, output string) (items
 	return items
 }
 
+// fetchJobArtifacts returns every artifact of a job, unlike GetJobsArtifacts a failed request is reported
+func fetchJobArtifacts(ctx context.Context, ci CI, job JobRef) ([]ArtifactsItem, error) {
+	items := make([]ArtifactsItem, 0)
+	pageToken := ""
+	for {
+		var page Artifacts
+		url := fmt.Sprintf(restGetJobArtifacts, job.ProjectSlug, strconv.Itoa(job.JobNumber))
+		if pageToken != "" {
+			url = withPageToken(url, pageToken)
+		}
+		if err := getPage(ctx, ci, url, &page); err != nil {
+			return items, fmt.Errorf("artifacts of job %d: %w", job.JobNumber, err)
+		}
+		items = append(items, page.Items...)
+		if page.ContinuationToken == "" {
+			return items, nil
+		}
+		pageToken = page.ContinuationToken
+	}
+}
+
 func GetJobDetails(ci CI, jobId string, vsc string, namespace string, project string, output string) (items JobDetails) {
 	var p JobDetails
 	url := fmt.Sprintf(restGetJobDetails, vsc, namespace, project, jobId)
@@ -147,6 +189,8 @@ func GetJobDetails(ci CI, jobId string, vsc string, namespace string, project st
 	return p
 }
 
+// GetTestMetadata returns the test results of a job, following every page. page is kept for
+// compatibility and does not limit the results.
 func GetTestMetadata(ci CI, jobId string, vsc string, namespace string, project string, output string, page int) (items []TestMetadata) {
 	continuation := ""
 
@@ -154,7 +198,7 @@ func GetTestMetadata(ci CI, jobId string, vsc string, namespace string, project
 		url := fmt.Sprintf(restGetTestMetadata, vsc, namespace, project, jobId)
 
 		if continuation != "" {
-			url += "&continuationToken=" + continuation
+			url = withPageToken(url, continuation)
 		}
 
 		body, resp, err := ci.Get(url)
@@ -174,28 +218,32 @@ func GetTestMetadata(ci CI, jobId string, vsc string, namespace string, project
 	}
 
 	items = make([]TestMetadata, 0)
-	for i := 0; i < page; i++ {
-		for {
-			resp, err := get()
-			if err != nil {
-				return items
-			}
-
-			items = append(items, resp.Items...)
-			if resp.ContinuationToken == "" {
-				break
-			}
+	for {
+		resp, err := get()
+		if err != nil {
+			return items
+		}
 
-			continuation = resp.ContinuationToken
+		items = append(items, resp.Items...)
+		if resp.ContinuationToken == "" {
+			break
 		}
+
+		continuation = resp.ContinuationToken
 	}
 
 	return items
 }
 
+// GetJobData returns the whole output of a step of node 0 in memory, use OpenStepOutput to stream large logs
 func GetJobData(ci CI, jobId string, vsc string, namespace string, project string, step string, output string) (t []byte) {
+	return GetJobDataNode(ci, jobId, vsc, namespace, project, step, 0, output)
+}
+
+// GetJobDataNode returns the whole output of a step of one parallel node in memory
+func GetJobDataNode(ci CI, jobId string, vsc string, namespace string, project string, step string, node int, output string) (t []byte) {
 
-	url := fmt.Sprintf(restGetJobData, vsc, namespace, project, jobId, step)
+	url := fmt.Sprintf(restGetJobData, vsc, namespace, project, jobId, step, node)
 
 	body, resp, err := ci.Get(url)
 	if err != nil || resp.StatusCode != http.StatusOK {
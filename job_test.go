@@ -0,0 +1,27 @@
+package circleci
+
+import "testing"
+
+func TestGetTestMetadataPages(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/42/tests":               `{"items":[{"name":"TestA"}],"next_page_token":"t2"}`,
+		"api/v2/project/gh/bldmgr/circleci/42/tests?page-token=t2": `{"items":[{"name":"TestB"}],"next_page_token":null}`,
+	}}
+
+	tests := GetTestMetadata(ci, "42", "gh", "bldmgr", "circleci", "none", 1)
+	if len(tests) != 2 || tests[0].Name != "TestA" || tests[1].Name != "TestB" {
+		t.Errorf("GetTestMetadata = %+v, want TestA and TestB", tests)
+	}
+}
+
+func TestGetJobsArtifactsPages(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/42/artifacts":               `{"items":[{"path":"a.txt"}],"next_page_token":"t2"}`,
+		"api/v2/project/gh/bldmgr/circleci/42/artifacts?page-token=t2": `{"items":[{"path":"b.txt"}],"next_page_token":null}`,
+	}}
+
+	artifacts := GetJobsArtifacts(ci, "42", "gh/bldmgr/circleci", "none")
+	if len(artifacts) != 2 || artifacts[0].Path != "a.txt" || artifacts[1].Path != "b.txt" {
+		t.Errorf("GetJobsArtifacts = %+v, want a.txt and b.txt", artifacts)
+	}
+}
@@ -0,0 +1,370 @@
+package circleci
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigWorkflows holds the workflows of a config, skipping the legacy `version` entry
+type ConfigWorkflows map[string]ConfigWorkflow
+
+// UnmarshalYAML decodes every mapping entry of workflows as a ConfigWorkflow
+func (w *ConfigWorkflows) UnmarshalYAML(value *yaml.Node) error {
+	*w = make(ConfigWorkflows)
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		name, node := value.Content[i].Value, value.Content[i+1]
+		if node.Kind != yaml.MappingNode {
+			continue
+		}
+		var workflow ConfigWorkflow
+		if err := node.Decode(&workflow); err != nil {
+			return fmt.Errorf("workflow %s: %w", name, err)
+		}
+		(*w)[name] = workflow
+	}
+
+	return nil
+}
+
+// ConfigWorkflow is a workflow definition
+type ConfigWorkflow struct {
+	Jobs     []WorkflowJobRef `yaml:"jobs" json:"jobs"`
+	When     interface{}      `yaml:"when" json:"when,omitempty"`
+	Unless   interface{}      `yaml:"unless" json:"unless,omitempty"`
+	Triggers interface{}      `yaml:"triggers" json:"triggers,omitempty"`
+}
+
+// WorkflowJobRef is one entry of a workflow's jobs list
+type WorkflowJobRef struct {
+	Job        string                 `json:"job"`
+	Name       string                 `json:"name,omitempty"`
+	Type       string                 `json:"type,omitempty"`
+	Requires   []string               `json:"requires,omitempty"`
+	Context    []string               `json:"context,omitempty"`
+	Matrix     *ConfigMatrix          `json:"matrix,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// workflowJobKeys are the workflow job keys that are not job parameters
+var workflowJobKeys = map[string]bool{
+	"name": true, "type": true, "requires": true, "context": true, "matrix": true,
+	"filters": true, "pre-steps": true, "post-steps": true, "serial-group": true, "override-with": true,
+}
+
+// UnmarshalYAML accepts both `- build` and `- build: {requires: [...]}`
+func (r *WorkflowJobRef) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Job = value.Value
+		return nil
+	}
+	if value.Kind != yaml.MappingNode || len(value.Content) != 2 {
+		return fmt.Errorf("line %d: unexpected workflow job", value.Line)
+	}
+
+	r.Job = value.Content[0].Value
+	body := value.Content[1]
+	if body.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	r.Parameters = make(map[string]interface{})
+	for i := 0; i+1 < len(body.Content); i += 2 {
+		key, node := body.Content[i].Value, body.Content[i+1]
+		var err error
+		switch key {
+		case "name":
+			r.Name = node.Value
+		case "type":
+			r.Type = node.Value
+		case "requires":
+			r.Requires, err = stringList(node)
+		case "context":
+			r.Context, err = stringList(node)
+		case "matrix":
+			r.Matrix = new(ConfigMatrix)
+			err = node.Decode(r.Matrix)
+		default:
+			if !workflowJobKeys[key] {
+				var v interface{}
+				err = node.Decode(&v)
+				r.Parameters[key] = v
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("job %s %s: %w", r.Job, key, err)
+		}
+	}
+
+	return nil
+}
+
+// stringList decodes a scalar or a sequence of scalars
+func stringList(node *yaml.Node) ([]string, error) {
+	if node.Kind == yaml.ScalarNode {
+		return []string{node.Value}, nil
+	}
+	var out []string
+	err := node.Decode(&out)
+
+	return out, err
+}
+
+// MatrixParameter is one matrix axis with its values in declaration order
+type MatrixParameter struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ConfigMatrix is a workflow job matrix
+type ConfigMatrix struct {
+	Alias      string              `json:"alias,omitempty"`
+	Parameters []MatrixParameter   `json:"parameters"`
+	Exclude    []map[string]string `json:"exclude,omitempty"`
+}
+
+// UnmarshalYAML keeps the declaration order of matrix parameters, which drives generated job names,
+// and the literal value text so that 3.10 is not read back as 3.1
+func (m *ConfigMatrix) UnmarshalYAML(value *yaml.Node) error {
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, node := value.Content[i].Value, value.Content[i+1]
+		switch key {
+		case "alias":
+			m.Alias = node.Value
+		case "parameters":
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				values, err := stringList(node.Content[j+1])
+				if err != nil {
+					return err
+				}
+				m.Parameters = append(m.Parameters, MatrixParameter{Name: node.Content[j].Value, Values: values})
+			}
+		case "exclude":
+			for _, item := range node.Content {
+				exclude := make(map[string]string)
+				for j := 0; j+1 < len(item.Content); j += 2 {
+					exclude[item.Content[j].Value] = item.Content[j+1].Value
+				}
+				m.Exclude = append(m.Exclude, exclude)
+			}
+		}
+	}
+
+	return nil
+}
+
+// combinations returns every matrix combination that is not excluded
+func (m *ConfigMatrix) combinations() []map[string]string {
+	out := []map[string]string{{}}
+	for _, p := range m.Parameters {
+		next := make([]map[string]string, 0, len(out)*len(p.Values))
+		for _, combo := range out {
+			for _, v := range p.Values {
+				c := make(map[string]string, len(combo)+1)
+				for k, cv := range combo {
+					c[k] = cv
+				}
+				c[p.Name] = v
+				next = append(next, c)
+			}
+		}
+		out = next
+	}
+
+	filtered := make([]map[string]string, 0, len(out))
+	for _, combo := range out {
+		if !m.excluded(combo) {
+			filtered = append(filtered, combo)
+		}
+	}
+
+	return filtered
+}
+
+func (m *ConfigMatrix) excluded(combo map[string]string) bool {
+	for _, exclude := range m.Exclude {
+		match := true
+		for k, v := range exclude {
+			if combo[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ResolvedJob is a workflow job name resolved to its job definition
+type ResolvedJob struct {
+	Name       string                 `json:"name"`
+	Alias      string                 `json:"alias"`
+	Workflow   string                 `json:"workflow"`
+	Job        string                 `json:"job"`
+	Type       string                 `json:"type,omitempty"`
+	Requires   []string               `json:"requires,omitempty"`
+	Context    []string               `json:"context,omitempty"`
+	Matrix     map[string]string      `json:"matrix,omitempty"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Executor   ConfigExecutor         `json:"executor"`
+	Steps      []interface{}          `json:"steps"`
+}
+
+var (
+	matrixParam = regexp.MustCompile(`<<\s*matrix\.([\w-]+)\s*>>`)
+	jobParam    = regexp.MustCompile(`<<\s*parameters\.([\w-]+)\s*>>`)
+)
+
+// ExpandWorkflow returns every job of a workflow, with matrix jobs expanded into one entry per combination
+func (c *CompiledConfig) ExpandWorkflow(workflow string) []ResolvedJob {
+	w, ok := c.Workflows[workflow]
+	if !ok {
+		return nil
+	}
+
+	jobs := make([]ResolvedJob, 0, len(w.Jobs))
+	for _, ref := range w.Jobs {
+		if ref.Matrix == nil {
+			name := ref.Name
+			if name == "" {
+				name = ref.Job
+			}
+			jobs = append(jobs, c.resolveJob(workflow, ref, name, name, nil))
+			continue
+		}
+
+		alias := ref.Matrix.Alias
+		if alias == "" {
+			alias = ref.Job
+		}
+		for _, combo := range ref.Matrix.combinations() {
+			jobs = append(jobs, c.resolveJob(workflow, ref, matrixJobName(ref, combo), alias, combo))
+		}
+	}
+
+	return jobs
+}
+
+// matrixJobName returns the generated name of a matrix job, either the interpolated `name:`
+// or the job name followed by the parameter values in declaration order
+func matrixJobName(ref WorkflowJobRef, combo map[string]string) string {
+	if ref.Name != "" {
+		return matrixParam.ReplaceAllStringFunc(ref.Name, func(s string) string {
+			return combo[matrixParam.FindStringSubmatch(s)[1]]
+		})
+	}
+
+	parts := []string{ref.Job}
+	for _, p := range ref.Matrix.Parameters {
+		parts = append(parts, combo[p.Name])
+	}
+
+	return strings.Join(parts, "-")
+}
+
+func (c *CompiledConfig) resolveJob(workflow string, ref WorkflowJobRef, name string, alias string, combo map[string]string) ResolvedJob {
+	definition := c.Jobs[ref.Job]
+	executor, _ := c.JobExecutor(ref.Job)
+
+	parameters := make(map[string]interface{})
+	for k, p := range definition.Parameters {
+		if p.Default != nil {
+			parameters[k] = p.Default
+		}
+	}
+	for k, v := range ref.Parameters {
+		if s, ok := v.(string); ok {
+			v = matrixParam.ReplaceAllStringFunc(s, func(m string) string {
+				return combo[matrixParam.FindStringSubmatch(m)[1]]
+			})
+		}
+		parameters[k] = v
+	}
+	for k, v := range combo {
+		parameters[k] = v
+	}
+
+	executor.ResourceClass = substituteParameters(executor.ResourceClass, parameters).(string)
+	docker := make([]ConfigDocker, len(executor.Docker))
+	for i, d := range executor.Docker {
+		d.Image = substituteParameters(d.Image, parameters).(string)
+		docker[i] = d
+	}
+	executor.Docker = docker
+
+	steps := make([]interface{}, 0)
+	if definition.Steps != nil {
+		steps = substituteParameters(definition.Steps, parameters).([]interface{})
+	}
+
+	return ResolvedJob{
+		Name:       name,
+		Alias:      alias,
+		Workflow:   workflow,
+		Job:        ref.Job,
+		Type:       ref.Type,
+		Requires:   ref.Requires,
+		Context:    ref.Context,
+		Matrix:     combo,
+		Parameters: parameters,
+		Executor:   executor,
+		Steps:      steps,
+	}
+}
+
+// substituteParameters replaces << parameters.x >> in every string of a decoded yaml value
+func substituteParameters(v interface{}, parameters map[string]interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return jobParam.ReplaceAllStringFunc(t, func(m string) string {
+			if p, ok := parameters[jobParam.FindStringSubmatch(m)[1]]; ok {
+				return fmt.Sprint(p)
+			}
+			return m
+		})
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i := range t {
+			out[i] = substituteParameters(t[i], parameters)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			out[k] = substituteParameters(val, parameters)
+		}
+		return out
+	}
+
+	return v
+}
+
+// ResolveJob maps a WorkflowItem.Name, including generated matrix names and `name:` aliases,
+// back to its job definition. Workflows are searched in name order and a plain job name is the fallback.
+func (c *CompiledConfig) ResolveJob(name string) (ResolvedJob, bool) {
+	workflows := make([]string, 0, len(c.Workflows))
+	for w := range c.Workflows {
+		workflows = append(workflows, w)
+	}
+	sort.Strings(workflows)
+
+	for _, w := range workflows {
+		for _, job := range c.ExpandWorkflow(w) {
+			if job.Name == name {
+				return job, true
+			}
+		}
+	}
+
+	if _, ok := c.Jobs[name]; ok {
+		return c.resolveJob("", WorkflowJobRef{Job: name}, name, name, nil), true
+	}
+
+	return ResolvedJob{}, false
+}
@@ -0,0 +1,134 @@
+package circleci
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testMatrixConfig = `
+version: 2.1
+jobs:
+  lint:
+    docker:
+      - image: cimg/go:1.22
+    steps:
+      - run: golangci-lint run
+  test:
+    parameters:
+      go:
+        type: string
+        default: "1.22"
+      os:
+        type: string
+        default: linux
+    docker:
+      - image: cimg/go:<< parameters.go >>
+    steps:
+      - run: go test -tags << parameters.os >> ./...
+  build:
+    docker:
+      - image: cimg/base:current
+    steps:
+      - run: make << parameters.arch >>
+workflows:
+  version: 2
+  ci:
+    jobs:
+      - lint
+      - test:
+          matrix:
+            parameters:
+              go: ["1.21", "3.10"]
+              os: [linux, mac]
+            exclude:
+              - go: "1.21"
+                os: mac
+          requires: [lint]
+      - build:
+          name: build-<< matrix.arch >>
+          matrix:
+            alias: builds
+            parameters:
+              arch: [amd64, arm64]
+      - lint:
+          name: lint-again
+          context: [org]
+`
+
+func TestExpandWorkflow(t *testing.T) {
+	c, err := ParseConfig([]byte(testMatrixConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExpandWorkflow("version") != nil || c.ExpandWorkflow("missing") != nil {
+		t.Error("expanded a workflow that does not exist")
+	}
+
+	tests := []struct {
+		name   string
+		alias  string
+		job    string
+		matrix map[string]string
+		image  string
+		step   string
+	}{
+		{name: "lint", alias: "lint", job: "lint", image: "cimg/go:1.22", step: "golangci-lint run"},
+		{name: "test-1.21-linux", alias: "test", job: "test", matrix: map[string]string{"go": "1.21", "os": "linux"}, image: "cimg/go:1.21", step: "go test -tags linux ./..."},
+		{name: "test-3.10-linux", alias: "test", job: "test", matrix: map[string]string{"go": "3.10", "os": "linux"}, image: "cimg/go:3.10", step: "go test -tags linux ./..."},
+		{name: "test-3.10-mac", alias: "test", job: "test", matrix: map[string]string{"go": "3.10", "os": "mac"}, image: "cimg/go:3.10", step: "go test -tags mac ./..."},
+		{name: "build-amd64", alias: "builds", job: "build", matrix: map[string]string{"arch": "amd64"}, image: "cimg/base:current", step: "make amd64"},
+		{name: "build-arm64", alias: "builds", job: "build", matrix: map[string]string{"arch": "arm64"}, image: "cimg/base:current", step: "make arm64"},
+		{name: "lint-again", alias: "lint-again", job: "lint", image: "cimg/go:1.22", step: "golangci-lint run"},
+	}
+
+	jobs := c.ExpandWorkflow("ci")
+	if len(jobs) != len(tests) {
+		names := make([]string, 0, len(jobs))
+		for _, j := range jobs {
+			names = append(names, j.Name)
+		}
+		t.Fatalf("expanded %v, want %d jobs", names, len(tests))
+	}
+	for i, tt := range tests {
+		j := jobs[i]
+		if j.Name != tt.name || j.Alias != tt.alias || j.Job != tt.job || j.Workflow != "ci" {
+			t.Errorf("job %d = %s alias %s of %s in %s, want %s alias %s of %s", i, j.Name, j.Alias, j.Job, j.Workflow, tt.name, tt.alias, tt.job)
+		}
+		if len(tt.matrix) > 0 && !reflect.DeepEqual(j.Matrix, tt.matrix) {
+			t.Errorf("%s matrix = %v, want %v", tt.name, j.Matrix, tt.matrix)
+		}
+		if j.Executor.Image() != tt.image {
+			t.Errorf("%s image = %q, want %q", tt.name, j.Executor.Image(), tt.image)
+		}
+		if got := stepDisplayName(j.Steps[0]); got != tt.step {
+			t.Errorf("%s step = %q, want %q", tt.name, got, tt.step)
+		}
+	}
+	if !reflect.DeepEqual(jobs[1].Requires, []string{"lint"}) || !reflect.DeepEqual(jobs[6].Context, []string{"org"}) {
+		t.Errorf("requires %v and context %v not kept", jobs[1].Requires, jobs[6].Context)
+	}
+}
+
+func TestMatrixJobName(t *testing.T) {
+	matrix := &ConfigMatrix{Parameters: []MatrixParameter{{Name: "os", Values: []string{"linux"}}, {Name: "go", Values: []string{"1.22"}}}}
+	combo := map[string]string{"go": "1.22", "os": "linux"}
+
+	tests := []struct {
+		name string
+		ref  WorkflowJobRef
+		want string
+	}{
+		{name: "declaration order", ref: WorkflowJobRef{Job: "test", Matrix: matrix}, want: "test-linux-1.22"},
+		{name: "interpolated name", ref: WorkflowJobRef{Job: "test", Name: "test-<< matrix.go >>-on-<<matrix.os>>", Matrix: matrix}, want: "test-1.22-on-linux"},
+		{name: "name without parameters", ref: WorkflowJobRef{Job: "test", Name: "tests", Matrix: matrix}, want: "tests"},
+		{name: "unknown parameter", ref: WorkflowJobRef{Job: "test", Name: "test-<< matrix.arch >>", Matrix: matrix}, want: "test-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matrixJobName(tt.ref, combo); got != tt.want {
+				t.Errorf("matrixJobName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrbUsage is a single orb reference found in a project's latest pipeline
+type OrbUsage struct {
+	ProjectSlug string    `json:"project_slug"`
+	PipelineID  string    `json:"pipeline_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Alias       string    `json:"alias"`
+	Orb         string    `json:"orb"`
+	Version     string    `json:"version"`
+	Embedded    bool      `json:"embedded"`
+}
+
+// OrbVersion lists the projects using one version of an orb
+type OrbVersion struct {
+	Version  string   `json:"version"`
+	Projects []string `json:"projects"`
+}
+
+// OrbMatrix groups every version of an orb seen in the org
+type OrbMatrix struct {
+	Orb      string       `json:"orb"`
+	Newest   string       `json:"newest"`
+	Versions []OrbVersion `json:"versions"`
+}
+
+// OrbDrift flags a project whose orb version is unpinned or behind the newest seen in the org
+type OrbDrift struct {
+	ProjectSlug string `json:"project_slug"`
+	Orb         string `json:"orb"`
+	Version     string `json:"version"`
+	Newest      string `json:"newest"`
+	Unpinned    bool   `json:"unpinned"`
+	Behind      bool   `json:"behind"`
+}
+
+// OrbReport is the org wide orb inventory returned by GetOrbInventory
+type OrbReport struct {
+	Org    string      `json:"org"`
+	Usages []OrbUsage  `json:"usages"`
+	Matrix []OrbMatrix `json:"matrix"`
+	Drift  []OrbDrift  `json:"drift"`
+	Errors []ScanError `json:"errors"`
+}
+
+// ScanError records a project that could not be scanned
+type ScanError struct {
+	ProjectSlug string `json:"project_slug"`
+	PipelineID  string `json:"pipeline_id"`
+	Message     string `json:"message"`
+}
+
+// latestPipelines keeps the most recent pipeline of every project found in items
+func latestPipelines(items []PipelineItem) []PipelineItem {
+	latest := make(map[string]PipelineItem)
+	for _, item := range items {
+		if current, ok := latest[item.ProjectSlug]; !ok || item.CreatedAt.After(current.CreatedAt) {
+			latest[item.ProjectSlug] = item
+		}
+	}
+
+	out := make([]PipelineItem, 0, len(latest))
+	for _, item := range latest {
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ProjectSlug < out[j].ProjectSlug
+	})
+
+	return out
+}
+
+// GetOrbInventory scans the latest pipeline of every project in an org and reports the orbs in use
+func GetOrbInventory(ci CI, org string, output string, page int) (report OrbReport) {
+	report = OrbReport{
+		Org:    org,
+		Usages: make([]OrbUsage, 0),
+		Matrix: make([]OrbMatrix, 0),
+		Drift:  make([]OrbDrift, 0),
+		Errors: make([]ScanError, 0),
+	}
+
+	for _, pipeline := range latestPipelines(GetPipeline(ci, org, "none", page)) {
+		p, err := fetchPipelineConfig(context.Background(), ci, pipeline.ID)
+		if err != nil {
+			report.Errors = append(report.Errors, ScanError{
+				ProjectSlug: pipeline.ProjectSlug,
+				PipelineID:  pipeline.ID,
+				Message:     err.Error(),
+			})
+			continue
+		}
+		report.Usages = append(report.Usages, orbUsages(pipeline, processParms([]byte(p.Source), "orbs"))...)
+	}
+
+	report.Matrix, report.Drift = orbMatrix(report.Usages)
+
+	if output == "json" {
+		out, err := json.Marshal(report)
+		if err != nil {
+			fmt.Printf("could not marshal orb report: %v", err)
+		}
+		fmt.Printf(string(out) + "\n")
+	}
+
+	if output == "status" {
+		for _, d := range report.Drift {
+			fmt.Printf("%s: %s@%s -> newest %s (unpinned: %t, behind: %t)\n", d.ProjectSlug, d.Orb, d.Version, d.Newest, d.Unpinned, d.Behind)
+		}
+	}
+
+	return report
+}
+
+// orbUsages converts the orbs returned by processParms into OrbUsage records
+func orbUsages(pipeline PipelineItem, orbs []ViperSub) []OrbUsage {
+	usages := make([]OrbUsage, 0, len(orbs))
+	for _, o := range orbs {
+		// processParms marks inline orb definitions as "<alias>@embedded" with type "orbs"
+		if o.Type == "orbs" && strings.HasSuffix(o.Name, "@embedded") {
+			usages = append(usages, OrbUsage{
+				ProjectSlug: pipeline.ProjectSlug,
+				PipelineID:  pipeline.ID,
+				CreatedAt:   pipeline.CreatedAt,
+				Alias:       strings.TrimSuffix(o.Name, "@embedded"),
+				Orb:         strings.TrimSuffix(o.Name, "@embedded"),
+				Version:     "embedded",
+				Embedded:    true,
+			})
+			continue
+		}
+		name, version := splitOrbRef(o.Type)
+		usages = append(usages, OrbUsage{
+			ProjectSlug: pipeline.ProjectSlug,
+			PipelineID:  pipeline.ID,
+			CreatedAt:   pipeline.CreatedAt,
+			Alias:       o.Name,
+			Orb:         name,
+			Version:     version,
+		})
+	}
+
+	return usages
+}
+
+// orbMatrix builds the orb -> version -> projects matrix and the drift list
+func orbMatrix(usages []OrbUsage) (matrix []OrbMatrix, drift []OrbDrift) {
+	byOrb := make(map[string]map[string][]string)
+	for _, u := range usages {
+		if u.Embedded {
+			continue
+		}
+		if byOrb[u.Orb] == nil {
+			byOrb[u.Orb] = make(map[string][]string)
+		}
+		byOrb[u.Orb][u.Version] = appendUniqueString(byOrb[u.Orb][u.Version], u.ProjectSlug)
+	}
+
+	matrix = make([]OrbMatrix, 0, len(byOrb))
+	newest := make(map[string]string)
+	for orb, versions := range byOrb {
+		m := OrbMatrix{Orb: orb, Versions: make([]OrbVersion, 0, len(versions))}
+		for version, projects := range versions {
+			sort.Strings(projects)
+			m.Versions = append(m.Versions, OrbVersion{Version: version, Projects: projects})
+			if isPinnedOrbVersion(version) && (m.Newest == "" || compareVersions(version, m.Newest) > 0) {
+				m.Newest = version
+			}
+		}
+		sort.Slice(m.Versions, func(i, j int) bool {
+			return compareVersions(m.Versions[i].Version, m.Versions[j].Version) > 0
+		})
+		newest[orb] = m.Newest
+		matrix = append(matrix, m)
+	}
+	sort.Slice(matrix, func(i, j int) bool {
+		return matrix[i].Orb < matrix[j].Orb
+	})
+
+	drift = make([]OrbDrift, 0)
+	for _, u := range usages {
+		if u.Embedded {
+			continue
+		}
+		unpinned := !isPinnedOrbVersion(u.Version)
+		behind := !unpinned && newest[u.Orb] != "" && compareVersions(u.Version, newest[u.Orb]) < 0
+		if unpinned || behind {
+			drift = append(drift, OrbDrift{
+				ProjectSlug: u.ProjectSlug,
+				Orb:         u.Orb,
+				Version:     u.Version,
+				Newest:      newest[u.Orb],
+				Unpinned:    unpinned,
+				Behind:      behind,
+			})
+		}
+	}
+
+	return matrix, drift
+}
+
+// splitOrbRef splits "namespace/orb@version" into its name and version
+func splitOrbRef(ref string) (name string, version string) {
+	if i := strings.LastIndexByte(ref, '@'); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+
+	return ref, ""
+}
+
+// isPinnedOrbVersion reports whether version is a full major.minor.patch release.
+// "volatile", "dev:*" and partial versions such as "5" or "5.1" float and are not pinned.
+func isPinnedOrbVersion(version string) bool {
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, p := range parts {
+		if _, err := strconv.Atoi(p); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compareVersions compares two dotted numeric versions, returning -1, 0 or 1.
+// Non numeric versions sort before numeric ones.
+func compareVersions(a string, b string) int {
+	pa := strings.Split(a, ".")
+	pb := strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		na, nb := -1, -1
+		if i < len(pa) {
+			if n, err := strconv.Atoi(pa[i]); err == nil {
+				na = n
+			}
+		}
+		if i < len(pb) {
+			if n, err := strconv.Atoi(pb[i]); err == nil {
+				nb = n
+			}
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return strings.Compare(a, b)
+}
+
+// appendUniqueString adds value to the slice only if it is not already present
+func appendUniqueString(items []string, value string) []string {
+	for _, item := range items {
+		if item == value {
+			return items
+		}
+	}
+
+	return append(items, value)
+}
@@ -0,0 +1,86 @@
+package circleci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"5.1.0", "5.1.0", 0},
+		{"5.1.1", "5.1.0", 1},
+		{"5.0.9", "5.1.0", -1},
+		{"10.0.0", "9.9.9", 1},
+		{"5.1", "5.1.0", -1},
+		{"5", "4.9.9", 1},
+		{"volatile", "1.0.0", -1},
+		{"1.0.0", "dev:alpha", 1},
+		{"dev:alpha", "dev:beta", -1},
+	}
+
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestIsPinnedOrbVersion(t *testing.T) {
+	tests := map[string]bool{
+		"5.1.0":     true,
+		"10.20.30":  true,
+		"5":         false,
+		"5.1":       false,
+		"5.1.x":     false,
+		"5.1.0.1":   false,
+		"volatile":  false,
+		"dev:alpha": false,
+		"":          false,
+	}
+
+	for version, want := range tests {
+		if got := isPinnedOrbVersion(version); got != want {
+			t.Errorf("isPinnedOrbVersion(%q) = %t, want %t", version, got, want)
+		}
+	}
+}
+
+func TestOrbMatrix(t *testing.T) {
+	usages := []OrbUsage{
+		{ProjectSlug: "gh/bldmgr/api", Orb: "circleci/node", Version: "5.1.0"},
+		{ProjectSlug: "gh/bldmgr/web", Orb: "circleci/node", Version: "5.0.2"},
+		{ProjectSlug: "gh/bldmgr/web", Orb: "circleci/docker", Version: "2.0.0"},
+		{ProjectSlug: "gh/bldmgr/cli", Orb: "circleci/node", Version: "5"},
+		{ProjectSlug: "gh/bldmgr/api", Orb: "circleci/node", Version: "5.1.0"},
+		{ProjectSlug: "gh/bldmgr/cli", Orb: "bldmgr/tools", Version: "dev:alpha"},
+		{ProjectSlug: "gh/bldmgr/api", Orb: "slack", Version: "embedded", Embedded: true},
+	}
+
+	matrix, drift := orbMatrix(usages)
+
+	wantMatrix := []OrbMatrix{
+		{Orb: "bldmgr/tools", Newest: "", Versions: []OrbVersion{{Version: "dev:alpha", Projects: []string{"gh/bldmgr/cli"}}}},
+		{Orb: "circleci/docker", Newest: "2.0.0", Versions: []OrbVersion{{Version: "2.0.0", Projects: []string{"gh/bldmgr/web"}}}},
+		{Orb: "circleci/node", Newest: "5.1.0", Versions: []OrbVersion{
+			{Version: "5.1.0", Projects: []string{"gh/bldmgr/api"}},
+			{Version: "5.0.2", Projects: []string{"gh/bldmgr/web"}},
+			{Version: "5", Projects: []string{"gh/bldmgr/cli"}},
+		}},
+	}
+	if !reflect.DeepEqual(matrix, wantMatrix) {
+		t.Errorf("matrix = %+v, want %+v", matrix, wantMatrix)
+	}
+
+	// drift follows the order of usages, embedded orbs have no version to drift from
+	wantDrift := []OrbDrift{
+		{ProjectSlug: "gh/bldmgr/web", Orb: "circleci/node", Version: "5.0.2", Newest: "5.1.0", Behind: true},
+		{ProjectSlug: "gh/bldmgr/cli", Orb: "circleci/node", Version: "5", Newest: "5.1.0", Unpinned: true},
+		{ProjectSlug: "gh/bldmgr/cli", Orb: "bldmgr/tools", Version: "dev:alpha", Unpinned: true},
+	}
+	if !reflect.DeepEqual(drift, wantDrift) {
+		t.Errorf("drift = %+v, want %+v", drift, wantDrift)
+	}
+}
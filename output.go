@@ -0,0 +1,73 @@
+package circleci
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+)
+
+const (
+	// maxLineSize is the longest log line the line helpers accept, minified bundles and
+	// progress bars easily exceed bufio's 64KB default
+	maxLineSize = 4 * 1024 * 1024
+)
+
+// JobRef identifies a job by its project slug and job number
+type JobRef struct {
+	ProjectSlug string `json:"project_slug"`
+	JobNumber   int    `json:"job_number"`
+}
+
+// Ref returns the JobRef of a workflow job
+func (w WorkflowItem) Ref() JobRef {
+	return JobRef{ProjectSlug: w.ProjectSlug, JobNumber: w.JobNumber}
+}
+
+// OpenStepOutput streams the output of one step of a job on one parallel node.
+// The caller must close the returned reader.
+func OpenStepOutput(ctx context.Context, ci CI, job JobRef, step string, node int) (io.ReadCloser, error) {
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	url := fmt.Sprintf(restGetJobData, vcs, namespace, project, strconv.Itoa(job.JobNumber), step, node)
+
+	body, _, err := openEndpoint(ctx, ci, url)
+	if err != nil {
+		return nil, fmt.Errorf("job %d step %s node %d output: %w", job.JobNumber, step, node, err)
+	}
+
+	return body, nil
+}
+
+// ForEachLine calls fn for every line of r until fn returns false or r is exhausted
+func ForEachLine(r io.Reader, fn func(n int, line string) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for n := 1; scanner.Scan(); n++ {
+		if !fn(n, scanner.Text()) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Lines returns an iterator over the lines of r. A read error is yielded as the last element.
+func Lines(r io.Reader) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		stopped := false
+		err := ForEachLine(r, func(_ int, line string) bool {
+			stopped = !yield(line, nil)
+			return !stopped
+		})
+		if err != nil && !stopped {
+			yield("", err)
+		}
+	}
+}
+
+// Open streams the output of a step returned by GetConfigWithWorkflow
+func (s JobDataSteps) Open(ctx context.Context, ci CI) (io.ReadCloser, error) {
+	return OpenStepOutput(ctx, ci, s.Job, s.ID, 0)
+}
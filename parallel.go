@@ -0,0 +1,153 @@
+package circleci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NodeOutput is the output of a step on one parallel node
+type NodeOutput struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StepOutput holds the output of a step for every parallel node of a job
+type StepOutput struct {
+	Job   JobRef       `json:"job"`
+	Step  string       `json:"step"`
+	Nodes []NodeOutput `json:"nodes"`
+}
+
+// GetJobDetailsRef returns the details of the job identified by job
+func GetJobDetailsRef(ci CI, job JobRef) JobDetails {
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	return GetJobDetails(ci, strconv.Itoa(job.JobNumber), vcs, namespace, project, "none")
+}
+
+// jobNodes returns the parallel runs of a job, node 0 alone when the job details are unavailable
+func jobNodes(ci CI, job JobRef) []ParallelRun {
+	details := GetJobDetailsRef(ci, job)
+	if len(details.ParallelRuns) > 0 {
+		return details.ParallelRuns
+	}
+
+	runs := make([]ParallelRun, 0)
+	for _, index := range details.NodeIndexes() {
+		runs = append(runs, ParallelRun{Index: index, Status: details.Status})
+	}
+
+	return runs
+}
+
+// GetStepOutput reads the output of a step from every parallel run listed in JobDetails.ParallelRuns.
+// A node that cannot be read is reported in its Error field instead of failing the whole step.
+func GetStepOutput(ctx context.Context, ci CI, job JobRef, step string) (out StepOutput, err error) {
+	out = StepOutput{Job: job, Step: step, Nodes: make([]NodeOutput, 0)}
+
+	for _, run := range jobNodes(ci, job) {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		node := NodeOutput{Index: run.Index, Status: run.Status}
+		r, err := OpenStepOutput(ctx, ci, job, step, run.Index)
+		if err != nil {
+			node.Error = err.Error()
+			out.Nodes = append(out.Nodes, node)
+			continue
+		}
+		var sb strings.Builder
+		_, err = io.Copy(&sb, r)
+		r.Close()
+		if err != nil {
+			node.Error = err.Error()
+		}
+		node.Output = sb.String()
+		out.Nodes = append(out.Nodes, node)
+	}
+
+	return out, nil
+}
+
+// Merged returns the output of every node with each line prefixed by its node index
+func (s StepOutput) Merged() string {
+	var sb strings.Builder
+	for _, node := range s.Nodes {
+		prefix := fmt.Sprintf("[node %d] ", node.Index)
+		ForEachLine(strings.NewReader(node.Output), func(_ int, line string) bool {
+			sb.WriteString(prefix)
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+			return true
+		})
+	}
+
+	return sb.String()
+}
+
+// Separated returns the output of every node in its own section
+func (s StepOutput) Separated() string {
+	var sb strings.Builder
+	for _, node := range s.Nodes {
+		sb.WriteString(nodeHeader(node.Index, node.Status))
+		sb.WriteString(node.Output)
+		if node.Output != "" && !strings.HasSuffix(node.Output, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String()
+}
+
+func nodeHeader(index int, status string) string {
+	return fmt.Sprintf("==> node %d (%s) <==\n", index, status)
+}
+
+// OpenParallelStepOutput streams the output of a step from every parallel node one after the other.
+// With prefix set each line is tagged with its node index, otherwise each node gets a header.
+func OpenParallelStepOutput(ctx context.Context, ci CI, job JobRef, step string, prefix bool) (io.ReadCloser, error) {
+	runs := jobNodes(ci, job)
+	pr, pw := io.Pipe()
+
+	go func() {
+		for _, run := range runs {
+			r, err := OpenStepOutput(ctx, ci, job, step, run.Index)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if prefix {
+				tag := fmt.Sprintf("[node %d] ", run.Index)
+				var werr error
+				err = ForEachLine(r, func(_ int, line string) bool {
+					_, werr = io.WriteString(pw, tag+line+"\n")
+					return werr == nil
+				})
+				if err == nil {
+					err = werr
+				}
+			} else {
+				if _, err = io.WriteString(pw, nodeHeader(run.Index, run.Status)); err == nil {
+					_, err = io.Copy(pw, r)
+				}
+			}
+			r.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// OpenNode streams the output of a step returned by GetConfigWithWorkflow on one parallel node
+func (s JobDataSteps) OpenNode(ctx context.Context, ci CI, node int) (io.ReadCloser, error) {
+	return OpenStepOutput(ctx, ci, s.Job, s.ID, node)
+}
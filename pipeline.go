@@ -3,16 +3,20 @@ package circleci
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/bldmgr/circleci/pkg/logs"
 	"github.com/spf13/viper"
 )
 
@@ -21,6 +25,7 @@ const (
 	restPipelineId        = "api/v2/pipeline/%s"
 	restPipelineWorkflows = "api/v2/pipeline/%s/workflow"
 	restPipelineConfig    = "api/v2/pipeline/%s/config"
+	restProjectPipeline   = "api/v2/project/%s/pipeline"
 )
 
 type listPipelineResponse struct {
@@ -131,7 +136,7 @@ func GetPipelineWorkflows(ci CI, pipelineId string, output string) (items []Pipe
 		url := fmt.Sprintf(restPipelineWorkflows, pipelineId)
 
 		if continuation != "" {
-			url += "&continuationToken=" + continuation
+			url = withPageToken(url, continuation)
 		}
 
 		body, resp, err := ci.Get(url)
@@ -182,6 +187,21 @@ type PipelineConfig struct {
 	CompiledSetupConfig string `json:"compiled-setup-config"`
 }
 
+// fetchPipelineConfig returns the source and compiled configuration of a pipeline
+func fetchPipelineConfig(ctx context.Context, ci CI, pipelineId string) (p PipelineConfig, err error) {
+	url := fmt.Sprintf(restPipelineConfig, pipelineId)
+	body, resp, err := getWithContext(ctx, ci, url)
+	if err != nil {
+		return p, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return p, fmt.Errorf("pipeline %s config: %s", pipelineId, resp.Status)
+	}
+
+	err = json.Unmarshal(body, &p)
+	return p, err
+}
+
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -205,12 +225,12 @@ func GetConfigWithWorkflow(ci CI, jobs []WorkflowItem, workflows []PipelineWorkf
 	}
 
 	circleciSource := []byte(p.Source)
-	configCompiled := []byte(p.Compiled)
+	configCompiled := selectCompiledConfig(p, jobs[j].Name)
 	orbs = processParms(circleciSource, "orbs")
 	parameters = processParms(circleciSource, "parameters")
 
 	project, vcs, namespace := formatProjectSlug(workflows[w].ProjectSlug)
-	returnDataSet, returnEnvConfig := processJobs(ci, jobs[j].Name, jobs[j].JobNumber, project, namespace, vcs, output, configCompiled)
+	returnDataSet, returnEnvConfig := processJobs(context.Background(), ci, jobs[j].Name, jobs[j].JobNumber, project, namespace, vcs, output, true, configCompiled)
 
 	return returnDataSet, returnEnvConfig, orbs, parameters
 }
@@ -415,17 +435,7 @@ type listGetPipeline struct {
 	ContinuationToken string         `json:"next_page_token"`
 }
 
-type watcherCmd struct {
-	host           string
-	token          string
-	namespace      string
-	pipelineId     string
-	action         string
-	pipelineNumber string
-	createDate     string
-}
-
-func (cmd *watcherCmd) run(ci CI, org string, output string, maxPage int) (items []PipelineItem) {
+func GetPipeline(ci CI, org string, output string, page int) (items []PipelineItem) {
 	continuation := ""
 	get := func() (listResp listGetPipeline, err error) {
 		url := fmt.Sprintf(restPipeline, org)
@@ -438,8 +448,10 @@ func (cmd *watcherCmd) run(ci CI, org string, output string, maxPage int) (items
 
 		body, resp, err := ci.Get(url)
 		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Println(url)
-			fmt.Println(resp.Status)
+			if resp != nil {
+				fmt.Println(url)
+				fmt.Println(resp.Status)
+			}
 			return
 		}
 
@@ -456,7 +468,7 @@ func (cmd *watcherCmd) run(ci CI, org string, output string, maxPage int) (items
 	}
 
 	items = make([]PipelineItem, 0)
-	for i := 0; i < maxPage; i++ {
+	for i := 0; i < page; i++ {
 		resp, err := get()
 		if err != nil {
 			return items
@@ -471,24 +483,27 @@ func (cmd *watcherCmd) run(ci CI, org string, output string, maxPage int) (items
 		continuation = resp.ContinuationToken
 	}
 
+	if output == "xstatus" {
+		for i := range items {
+			fmt.Printf("%v: %s -> %s --> %s -> %s\n", items[i].Number, items[i].ProjectSlug, items[i].ID, items[i].State, items[i].Trigger.Actor.Login)
+		}
+	}
+
 	return items
 }
 
-func GetPipeline(ci CI, org string, output string, page int) (items []PipelineItem) {
+// GetProjectPipelines returns the pipelines of a project, most recent first. An empty branch lists every branch.
+func GetProjectPipelines(ci CI, projectSlug string, branch string, output string, page int) (items []PipelineItem) {
 	continuation := ""
 	get := func() (listResp listGetPipeline, err error) {
-		url := fmt.Sprintf(restPipeline, org)
+		url := projectPipelinesURL(projectSlug, branch)
 
 		if continuation != "" {
-			url += "&page-token=" + continuation
+			url = withPageToken(url, continuation)
 		}
 
-		url += "&mine=false"
-
 		body, resp, err := ci.Get(url)
 		if err != nil || resp.StatusCode != http.StatusOK {
-			fmt.Println(url)
-			fmt.Println(resp.Status)
 			return
 		}
 
@@ -520,13 +535,17 @@ func GetPipeline(ci CI, org string, output string, page int) (items []PipelineIt
 		continuation = resp.ContinuationToken
 	}
 
-	if output == "xstatus" {
-		for i := range items {
-			fmt.Printf("%v: %s -> %s --> %s -> %s\n", items[i].Number, items[i].ProjectSlug, items[i].ID, items[i].State, items[i].Trigger.Actor.Login)
-		}
+	return items
+}
+
+// projectPipelinesURL lists the pipelines of a project, of every branch when branch is empty
+func projectPipelinesURL(projectSlug string, branch string) string {
+	url := fmt.Sprintf(restProjectPipeline, projectSlug)
+	if branch != "" {
+		url += "?branch=" + neturl.QueryEscape(branch)
 	}
 
-	return items
+	return url
 }
 
 type Cache struct {
@@ -657,217 +676,207 @@ func processParms(circleciConfig []byte, viperSub string) []ViperSub {
 	return viperItems
 }
 
-func processJobs(ci CI, workflowName string, jobNumber int, projectName string, namespace string, vsc string, output string, configCompiled []byte) (Steps []JobDataSteps, Env []JobDataEnvironment) {
-	viper.SetConfigType("yaml")
-	viper.ReadConfig(bytes.NewBuffer(configCompiled))
-
-	ghSha := ""
-	getSteps := func(jobsSteps []interface{}, sum int) (Steps []JobDataSteps) {
-		dataSteps := make([]JobDataSteps, 0)
+// processJobs returns the steps of a job. The spin up and environment steps are only streamed and parsed
+// into Env when environment is set.
+func processJobs(ctx context.Context, ci CI, workflowName string, jobNumber int, projectName string, namespace string, vsc string, output string, environment bool, configCompiled []byte) (Steps []JobDataSteps, Env []JobDataEnvironment) {
+	job := JobRef{ProjectSlug: fmt.Sprintf("%s/%s/%s", vsc, namespace, projectName), JobNumber: jobNumber}
+	// step outputs are only held in memory for the "data" output, otherwise JobDataSteps.Open streams them on demand
+	keep := output == "data"
 
-		data := ""
-		data_name := ""
-		data_command := ""
-		data_path := ""
-		data_key := ""
-		data_when := ""
-
-		for _, steps := range jobsSteps {
-			sum++
-			switch v := steps.(type) {
-			case string:
-				data_name = v
-				if v == "checkout" {
-					if output == "data" {
-						data = string(GetJobData(ci, strconv.Itoa(jobNumber), vsc, namespace, projectName, strconv.Itoa(sum), ""))
-					}
-				}
-			default:
-				stepsMap := steps.(map[string]interface{})
-				for stepsName, stepsValue := range stepsMap {
-					data_command = ""
-					data_path = ""
-					data_key = ""
-					data_when = ""
-					data_name = stepsName
-					switch v := stepsValue.(type) {
-					case string:
-						data_name = v
-						if output == "data" {
-							data = string(GetJobData(ci, strconv.Itoa(jobNumber), vsc, namespace, projectName, strconv.Itoa(sum), ""))
-						}
-					default:
-						jobDetails := stepsValue.(map[string]interface{})
-						for key, value := range jobDetails {
-							if key == "command" {
-								data_command = fmt.Sprintf("%v", value)
-							}
-							if key == "path" {
-								data_path = fmt.Sprintf("%v", value)
-							}
-							if key == "when" {
-								data_when = fmt.Sprintf("%v", value)
-							}
-							if key == "key" {
-								data_key = fmt.Sprintf("%v", value)
-							}
-						}
-					}
-					if output == "data" {
-						data = string(GetJobData(ci, strconv.Itoa(jobNumber), vsc, namespace, projectName, strconv.Itoa(sum), ""))
-					}
-				}
-			}
-			dataSteps = append(dataSteps, JobDataSteps{
-				ID:      strconv.Itoa(sum),
-				Name:    data_name,
-				Command: data_command,
-				Key:     data_key,
-				Path:    data_path,
-				When:    data_when,
-				Output:  data,
-			})
-		}
+	// resolve matrix and `name:` aliased jobs back to their job definition
+	configSteps := make([]interface{}, 0)
+	c, err := ParseConfig(configCompiled)
+	if err != nil {
+		fmt.Printf("could not read compiled config: %v", err)
+	} else if resolved, ok := c.ResolveJob(workflowName); ok {
+		configSteps = resolved.Steps
+	}
 
-		return dataSteps
+	// map the steps CircleCI actually ran back to the config, the fixed numbering is only
+	// used when the build details of the job are unavailable
+	executed := GetExecutedSteps(ci, job, 0, configSteps)
+	if len(executed) == 0 {
+		executed = legacySteps(configSteps)
 	}
 
 	dataSteps := make([]JobDataSteps, 0)
 	dataEnvironment := make([]JobDataEnvironment, 0)
-	data := string(GetJobData(ci, strconv.Itoa(jobNumber), vsc, namespace, projectName, "0", ""))
-	jobHost := string(data) + "\n"
-	outAgent, outRunner, outVm, outImage, outVolume := parseVariables(jobHost, "Build-agent version ", "Launch-agent version ", "Using volume:", "default", "  using image ")
-	dataSteps = append(dataSteps, JobDataSteps{
-		ID:      "0",
-		Name:    "Spin up environment",
-		Command: "",
-		Key:     "",
-		Path:    "",
-		When:    "",
-		Output:  data,
-	})
-
-	data = string(GetJobData(ci, strconv.Itoa(jobNumber), vsc, namespace, projectName, "99", ""))
-	jobEnvironment := string(data) + "\n"
-	dataSteps = append(dataSteps, JobDataSteps{
-		ID:      "99",
-		Name:    "Preparing environment variables",
-		Command: "",
-		Key:     "",
-		Path:    "",
-		When:    "",
-		Output:  data,
-	})
-	dataReader := strings.NewReader(jobEnvironment)
-	scanner := bufio.NewScanner(dataReader)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "CIRCLE_SHA1") == true {
-			ghSha = strings.Replace(line, "  CIRCLE_SHA1=", "", -1)
+	var spinUp logs.SpinUp
+	var env logs.Environment
+	for _, e := range executed {
+		var parse func(r io.Reader)
+		if environment && e.Name == StepSpinUp {
+			parse = func(r io.Reader) {
+				spinUp, _ = logs.ParseSpinUp(r)
+			}
+		}
+		if environment && e.Name == StepPrepareEnv {
+			parse = func(r io.Reader) {
+				env, _ = logs.ParseEnvironment(r)
+			}
 		}
-	}
 
+		data := ""
+		if keep || parse != nil {
+			data = streamStep(ctx, ci, job, e.ID, keep, parse)
+		}
+
+		data_name, data_command, data_key, data_path, data_when := stepFields(e.ConfigStep)
+		if e.Name != "" {
+			data_name = e.Name
+		}
+		dataSteps = append(dataSteps, JobDataSteps{
+			ID:        e.ID,
+			Name:      data_name,
+			Command:   data_command,
+			Key:       data_key,
+			Path:      data_path,
+			When:      data_when,
+			Output:    data,
+			Job:       job,
+			Status:    e.Status,
+			ExitCode:  e.ExitCode,
+			StartedAt: e.StartTime,
+			StoppedAt: e.EndTime,
+			OutputURL: e.OutputURL,
+		})
+	}
+
+	if !environment {
+		return dataSteps, dataEnvironment
+	}
 	dataEnvironment = append(dataEnvironment, JobDataEnvironment{
-		Sha:        ghSha,
-		HostAgent:  outAgent,
-		HostImage:  outImage,
-		HostVM:     outVm,
-		HostVolume: outVolume,
-		HostRunner: outRunner,
+		Sha:            env.BuiltIn["CIRCLE_SHA1"],
+		HostType:       spinUp.ExecutorType,
+		HostClass:      spinUp.ResourceClass,
+		HostAgent:      spinUp.BuildAgentVersion,
+		HostImage:      spinUp.PrimaryImage(),
+		HostVM:         spinUp.VMID,
+		HostVolume:     strings.Join(spinUp.Volumes, ","),
+		HostRunner:     spinUp.LaunchAgentVersion,
+		ExternalInputs: env.Injected,
+		SpinUp:         &spinUp,
+		Environment:    &env,
 	})
 
-	v := viper.Sub("jobs")
-	if v != nil { // Sub returns nil if the key cannot be found
-
-		keys := v.AllKeys()
-		for i := 0; i < len(keys); i++ {
-			sum := 100
-			if strings.Contains(keys[i], fmt.Sprintf("%v.steps", workflowName)) {
-				var jobsSteps = v.Get(keys[i]).([]interface{})
-				items := getSteps(jobsSteps, sum)
-				for i := range items {
-					dataSteps = append(dataSteps, JobDataSteps{
-						ID:      items[i].ID,
-						Name:    items[i].Name,
-						Command: items[i].Command,
-						Key:     items[i].Key,
-						Path:    items[i].Path,
-						When:    items[i].When,
-						Output:  items[i].Output,
-					})
+	return dataSteps, dataEnvironment
+}
+
+// legacySteps numbers steps the way processJobs used to: 0 is spin up, 99 prepares the
+// environment and config steps start at 101
+func legacySteps(configSteps []interface{}) []ExecutedStep {
+	steps := []ExecutedStep{
+		{ID: "0", Name: StepSpinUp, ConfigIndex: -1},
+		{ID: "99", Name: StepPrepareEnv, ConfigIndex: -1},
+	}
+	for i, step := range configSteps {
+		steps = append(steps, ExecutedStep{ID: strconv.Itoa(101 + i), ConfigIndex: i, ConfigStep: step})
+	}
+
+	return steps
+}
+
+// stepFields extracts the name, command, key, path and when of a config step
+func stepFields(step interface{}) (name string, command string, key string, path string, when string) {
+	switch v := step.(type) {
+	case string:
+		name = v
+	case map[string]interface{}:
+		for stepsName, stepsValue := range v {
+			name = stepsName
+			switch d := stepsValue.(type) {
+			case string:
+				name = d
+			case map[string]interface{}:
+				for k, value := range d {
+					switch k {
+					case "command":
+						command = fmt.Sprintf("%v", value)
+					case "path":
+						path = fmt.Sprintf("%v", value)
+					case "when":
+						when = fmt.Sprintf("%v", value)
+					case "key":
+						key = fmt.Sprintf("%v", value)
+					}
 				}
 			}
 		}
 	}
 
-	return dataSteps, dataEnvironment
+	return name, command, key, path, when
 }
 
-func removeText(data string, start string, end string, number int) (out string) {
-	format := strings.Replace(data, start, "", number)
-	out = strings.Replace(format, end, "", number)
-	return out
-}
+// streamStep streams the output of a step through parse without buffering it.
+// The output is only returned when keep is set.
+func streamStep(ctx context.Context, ci CI, job JobRef, step string, keep bool, parse func(r io.Reader)) string {
+	r, err := OpenStepOutput(ctx, ci, job, step, 0)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
 
-func parseVariables(data string, agent string, runner string, volume string, vm string, image string) (outAgent string, outRunner string, outVm string, outImage string, outVolume string) {
-	theReader := strings.NewReader(data)
-	scanner := bufio.NewScanner(theReader)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, agent) == true {
-			outAgent = strings.Replace(line, agent, "", -1)
-		}
-		if strings.Contains(line, runner) == true {
-			outRunner = strings.Replace(line, runner, "", -1)
-		}
-		if strings.Contains(line, vm) == true {
-			outVm = removeText(line, "VM '", "' has been created", -1)
-		}
-		if strings.Contains(line, volume) == true {
-			outVolume = strings.Replace(line, volume, "", -1)
-		}
-		if strings.Contains(line, image) == true {
-			outImage = strings.Replace(line, image, "", -1)
-		}
+	var sb strings.Builder
+	var src io.Reader = r
+	if keep {
+		src = io.TeeReader(r, &sb)
+	}
+	if parse != nil {
+		parse(src)
+	}
+	if keep {
+		io.Copy(&sb, r)
 	}
 
-	return outAgent, outRunner, outVm, outImage, outVolume
+	return sb.String()
 }
 
 type JobDataEnvironment struct {
-	Sha            string   `json:"sha"`
-	HostType       string   `json:"host_type"`
-	HostClass      string   `json:"host_class"`
-	HostImage      string   `json:"host_image"`
-	HostVM         string   `json:"host_vm"`
-	HostVolume     string   `json:"host_volume"`
-	HostAgent      string   `json:"host_agent"`
-	HostRunner     string   `json:"host_runner"`
-	ExternalInputs []string `json:"external_inputs"`
-	Orbs           []string `json:"orbs"`
-	Parameters     []string `json:"parameters"`
+	Sha            string            `json:"sha"`
+	HostType       string            `json:"host_type"`
+	HostClass      string            `json:"host_class"`
+	HostImage      string            `json:"host_image"`
+	HostVM         string            `json:"host_vm"`
+	HostVolume     string            `json:"host_volume"`
+	HostAgent      string            `json:"host_agent"`
+	HostRunner     string            `json:"host_runner"`
+	ExternalInputs []string          `json:"external_inputs"`
+	Orbs           []string          `json:"orbs"`
+	Parameters     []string          `json:"parameters"`
+	SpinUp         *logs.SpinUp      `json:"spin_up,omitempty"`
+	Environment    *logs.Environment `json:"environment,omitempty"`
 }
 
 type JobDataSteps struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Command string `json:"command"`
-	Key     string `json:"key"`
-	Path    string `json:"path"`
-	When    string `json:"when"`
-	Output  string `json:"output"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Command   string    `json:"command"`
+	Key       string    `json:"key"`
+	Path      string    `json:"path"`
+	When      string    `json:"when"`
+	Output    string    `json:"output"`
+	Job       JobRef    `json:"job"`
+	Status    string    `json:"status,omitempty"`
+	ExitCode  *int      `json:"exit_code,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	StoppedAt time.Time `json:"stopped_at"`
+	OutputURL string    `json:"output_url,omitempty"`
 }
 
 type WorkflowPipeline struct {
-	JobNumber    int            `json:"job_number"`
-	Id           string         `json:"id"`
-	StartedAt    string         `json:"started_at"`
-	Name         string         `json:"name"`
-	ProjectSlug  string         `json:"project_slug"`
-	Status       string         `json:"status"`
-	Type         string         `json:"type"`
-	StoppedAt    string         `json:"stopped_at"`
-	JobDataSteps []JobDataSteps `json:"job_data_steps"`
+	JobNumber    int                  `json:"job_number"`
+	Id           string               `json:"id"`
+	StartedAt    string               `json:"started_at"`
+	Name         string               `json:"name"`
+	ProjectSlug  string               `json:"project_slug"`
+	Status       string               `json:"status"`
+	Type         string               `json:"type"`
+	StoppedAt    string               `json:"stopped_at"`
+	JobDataSteps []JobDataSteps       `json:"job_data_steps"`
+	Details      *JobDetails          `json:"details,omitempty"`
+	Environment  []JobDataEnvironment `json:"environment,omitempty"`
+	Tests        []TestMetadata       `json:"tests,omitempty"`
+	Artifacts    []ArtifactsItem      `json:"artifacts,omitempty"`
 }
 
 type AllData struct {
@@ -0,0 +1,87 @@
+// Package cache provides a Redis backed circleci.ResponseCache so several processes share responses
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bldmgr/circleci"
+	"github.com/bldmgr/circleci/pkg/config"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix   = "circleci:"
+	defaultPort = "6379"
+)
+
+// Redis is a circleci.ResponseCache stored in Redis
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis connects to addr, either host[:port] or a redis:// URL
+func NewRedis(addr string) (*Redis, error) {
+	var opts *redis.Options
+	if strings.Contains(addr, "://") {
+		var err error
+		if opts, err = redis.ParseURL(addr); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, defaultPort)
+		}
+		opts = &redis.Options{Addr: addr}
+	}
+
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+func (c *Redis) Get(ctx context.Context, key string) (circleci.CachedResponse, bool, error) {
+	var r circleci.CachedResponse
+	data, err := c.client.Get(ctx, keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, false, err
+	}
+
+	return r, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, r circleci.CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Set(ctx, keyPrefix+key, data, ttl).Err()
+}
+
+// Close closes the connection pool
+func (c *Redis) Close() error {
+	return c.client.Close()
+}
+
+// FromConfig returns a Redis cache when the config sets a Redis host through REDIS_HOST or
+// _redis_host, an in-memory cache when CIRCLE_CACHE or _cache is "memory" and nil, no caching, otherwise
+func FromConfig(c *config.ConfigYaml) (circleci.ResponseCache, error) {
+	host := strings.TrimSpace(c.Redis)
+	if host != "" && host != "<nil>" {
+		return NewRedis(host)
+	}
+	if strings.EqualFold(strings.TrimSpace(c.Cache), "memory") {
+		return circleci.NewMemoryCache(), nil
+	}
+
+	return nil, nil
+}
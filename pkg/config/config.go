@@ -16,6 +16,7 @@ const (
 	projectEnvVar    = "CIRCLE_PROJECT"
 	pipelineIdEnvVar = "CIRCLE_PIPELINEID"
 	redisEnvVar      = "REDIS_HOST"
+	cacheEnvVar      = "CIRCLE_CACHE"
 )
 
 type ConfigYaml struct {
@@ -25,6 +26,7 @@ type ConfigYaml struct {
 	PipelineID string
 	Type       string
 	Redis      string
+	Cache      string
 }
 
 func SetConfigYaml() *ConfigYaml {
@@ -53,6 +55,7 @@ func SetConfigYaml() *ConfigYaml {
 			PipelineID: fmt.Sprintf("%v", viper.Get("pipelineId")),
 			Type:       fmt.Sprintf("%v", "yamlVar"),
 			Redis:      fmt.Sprintf("%v", viper.Get("_redis_host")),
+			Cache:      viper.GetString("_cache"),
 		}
 	} else {
 		return &ConfigYaml{
@@ -62,6 +65,7 @@ func SetConfigYaml() *ConfigYaml {
 			PipelineID: os.Getenv(pipelineIdEnvVar),
 			Type:       fmt.Sprintf("%v", "osEnvVar"),
 			Redis:      strings.TrimSpace(os.Getenv(redisEnvVar)),
+			Cache:      strings.TrimSpace(os.Getenv(cacheEnvVar)),
 		}
 	}
 }
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns returns the column names of a report row in order
+func Columns[T Row]() []string {
+	t := reflect.TypeOf(*new(T))
+	columns := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("parquet"), ",")
+		columns = append(columns, name)
+	}
+
+	return columns
+}
+
+// WriteCSV writes rows as CSV with a header line of their Columns
+func WriteCSV[T Row](w io.Writer, rows []T) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Columns[T]()); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		v := reflect.ValueOf(row)
+		record := make([]string, v.NumField())
+		for i := range record {
+			record[i] = csvValue(v.Field(i))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// WriteCSV writes pipelines.csv, workflows.csv and jobs.csv into dir
+func (r Report) WriteCSV(dir string) error {
+	return r.writeFiles(dir, ".csv", func(w io.Writer, name string) error {
+		switch name {
+		case "pipelines":
+			return WriteCSV(w, r.Pipelines)
+		case "workflows":
+			return WriteCSV(w, r.Workflows)
+		default:
+			return WriteCSV(w, r.Jobs)
+		}
+	})
+}
+
+// writeFiles creates one file per table of the report in dir
+func (r Report) writeFiles(dir string, ext string, write func(w io.Writer, table string) error) error {
+	for _, table := range []string{"pipelines", "workflows", "jobs"} {
+		f, err := os.Create(filepath.Join(dir, table+ext))
+		if err != nil {
+			return err
+		}
+		if err := write(f, table); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.UTC().Format(timeLayout)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case string:
+		return x
+	}
+
+	return ""
+}
@@ -0,0 +1,4 @@
+// Package export writes pipeline snapshots into stores analysts can query: SQLite keeps the build
+// history in a normalized schema, Report holds pipeline, workflow duration and job usage rows for
+// CSV and Parquet reporting.
+package export
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetSchema returns the Parquet schema of a report row. Columns follow the parquet tags in order,
+// timestamps are stored in milliseconds: parquet-go maps *time.Time to nanoseconds and only takes the
+// timestamp tag on values, which could not hold nulls.
+func ParquetSchema[T Row]() *parquet.Schema {
+	schema := parquet.SchemaOf(new(T))
+
+	return parquet.NewSchema(schema.Name(), millisNode{schema})
+}
+
+// millisNode is a row node whose timestamp columns are in milliseconds
+type millisNode struct {
+	parquet.Node
+}
+
+func (n millisNode) Fields() []parquet.Field {
+	fields := n.Node.Fields()
+	out := make([]parquet.Field, len(fields))
+	for i, f := range fields {
+		out[i] = f
+		if lt := f.Type().LogicalType(); lt != nil && lt.Timestamp != nil {
+			out[i] = millisField{f}
+		}
+	}
+
+	return out
+}
+
+type millisField struct {
+	parquet.Field
+}
+
+func (f millisField) Type() parquet.Type {
+	return parquet.Timestamp(parquet.Millisecond).Type()
+}
+
+// WriteParquet writes rows as a Parquet file with their ParquetSchema
+func WriteParquet[T Row](w io.Writer, rows []T) error {
+	pw := parquet.NewGenericWriter[T](w, ParquetSchema[T]())
+	if _, err := pw.Write(rows); err != nil {
+		return err
+	}
+
+	return pw.Close()
+}
+
+// WriteParquet writes pipelines.parquet, workflows.parquet and jobs.parquet into dir
+func (r Report) WriteParquet(dir string) error {
+	return r.writeFiles(dir, ".parquet", func(w io.Writer, name string) error {
+		switch name {
+		case "pipelines":
+			return WriteParquet(w, r.Pipelines)
+		case "workflows":
+			return WriteParquet(w, r.Workflows)
+		default:
+			return WriteParquet(w, r.Jobs)
+		}
+	})
+}
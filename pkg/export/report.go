@@ -0,0 +1,191 @@
+package export
+
+import (
+	"context"
+	"time"
+
+	"github.com/bldmgr/circleci"
+)
+
+// The report rows below are the schema of the CSV and Parquet exports. Column names come from the
+// parquet tags and columns keep their order, new columns are only ever appended. Timestamps are UTC,
+// written in CSV as RFC 3339 with milliseconds and in Parquet as millisecond timestamps. Empty CSV
+// cells and Parquet nulls mark values CircleCI did not report, such as the stop time of a running workflow.
+
+// PipelineRow is one pipeline
+type PipelineRow struct {
+	PipelineID   string     `parquet:"pipeline_id"`
+	ProjectSlug  string     `parquet:"project_slug"`
+	Number       int64      `parquet:"number"`
+	State        string     `parquet:"state"`
+	Branch       string     `parquet:"branch"`
+	Revision     string     `parquet:"revision"`
+	TriggerType  string     `parquet:"trigger_type"`
+	TriggerActor string     `parquet:"trigger_actor"`
+	CreatedAt    *time.Time `parquet:"created_at,optional"`
+	UpdatedAt    *time.Time `parquet:"updated_at,optional"`
+}
+
+// WorkflowRow is one workflow, DurationSeconds is the time from its creation until it stopped
+type WorkflowRow struct {
+	WorkflowID      string     `parquet:"workflow_id"`
+	PipelineID      string     `parquet:"pipeline_id"`
+	PipelineNumber  int64      `parquet:"pipeline_number"`
+	ProjectSlug     string     `parquet:"project_slug"`
+	Name            string     `parquet:"name"`
+	Status          string     `parquet:"status"`
+	Tag             string     `parquet:"tag"`
+	StartedBy       string     `parquet:"started_by"`
+	CreatedAt       *time.Time `parquet:"created_at,optional"`
+	StoppedAt       *time.Time `parquet:"stopped_at,optional"`
+	DurationSeconds *float64   `parquet:"duration_seconds,optional"`
+}
+
+// JobUsageRow is the executor usage of one job. QueuedSeconds is the time from queueing until the job
+// started. NodeSeconds is DurationSeconds times Parallelism, an upper bound of the executor time billed
+// as parallel nodes may finish early.
+type JobUsageRow struct {
+	ProjectSlug     string     `parquet:"project_slug"`
+	PipelineID      string     `parquet:"pipeline_id"`
+	WorkflowID      string     `parquet:"workflow_id"`
+	WorkflowName    string     `parquet:"workflow_name"`
+	JobNumber       int64      `parquet:"job_number"`
+	Name            string     `parquet:"name"`
+	Status          string     `parquet:"status"`
+	ExecutorType    string     `parquet:"executor_type"`
+	ResourceClass   string     `parquet:"resource_class"`
+	Parallelism     int64      `parquet:"parallelism"`
+	QueuedAt        *time.Time `parquet:"queued_at,optional"`
+	StartedAt       *time.Time `parquet:"started_at,optional"`
+	StoppedAt       *time.Time `parquet:"stopped_at,optional"`
+	QueuedSeconds   *float64   `parquet:"queued_seconds,optional"`
+	DurationSeconds *float64   `parquet:"duration_seconds,optional"`
+	NodeSeconds     *float64   `parquet:"node_seconds,optional"`
+}
+
+// Row is a row of a report
+type Row interface {
+	PipelineRow | WorkflowRow | JobUsageRow
+}
+
+// NewPipelineRow returns the report row of a pipeline
+func NewPipelineRow(p circleci.PipelineItem) PipelineRow {
+	return PipelineRow{
+		PipelineID:   p.ID,
+		ProjectSlug:  p.ProjectSlug,
+		Number:       int64(p.Number),
+		State:        p.State,
+		Branch:       p.Vcs.Branch,
+		Revision:     p.Vcs.Revision,
+		TriggerType:  p.Trigger.Type,
+		TriggerActor: p.Trigger.Actor.Login,
+		CreatedAt:    timePtr(p.CreatedAt),
+		UpdatedAt:    timePtr(p.UpdatedAt),
+	}
+}
+
+// NewWorkflowRow returns the report row of a workflow
+func NewWorkflowRow(w circleci.PipelineWorkflows) WorkflowRow {
+	return WorkflowRow{
+		WorkflowID:      w.ID,
+		PipelineID:      w.PipelineID,
+		PipelineNumber:  int64(w.PipelineNumber),
+		ProjectSlug:     w.ProjectSlug,
+		Name:            w.Name,
+		Status:          w.Status,
+		Tag:             w.Tag,
+		StartedBy:       w.StartedBy,
+		CreatedAt:       timePtr(w.CreatedAt),
+		StoppedAt:       timePtr(w.StoppedAt),
+		DurationSeconds: seconds(w.CreatedAt, w.StoppedAt),
+	}
+}
+
+// NewJobUsageRow returns the usage row of a job from its details
+func NewJobUsageRow(d circleci.JobDetails) JobUsageRow {
+	row := JobUsageRow{
+		ProjectSlug:   d.Project.Slug,
+		PipelineID:    d.Pipeline.ID,
+		WorkflowID:    d.LatestWorkflow.ID,
+		WorkflowName:  d.LatestWorkflow.Name,
+		JobNumber:     int64(d.Number),
+		Name:          d.Name,
+		Status:        d.Status,
+		ExecutorType:  d.Executor.Type,
+		ResourceClass: d.Executor.ResourceClass,
+		Parallelism:   int64(max(d.Parallelism, 1)),
+		QueuedAt:      timePtr(d.QueuedAt),
+		StartedAt:     timePtr(d.StartedAt),
+		StoppedAt:     timePtr(d.StoppedAt),
+		QueuedSeconds: seconds(d.QueuedAt, d.StartedAt),
+	}
+	// duration is reported in milliseconds once the job stopped
+	if !d.StoppedAt.IsZero() {
+		duration := float64(d.Duration) / 1000
+		nodes := duration * float64(row.Parallelism)
+		row.DurationSeconds, row.NodeSeconds = &duration, &nodes
+	}
+
+	return row
+}
+
+// Report holds the rows of the reporting exports
+type Report struct {
+	Pipelines []PipelineRow
+	Workflows []WorkflowRow
+	Jobs      []JobUsageRow
+}
+
+// Add appends the rows of a snapshot, jobs without details such as approvals have no usage row
+func (r *Report) Add(snapshot circleci.PipelineSnapshot) {
+	r.Pipelines = append(r.Pipelines, NewPipelineRow(snapshot.Pipeline))
+	for _, w := range snapshot.Workflows {
+		r.Workflows = append(r.Workflows, NewWorkflowRow(w.Workflow()))
+		for _, j := range w.WorkflowPipeline {
+			if j.Details == nil {
+				continue
+			}
+			// the details name the latest workflow of the job, which differs for a rerun workflow
+			row := NewJobUsageRow(*j.Details)
+			row.PipelineID, row.WorkflowID, row.WorkflowName = snapshot.Pipeline.ID, w.ID, w.Name
+			r.Jobs = append(r.Jobs, row)
+		}
+	}
+}
+
+// BuildReport collects the report of the pipelines of an org selected by opts. Logs, tests and
+// artifacts are not part of the report and are not fetched.
+func BuildReport(ctx context.Context, ci circleci.CI, org string, opts circleci.ExportOptions) (Report, error) {
+	opts.Snapshot.Logs, opts.Snapshot.Tests, opts.Snapshot.Artifacts = false, false, false
+
+	r := Report{
+		Pipelines: make([]PipelineRow, 0),
+		Workflows: make([]WorkflowRow, 0),
+		Jobs:      make([]JobUsageRow, 0),
+	}
+	_, err := circleci.ExportPipelines(ctx, ci, org, opts, func(snapshot circleci.PipelineSnapshot, _ circleci.ExportCursor) error {
+		r.Add(snapshot)
+		return nil
+	})
+
+	return r, err
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+
+	return &t
+}
+
+// seconds returns the time between from and to, nil unless both are set
+func seconds(from time.Time, to time.Time) *float64 {
+	if from.IsZero() || to.IsZero() {
+		return nil
+	}
+	s := to.Sub(from).Seconds()
+
+	return &s
+}
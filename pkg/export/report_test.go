@@ -0,0 +1,105 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// golden compares got with testdata/report/name, columns are only ever appended so a diff here
+// breaks readers of earlier exports
+func golden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "report", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestReportSchemas(t *testing.T) {
+	tests := []struct {
+		table  string
+		csv    func() (string, error)
+		schema *parquet.Schema
+	}{
+		{"pipelines", csvHeader[PipelineRow], ParquetSchema[PipelineRow]()},
+		{"workflows", csvHeader[WorkflowRow], ParquetSchema[WorkflowRow]()},
+		{"jobs", csvHeader[JobUsageRow], ParquetSchema[JobUsageRow]()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+			header, err := tt.csv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.table+".csv", header)
+			golden(t, tt.table+".schema", tt.schema.String()+"\n")
+		})
+	}
+}
+
+func csvHeader[T Row]() (string, error) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []T{})
+
+	return buf.String(), err
+}
+
+func TestWriteParquetMillis(t *testing.T) {
+	created := time.Date(2024, 6, 20, 17, 42, 50, 528000000, time.UTC)
+	rows := []PipelineRow{
+		{PipelineID: "p1", Number: 7, CreatedAt: &created},
+		{PipelineID: "p2", Number: 8},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	column, ok := f.Schema().Lookup("created_at")
+	if !ok {
+		t.Fatal("no created_at column")
+	}
+	values := make([]parquet.Row, 2)
+	n, _ := f.RowGroups()[0].Rows().ReadRows(values)
+	if n != 2 {
+		t.Fatalf("read %d rows, want 2", n)
+	}
+	if got := values[0][column.ColumnIndex].Int64(); got != created.UnixMilli() {
+		t.Errorf("created_at = %d, want %d milliseconds", got, created.UnixMilli())
+	}
+	if !values[1][column.ColumnIndex].IsNull() {
+		t.Error("a missing created_at is not null")
+	}
+
+	r := parquet.NewGenericReader[PipelineRow](bytes.NewReader(buf.Bytes()), ParquetSchema[PipelineRow]())
+	got := make([]PipelineRow, 2)
+	if n, err := r.Read(got); n != 2 {
+		t.Fatalf("read %d rows: %v", n, err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read back %+v, want %+v", got, rows)
+	}
+}
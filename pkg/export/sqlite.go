@@ -0,0 +1,372 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bldmgr/circleci"
+	_ "modernc.org/sqlite"
+)
+
+// timeLayout stores timestamps as fixed width UTC text so they sort and compare as strings
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS pipelines (
+	id                    TEXT PRIMARY KEY,
+	project_slug          TEXT NOT NULL,
+	number                INTEGER NOT NULL,
+	state                 TEXT NOT NULL,
+	created_at            TEXT,
+	updated_at            TEXT,
+	trigger_type          TEXT NOT NULL,
+	trigger_actor         TEXT NOT NULL,
+	branch                TEXT NOT NULL,
+	revision              TEXT NOT NULL,
+	commit_subject        TEXT NOT NULL,
+	origin_repository_url TEXT NOT NULL,
+	errors                TEXT
+);
+CREATE INDEX IF NOT EXISTS pipelines_project ON pipelines (project_slug, created_at);
+CREATE INDEX IF NOT EXISTS pipelines_branch ON pipelines (branch, created_at);
+
+CREATE TABLE IF NOT EXISTS workflows (
+	id              TEXT PRIMARY KEY,
+	pipeline_id     TEXT NOT NULL REFERENCES pipelines (id) ON DELETE CASCADE,
+	name            TEXT NOT NULL,
+	project_slug    TEXT NOT NULL,
+	status          TEXT NOT NULL,
+	started_by      TEXT NOT NULL,
+	pipeline_number INTEGER NOT NULL,
+	tag             TEXT NOT NULL,
+	created_at      TEXT,
+	stopped_at      TEXT
+);
+CREATE INDEX IF NOT EXISTS workflows_pipeline ON workflows (pipeline_id);
+CREATE INDEX IF NOT EXISTS workflows_status ON workflows (status);
+CREATE INDEX IF NOT EXISTS workflows_name ON workflows (name, created_at);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id             TEXT PRIMARY KEY,
+	workflow_id    TEXT NOT NULL REFERENCES workflows (id) ON DELETE CASCADE,
+	job_number     INTEGER NOT NULL,
+	name           TEXT NOT NULL,
+	project_slug   TEXT NOT NULL,
+	type           TEXT NOT NULL,
+	status         TEXT NOT NULL,
+	started_at     TEXT,
+	stopped_at     TEXT,
+	web_url        TEXT,
+	executor_type  TEXT,
+	resource_class TEXT,
+	parallelism    INTEGER,
+	duration_ms    INTEGER,
+	queued_at      TEXT,
+	contexts       TEXT
+);
+CREATE INDEX IF NOT EXISTS jobs_workflow ON jobs (workflow_id);
+CREATE INDEX IF NOT EXISTS jobs_number ON jobs (project_slug, job_number);
+CREATE INDEX IF NOT EXISTS jobs_name ON jobs (name, status);
+
+CREATE TABLE IF NOT EXISTS steps (
+	job_id     TEXT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	step_id    TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	command    TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	exit_code  INTEGER,
+	started_at TEXT,
+	stopped_at TEXT,
+	output     TEXT,
+	output_url TEXT,
+	PRIMARY KEY (job_id, position)
+);
+CREATE INDEX IF NOT EXISTS steps_name ON steps (name);
+
+CREATE TABLE IF NOT EXISTS tests (
+	job_id    TEXT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+	position  INTEGER NOT NULL,
+	classname TEXT NOT NULL,
+	file      TEXT NOT NULL,
+	name      TEXT NOT NULL,
+	result    TEXT NOT NULL,
+	message   TEXT NOT NULL,
+	run_time  REAL NOT NULL,
+	source    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (job_id, position)
+);
+CREATE INDEX IF NOT EXISTS tests_key ON tests (file, classname, name);
+CREATE INDEX IF NOT EXISTS tests_result ON tests (result);
+
+CREATE TABLE IF NOT EXISTS artifacts (
+	job_id     TEXT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+	node_index INTEGER NOT NULL,
+	path       TEXT NOT NULL,
+	url        TEXT NOT NULL,
+	PRIMARY KEY (job_id, node_index, path)
+);
+`
+
+// SQLite writes pipeline snapshots into a normalized SQLite schema: pipelines, workflows, jobs and the
+// steps, tests and artifacts of each job, linked by foreign keys. Timestamps are UTC text.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database at path
+func OpenSQLite(path string) (*SQLite, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open("sqlite", path+sep+"_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writers instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// migrateSQLite adds the columns databases created by earlier versions lack
+func migrateSQLite(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('tests') WHERE name = 'source'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := db.Exec(`ALTER TABLE tests ADD COLUMN source TEXT NOT NULL DEFAULT ''`)
+		return err
+	}
+
+	return nil
+}
+
+// DB returns the database for querying
+func (s *SQLite) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Export writes the pipelines of an org into the database. Without opts.Since it continues from the
+// oldest pipeline that was still running in the last export, or the newest one when none was, so
+// repeated exports only fetch what changed. Rows are upserted, exporting a pipeline twice is harmless.
+func (s *SQLite) Export(ctx context.Context, ci circleci.CI, org string, opts circleci.ExportOptions) (circleci.ExportCursor, error) {
+	if opts.Since.IsZero() && opts.Cursor == nil {
+		since, err := s.refreshSince(ctx)
+		if err != nil {
+			return circleci.ExportCursor{}, err
+		}
+		opts.Since = since
+	}
+
+	return circleci.ExportPipelines(ctx, ci, org, opts, func(snapshot circleci.PipelineSnapshot, _ circleci.ExportCursor) error {
+		return s.WriteSnapshot(ctx, snapshot)
+	})
+}
+
+// refreshSince returns the creation time of the oldest pipeline that may still change. A pipeline
+// without workflows may still get them while it is created and newer than every pipeline that has
+// workflows, older ones never will and must not hold the refresh back.
+func (s *SQLite) refreshSince(ctx context.Context) (time.Time, error) {
+	var since sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(
+		(SELECT MIN(p.created_at) FROM pipelines p LEFT JOIN workflows w ON w.pipeline_id = p.id
+			WHERE w.status IN ('running', 'on_hold', 'failing')
+				OR (w.id IS NULL AND p.state = 'created' AND p.created_at > COALESCE(
+					(SELECT MAX(p2.created_at) FROM pipelines p2 JOIN workflows w2 ON w2.pipeline_id = p2.id), ''))),
+		(SELECT MAX(created_at) FROM pipelines))`).Scan(&since)
+	if err != nil || !since.Valid {
+		return time.Time{}, err
+	}
+
+	return time.Parse(timeLayout, since.String)
+}
+
+// WriteSnapshot upserts a pipeline with its workflows and jobs in one transaction. Steps, tests and
+// artifacts of a job are replaced when the snapshot holds them, a snapshot taken without tests or
+// artifacts, or one that failed to fetch them or the steps of a job, keeps those already stored.
+// Tests and artifacts are nil when they were not fetched, an empty list clears the stored ones.
+func (s *SQLite) WriteSnapshot(ctx context.Context, snapshot circleci.PipelineSnapshot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := writePipeline(ctx, tx, snapshot.Pipeline); err != nil {
+		return err
+	}
+	for _, w := range snapshot.Workflows {
+		if err := writeWorkflow(ctx, tx, snapshot.Pipeline.ID, w.Workflow()); err != nil {
+			return err
+		}
+		for _, j := range w.WorkflowPipeline {
+			if err := writeJob(ctx, tx, w.ID, j); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func writePipeline(ctx context.Context, tx *sql.Tx, p circleci.PipelineItem) error {
+	var errs interface{}
+	if len(p.Errors) > 0 {
+		data, err := json.Marshal(p.Errors)
+		if err != nil {
+			return err
+		}
+		errs = string(data)
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO pipelines (id, project_slug, number, state, created_at, updated_at, trigger_type,
+			trigger_actor, branch, revision, commit_subject, origin_repository_url, errors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET project_slug = excluded.project_slug, number = excluded.number,
+			state = excluded.state, created_at = excluded.created_at, updated_at = excluded.updated_at,
+			trigger_type = excluded.trigger_type, trigger_actor = excluded.trigger_actor, branch = excluded.branch,
+			revision = excluded.revision, commit_subject = excluded.commit_subject,
+			origin_repository_url = excluded.origin_repository_url, errors = excluded.errors`,
+		p.ID, p.ProjectSlug, p.Number, p.State, formatTime(p.CreatedAt), formatTime(p.UpdatedAt), p.Trigger.Type,
+		p.Trigger.Actor.Login, p.Vcs.Branch, p.Vcs.Revision, p.Vcs.Commit.Subject, p.Vcs.OriginRepositoryURL, errs)
+
+	return err
+}
+
+func writeWorkflow(ctx context.Context, tx *sql.Tx, pipelineId string, w circleci.PipelineWorkflows) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO workflows (id, pipeline_id, name, project_slug, status, started_by, pipeline_number,
+			tag, created_at, stopped_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET pipeline_id = excluded.pipeline_id, name = excluded.name,
+			project_slug = excluded.project_slug, status = excluded.status, started_by = excluded.started_by,
+			pipeline_number = excluded.pipeline_number, tag = excluded.tag, created_at = excluded.created_at,
+			stopped_at = excluded.stopped_at`,
+		w.ID, pipelineId, w.Name, w.ProjectSlug, w.Status, w.StartedBy, w.PipelineNumber, w.Tag,
+		formatTime(w.CreatedAt), formatTime(w.StoppedAt))
+
+	return err
+}
+
+func writeJob(ctx context.Context, tx *sql.Tx, workflowId string, j circleci.WorkflowPipeline) error {
+	// approval jobs have no details, their columns stay NULL
+	var webURL, executorType, resourceClass, parallelism, duration, queuedAt, contexts interface{}
+	if d := j.Details; d != nil {
+		names := make([]string, 0, len(d.Contexts))
+		for _, c := range d.Contexts {
+			names = append(names, c.Name)
+		}
+		webURL, executorType, resourceClass = d.WebURL, d.Executor.Type, d.Executor.ResourceClass
+		parallelism, duration, queuedAt, contexts = d.Parallelism, d.Duration, formatTime(d.QueuedAt), strings.Join(names, ",")
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO jobs (id, workflow_id, job_number, name, project_slug, type, status, started_at,
+			stopped_at, web_url, executor_type, resource_class, parallelism, duration_ms, queued_at, contexts)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET workflow_id = excluded.workflow_id, job_number = excluded.job_number,
+			name = excluded.name, project_slug = excluded.project_slug, type = excluded.type, status = excluded.status,
+			started_at = excluded.started_at, stopped_at = excluded.stopped_at,
+			web_url = COALESCE(excluded.web_url, web_url),
+			executor_type = COALESCE(excluded.executor_type, executor_type),
+			resource_class = COALESCE(excluded.resource_class, resource_class),
+			parallelism = COALESCE(excluded.parallelism, parallelism),
+			duration_ms = COALESCE(excluded.duration_ms, duration_ms),
+			queued_at = COALESCE(excluded.queued_at, queued_at),
+			contexts = COALESCE(excluded.contexts, contexts)`,
+		j.Id, workflowId, j.JobNumber, j.Name, j.ProjectSlug, j.Type, j.Status, parseTime(j.StartedAt),
+		parseTime(j.StoppedAt), webURL, executorType, resourceClass, parallelism, duration, queuedAt, contexts)
+	if err != nil {
+		return err
+	}
+
+	if len(j.JobDataSteps) > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM steps WHERE job_id = ?`, j.Id); err != nil {
+			return err
+		}
+		for i, step := range j.JobDataSteps {
+			_, err := tx.ExecContext(ctx, `INSERT INTO steps (job_id, position, step_id, name, command, status, exit_code, started_at,
+					stopped_at, output, output_url)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				j.Id, i, step.ID, step.Name, step.Command, step.Status, step.ExitCode, formatTime(step.StartedAt),
+				formatTime(step.StoppedAt), nullString(step.Output), nullString(step.OutputURL))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.Tests != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tests WHERE job_id = ?`, j.Id); err != nil {
+			return err
+		}
+		for i, t := range j.Tests {
+			_, err := tx.ExecContext(ctx, `INSERT INTO tests (job_id, position, classname, file, name, result, message, run_time, source)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				j.Id, i, t.Classname, t.File, t.Name, t.Result, t.Message, t.RunTime, t.Source)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if j.Artifacts != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM artifacts WHERE job_id = ?`, j.Id); err != nil {
+			return err
+		}
+		for _, a := range j.Artifacts {
+			_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO artifacts (job_id, node_index, path, url) VALUES (?, ?, ?, ?)`,
+				j.Id, a.NodeIndex, a.Path, a.URL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatTime returns t as UTC text, NULL when it is zero
+func formatTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.UTC().Format(timeLayout)
+}
+
+// parseTime formats the timestamps WorkflowItem keeps as strings like formatTime, they are kept as
+// they are when they do not parse
+func parseTime(s string) interface{} {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nullString(s)
+	}
+
+	return formatTime(t)
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
@@ -0,0 +1,237 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bldmgr/circleci"
+)
+
+func openTestSQLite(t *testing.T) *SQLite {
+	t.Helper()
+	s, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func testSnapshot(id string, created time.Time, workflowStatus string) circleci.PipelineSnapshot {
+	details := &circleci.JobDetails{WebURL: "https://app.circleci.com/jobs/" + id, Parallelism: 2, Duration: 61000, QueuedAt: created}
+	details.Executor.Type, details.Executor.ResourceClass = "docker", "medium"
+	details.Contexts = append(details.Contexts, struct {
+		Name string `json:"name"`
+	}{Name: "aws"})
+
+	return circleci.PipelineSnapshot{
+		Pipeline: circleci.PipelineItem{ID: id, ProjectSlug: "gh/bldmgr/circleci", Number: 1, State: "created", CreatedAt: created},
+		Workflows: []circleci.AllData{{
+			ID:     "w-" + id,
+			Name:   "build",
+			Status: workflowStatus,
+			WorkflowPipeline: []circleci.WorkflowPipeline{{
+				JobNumber:    7,
+				Id:           "j-" + id,
+				Name:         "test",
+				Status:       "success",
+				Type:         "build",
+				JobDataSteps: []circleci.JobDataSteps{{ID: "101", Name: "go test", Status: "success"}},
+				Details:      details,
+				Tests:        []circleci.TestMetadata{{Name: "TestA", Result: "success", Source: "go"}},
+				Artifacts:    []circleci.ArtifactsItem{{Path: "coverage.out", URL: "https://example.com/coverage.out"}},
+			}},
+		}},
+	}
+}
+
+func count(t *testing.T, s *SQLite, table string) int {
+	t.Helper()
+	var n int
+	if err := s.DB().QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestWriteSnapshotIdempotent(t *testing.T) {
+	s := openTestSQLite(t)
+	ctx := context.Background()
+	snapshot := testSnapshot("p1", time.Date(2024, 6, 20, 17, 42, 50, 0, time.UTC), "success")
+
+	for i := 0; i < 2; i++ {
+		if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range []string{"pipelines", "workflows", "jobs", "steps", "tests", "artifacts"} {
+		if n := count(t, s, table); n != 1 {
+			t.Errorf("%s holds %d rows after writing a snapshot twice, want 1", table, n)
+		}
+	}
+
+	var source string
+	if err := s.DB().QueryRow(`SELECT source FROM tests`).Scan(&source); err != nil || source != "go" {
+		t.Errorf("test source = %q, %v, want go", source, err)
+	}
+}
+
+func TestWriteSnapshotKeepsMissingParts(t *testing.T) {
+	s := openTestSQLite(t)
+	ctx := context.Background()
+	snapshot := testSnapshot("p1", time.Date(2024, 6, 20, 17, 42, 50, 0, time.UTC), "success")
+	if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// a snapshot taken without tests, artifacts or details that failed to fetch the steps
+	job := &snapshot.Workflows[0].WorkflowPipeline[0]
+	job.JobDataSteps, job.Tests, job.Artifacts, job.Details = []circleci.JobDataSteps{}, nil, nil, nil
+	job.Status = "failed"
+	if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"steps", "tests", "artifacts"} {
+		if n := count(t, s, table); n != 1 {
+			t.Errorf("%s holds %d rows, want the stored row kept", table, n)
+		}
+	}
+
+	// a snapshot whose job has no tests or artifacts anymore
+	job.Tests, job.Artifacts = []circleci.TestMetadata{}, []circleci.ArtifactsItem{}
+	if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"tests", "artifacts"} {
+		if n := count(t, s, table); n != 0 {
+			t.Errorf("%s holds %d rows, want them cleared by an empty list", table, n)
+		}
+	}
+
+	var status, webURL, executor, resourceClass, contexts string
+	var parallelism, duration int
+	err := s.DB().QueryRow(`SELECT status, web_url, executor_type, resource_class, parallelism, duration_ms, contexts FROM jobs`).
+		Scan(&status, &webURL, &executor, &resourceClass, &parallelism, &duration, &contexts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" {
+		t.Errorf("status = %q, want the new status", status)
+	}
+	if webURL != "https://app.circleci.com/jobs/p1" || executor != "docker" || resourceClass != "medium" ||
+		parallelism != 2 || duration != 61000 || contexts != "aws" {
+		t.Errorf("job details were not kept: %q %q %q %d %d %q", webURL, executor, resourceClass, parallelism, duration, contexts)
+	}
+}
+
+func TestRefreshSince(t *testing.T) {
+	s := openTestSQLite(t)
+	ctx := context.Background()
+	day := time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC)
+
+	if since, err := s.refreshSince(ctx); err != nil || !since.IsZero() {
+		t.Errorf("refreshSince of an empty database = %v, %v, want zero", since, err)
+	}
+
+	for _, snapshot := range []circleci.PipelineSnapshot{
+		testSnapshot("p1", day, "success"),
+		testSnapshot("p2", day.Add(time.Hour), "running"),
+		testSnapshot("p3", day.Add(2*time.Hour), "on_hold"),
+		testSnapshot("p4", day.Add(3*time.Hour), "failed"),
+	} {
+		if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, err := s.refreshSince(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := day.Add(time.Hour); !since.Equal(want) {
+		t.Errorf("refreshSince = %v, want the oldest unfinished pipeline %v", since, want)
+	}
+
+	// an errored pipeline and a created one that is older than pipelines with workflows never get any
+	for _, snapshot := range []circleci.PipelineSnapshot{
+		testSnapshot("p0", day.Add(-time.Hour), ""),
+		testSnapshot("p5", day.Add(30*time.Minute), ""),
+	} {
+		snapshot.Workflows = nil
+		if snapshot.Pipeline.ID == "p5" {
+			snapshot.Pipeline.State = "errored"
+		}
+		if err := s.WriteSnapshot(ctx, snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, err = s.refreshSince(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := day.Add(time.Hour); !since.Equal(want) {
+		t.Errorf("refreshSince = %v, want pipelines without workflows ignored, %v", since, want)
+	}
+
+	// once everything finished the export continues from the newest pipeline
+	for _, id := range []string{"p2", "p3"} {
+		if _, err := s.DB().Exec(`UPDATE workflows SET status = 'success' WHERE pipeline_id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, err = s.refreshSince(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := day.Add(3 * time.Hour); !since.Equal(want) {
+		t.Errorf("refreshSince = %v, want the newest pipeline %v", since, want)
+	}
+
+	// pipelines newer than every pipeline with workflows may still get theirs
+	for _, id := range []string{"p6", "p7"} {
+		pending := testSnapshot(id, day.Add(4*time.Hour), "")
+		if id == "p7" {
+			pending.Pipeline.CreatedAt = day.Add(5 * time.Hour)
+		}
+		pending.Workflows = nil
+		if err := s.WriteSnapshot(ctx, pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+	since, err = s.refreshSince(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := day.Add(4 * time.Hour); !since.Equal(want) {
+		t.Errorf("refreshSince = %v, want the pipeline still waiting for workflows %v", since, want)
+	}
+}
+
+func TestOpenSQLiteAddsTestSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE tests (job_id TEXT NOT NULL, position INTEGER NOT NULL, classname TEXT NOT NULL,
+		file TEXT NOT NULL, name TEXT NOT NULL, result TEXT NOT NULL, message TEXT NOT NULL, run_time REAL NOT NULL,
+		PRIMARY KEY (job_id, position))`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.WriteSnapshot(context.Background(), testSnapshot("p1", time.Now(), "success")); err != nil {
+		t.Errorf("writing into a migrated database: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package logs
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ansiEscape matches CSI sequences (colors, cursor movement, erase line) and OSC sequences (window titles, links)
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes every ANSI escape sequence from s
+func StripANSI(s string) string {
+	return ansiEscape.ReplaceAllString(s, "")
+}
+
+// CollapseCR keeps only what a terminal would finally show for lines rewritten with carriage
+// returns, so "10%\r50%\r100%" becomes "100%". A trailing CRLF is treated as a line ending.
+func CollapseCR(s string) string {
+	if !strings.Contains(s, "\r") {
+		return s
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.Contains(line, "\r") {
+			parts := strings.Split(line, "\r")
+			// a progress bar often ends with "\r" followed by nothing, keep the last non empty update
+			line = ""
+			for k := len(parts) - 1; k >= 0; k-- {
+				if parts[k] != "" {
+					line = parts[k]
+					break
+				}
+			}
+		}
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Normalize collapses carriage return progress updates and strips ANSI sequences
+func Normalize(s string) string {
+	return StripANSI(CollapseCR(s))
+}
+
+// ansiColors are the CSS colors of the 8 standard and 8 bright ANSI colors
+var ansiColors = []string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+type sgrState struct {
+	bold, italic, underline bool
+	fg, bg                  string
+}
+
+func (s sgrState) style() string {
+	parts := make([]string, 0, 5)
+	if s.fg != "" {
+		parts = append(parts, "color:"+s.fg)
+	}
+	if s.bg != "" {
+		parts = append(parts, "background-color:"+s.bg)
+	}
+	if s.bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if s.italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if s.underline {
+		parts = append(parts, "text-decoration:underline")
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// apply updates the state with the parameters of one SGR (ESC[...m) sequence
+func (s *sgrState) apply(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		n, err := strconv.Atoi(codes[i])
+		if err != nil {
+			n = 0
+		}
+		switch {
+		case n == 0:
+			*s = sgrState{}
+		case n == 1:
+			s.bold = true
+		case n == 3:
+			s.italic = true
+		case n == 4:
+			s.underline = true
+		case n == 22:
+			s.bold = false
+		case n == 23:
+			s.italic = false
+		case n == 24:
+			s.underline = false
+		case n >= 30 && n <= 37:
+			s.fg = ansiColors[n-30]
+		case n == 39:
+			s.fg = ""
+		case n >= 40 && n <= 47:
+			s.bg = ansiColors[n-40]
+		case n == 49:
+			s.bg = ""
+		case n >= 90 && n <= 97:
+			s.fg = ansiColors[n-90+8]
+		case n >= 100 && n <= 107:
+			s.bg = ansiColors[n-100+8]
+		case (n == 38 || n == 48) && i+1 < len(codes):
+			color, used := extendedColor(codes[i+1:])
+			if n == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+			i += used
+		}
+	}
+}
+
+// extendedColor decodes the 256 color (5;n) and true color (2;r;g;b) forms
+func extendedColor(codes []string) (color string, used int) {
+	atoi := func(i int) int {
+		if i >= len(codes) {
+			return 0
+		}
+		n, _ := strconv.Atoi(codes[i])
+		return n
+	}
+
+	switch codes[0] {
+	case "5":
+		n := atoi(1)
+		switch {
+		case n < 16:
+			return ansiColors[n], 2
+		case n < 232:
+			n -= 16
+			return fmt.Sprintf("#%02x%02x%02x", cube(n/36), cube(n/6%6), cube(n%6)), 2
+		default:
+			g := 8 + (n-232)*10
+			return fmt.Sprintf("#%02x%02x%02x", g, g, g), 2
+		}
+	case "2":
+		return fmt.Sprintf("#%02x%02x%02x", atoi(1), atoi(2), atoi(3)), 4
+	}
+
+	return "", 1
+}
+
+func cube(n int) int {
+	if n == 0 {
+		return 0
+	}
+
+	return 55 + n*40
+}
+
+// ToHTML converts SGR color and style sequences into inline styled spans and escapes everything else.
+// Other escape sequences are dropped and carriage return updates are collapsed first.
+func ToHTML(s string) string {
+	s = CollapseCR(s)
+
+	var sb strings.Builder
+	state := sgrState{}
+	open := false
+	last := 0
+	for _, loc := range ansiEscape.FindAllStringIndex(s, -1) {
+		sb.WriteString(html.EscapeString(s[last:loc[0]]))
+		last = loc[1]
+
+		seq := s[loc[0]:loc[1]]
+		if !strings.HasPrefix(seq, "\x1b[") || !strings.HasSuffix(seq, "m") {
+			continue
+		}
+		state.apply(seq[2 : len(seq)-1])
+		if open {
+			sb.WriteString("</span>")
+			open = false
+		}
+		if style := state.style(); style != "" {
+			fmt.Fprintf(&sb, `<span style="%s">`, style)
+			open = true
+		}
+	}
+	sb.WriteString(html.EscapeString(s[last:]))
+	if open {
+		sb.WriteString("</span>")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,76 @@
+package logs
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"color", "\x1b[31mred\x1b[0m text", "red text"},
+		{"reset without parameters", "\x1b[1mbold\x1b[m", "bold"},
+		{"256 colors", "\x1b[38;5;196mx\x1b[39m", "x"},
+		{"true color", "\x1b[48;2;1;2;3mx\x1b[49m", "x"},
+		{"erase line and cursor", "\x1b[2K\x1b[1Gdone", "done"},
+		{"private mode", "\x1b[?25lhidden cursor\x1b[?25h", "hidden cursor"},
+		{"window title", "\x1b]0;title\x07text", "text"},
+		{"hyperlink", "\x1b]8;;https://circleci.com\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"two byte sequence", "\x1bMup", "up"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripANSI(tt.in); got != tt.want {
+				t.Errorf("StripANSI(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollapseCR(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"no carriage return", "a\nb", "a\nb"},
+		{"progress", "10%\r50%\r100%", "100%"},
+		{"trailing carriage return", "50%\r100%\r", "100%"},
+		{"empty updates", "done\r\r", "done"},
+		{"crlf line endings", "a\r\nb\r\n", "a\nb\n"},
+		{"per line", "x\ry\nz\r\n1\r2", "y\nz\n2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CollapseCR(tt.in); got != tt.want {
+				t.Errorf("CollapseCR(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "hello", "hello"},
+		{"escapes markup", `<script>alert("x" & 'y')</script>`, "&lt;script&gt;alert(&#34;x&#34; &amp; &#39;y&#39;)&lt;/script&gt;"},
+		{"escapes inside a span", "\x1b[31m<b>&amp;\x1b[0m", `<span style="color:#cd3131">&lt;b&gt;&amp;amp;</span>`},
+		{"bold with colors", "\x1b[1;32;44mok\x1b[0m", `<span style="color:#0dbc79;background-color:#2472c8;font-weight:bold">ok</span>`},
+		{"bright color closed at the end", "\x1b[91mx", `<span style="color:#f14c4c">x</span>`},
+		{"256 color cube", "\x1b[38;5;196mx\x1b[0m", `<span style="color:#ff0000">x</span>`},
+		{"256 color gray", "\x1b[38;5;232mx\x1b[0m", `<span style="color:#080808">x</span>`},
+		{"true color background", "\x1b[48;2;1;2;3mx\x1b[49my", `<span style="background-color:#010203">x</span>y`},
+		{"color change", "\x1b[31ma\x1b[32mb", `<span style="color:#cd3131">a</span><span style="color:#0dbc79">b</span>`},
+		{"attribute off", "\x1b[1;3;4ma\x1b[22;23mb\x1b[24mc", `<span style="font-weight:bold;font-style:italic;text-decoration:underline">a</span><span style="text-decoration:underline">b</span>c`},
+		{"other sequences dropped", "\x1b[2K\x1b]0;title\x07x", "x"},
+		{"carriage returns collapsed", "10%\r\x1b[32m100%\x1b[0m", `<span style="color:#0dbc79">100%</span>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.in); got != tt.want {
+				t.Errorf("ToHTML(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package logs
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Environment is what the "Preparing environment variables" step reports. BuiltIn holds the variables
+// CircleCI sets for every job with their values. Injected only holds the names of the project and
+// context variables, their values are never read.
+type Environment struct {
+	BuiltIn  map[string]string `json:"built_in"`
+	Injected []string          `json:"injected"`
+}
+
+const (
+	envSectionNone = iota
+	envSectionBuiltIn
+	envSectionInjected
+)
+
+// ParseEnvironment parses the output of the "Preparing environment variables" step
+func ParseEnvironment(r io.Reader) (Environment, error) {
+	env := Environment{
+		BuiltIn:  make(map[string]string),
+		Injected: make([]string, 0),
+	}
+
+	section := envSectionNone
+	err := eachLine(r, func(_ int, line string) {
+		switch {
+		case strings.HasPrefix(line, "Using build environment variables"):
+			section = envSectionBuiltIn
+			return
+		case strings.HasPrefix(line, "Using environment variables from project settings"):
+			section = envSectionInjected
+			return
+		case !strings.HasPrefix(line, " "):
+			section = envSectionNone
+			return
+		}
+
+		name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || name == "" {
+			return
+		}
+		switch section {
+		case envSectionBuiltIn:
+			env.BuiltIn[name] = value
+		case envSectionInjected:
+			env.Injected = append(env.Injected, name)
+		}
+	})
+	sort.Strings(env.Injected)
+
+	return env, err
+}
+
+// Circle returns the built-in CIRCLE_* variables only
+func (e Environment) Circle() map[string]string {
+	out := make(map[string]string)
+	for k, v := range e.BuiltIn {
+		if strings.HasPrefix(k, "CIRCLE_") {
+			out[k] = v
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,51 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "environment.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	env, err := ParseEnvironment(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "NPM_TOKEN"}; !reflect.DeepEqual(env.Injected, want) {
+		t.Errorf("Injected = %v, want %v", env.Injected, want)
+	}
+	if len(env.BuiltIn) != 19 {
+		t.Errorf("len(BuiltIn) = %d, want 19", len(env.BuiltIn))
+	}
+	for name, want := range map[string]string{
+		"CI":                 "true",
+		"CIRCLE_SHA1":        "2a779aeecc39eabc4a99d92169470742a94fc8c0",
+		"CIRCLE_COMPARE_URL": "",
+		"CIRCLE_BUILD_URL":   "https://circleci.com/gh/bldmgr/circleci/21692",
+	} {
+		if got, ok := env.BuiltIn[name]; !ok || got != want {
+			t.Errorf("BuiltIn[%s] = %q, %t, want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range env.Injected {
+		if _, ok := env.BuiltIn[name]; ok {
+			t.Errorf("injected variable %s parsed as built-in", name)
+		}
+	}
+
+	circle := env.Circle()
+	if _, ok := circle["CI"]; ok {
+		t.Error("Circle() kept CI")
+	}
+	if circle["CIRCLE_BRANCH"] != "main" || len(circle) != 16 {
+		t.Errorf("Circle() = %v", circle)
+	}
+}
@@ -0,0 +1,137 @@
+package logs
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Segment is a run of log lines that starts at a timestamped line or at a section marker
+type Segment struct {
+	Start     time.Time `json:"start"`
+	Section   string    `json:"section,omitempty"`
+	FirstLine int       `json:"first_line"`
+	Lines     []string  `json:"lines"`
+}
+
+// Section is a known marker found in a log, with the values captured by its pattern
+type Section struct {
+	Name   string   `json:"name"`
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Values []string `json:"values,omitempty"`
+}
+
+// Marker recognises a known line of CircleCI output
+type Marker struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultMarkers are the lines CircleCI prints while spinning up an environment and running steps.
+// They cover what processJobs' parseVariables scrapes from the spin up step and a few more.
+var DefaultMarkers = []Marker{
+	{"build-agent", regexp.MustCompile(`^Build-agent version (\S+)`)},
+	{"launch-agent", regexp.MustCompile(`^Launch-agent version (\S+)`)},
+	{"volume", regexp.MustCompile(`^Using volume:\s*(\S+)`)},
+	{"vm-created", regexp.MustCompile(`^VM '([^']+)' has been created`)},
+	{"image", regexp.MustCompile(`^\s+using image (\S+)`)},
+	{"starting-container", regexp.MustCompile(`^Starting container (\S+)`)},
+	{"pull-stats", regexp.MustCompile(`^\s*pull stats: (.*)`)},
+	{"time-to-create-container", regexp.MustCompile(`^\s*time to create container: (.*)`)},
+	{"waiting-for-container", regexp.MustCompile(`^Waiting for (.*) to be ready`)},
+	{"creating-vm", regexp.MustCompile(`^Creating a dedicated VM with (.*)`)},
+	{"exit-code", regexp.MustCompile(`^Exited with code (?:exit status )?(\d+)`)},
+	{"too-long-no-output", regexp.MustCompile(`^Too long with no output`)},
+	{"received-signal", regexp.MustCompile(`(?i)received signal: (\w+)`)},
+}
+
+// timestampPatterns are the leading timestamps recognised at the start of a line, with their layouts
+var timestampPatterns = []struct {
+	pattern *regexp.Regexp
+	layout  string
+}{
+	{regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\]?\s`), ""},
+	{regexp.MustCompile(`^\[?(\d{2}:\d{2}:\d{2}(?:\.\d+)?)\]?\s`), "15:04:05"},
+}
+
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ParseTimestamp returns the timestamp a line starts with, if any
+func ParseTimestamp(line string) (time.Time, bool) {
+	for _, tp := range timestampPatterns {
+		m := tp.pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if tp.layout != "" {
+			if t, err := time.Parse(tp.layout, m[1]); err == nil {
+				return t, true
+			}
+			continue
+		}
+		for _, layout := range isoLayouts {
+			if t, err := time.Parse(layout, m[1]); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// DetectSections returns every line of a normalized log that matches one of markers
+func DetectSections(r io.Reader, markers []Marker) ([]Section, error) {
+	sections := make([]Section, 0)
+	err := eachLine(r, func(n int, line string) {
+		for _, m := range markers {
+			if match := m.Pattern.FindStringSubmatch(line); match != nil {
+				sections = append(sections, Section{Name: m.Name, Line: n, Text: line, Values: match[1:]})
+			}
+		}
+	})
+
+	return sections, err
+}
+
+// Split normalizes a log and splits it into segments. A new segment starts at every timestamped
+// line and at every line matching one of markers, lines before the first boundary form their own segment.
+func Split(r io.Reader, markers []Marker) ([]Segment, error) {
+	segments := make([]Segment, 0)
+	err := eachLine(r, func(n int, line string) {
+		start, stamped := ParseTimestamp(line)
+		section := ""
+		for _, m := range markers {
+			if m.Pattern.MatchString(line) {
+				section = m.Name
+				break
+			}
+		}
+		if len(segments) == 0 || stamped || section != "" {
+			segments = append(segments, Segment{Start: start, Section: section, FirstLine: n, Lines: make([]string, 0, 1)})
+		}
+		current := &segments[len(segments)-1]
+		current.Lines = append(current.Lines, line)
+	})
+
+	return segments, err
+}
+
+// eachLine calls fn with every normalized line of r, numbered from 1
+func eachLine(r io.Reader, fn func(n int, line string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		fn(n, strings.TrimRight(Normalize(scanner.Text()), " "))
+	}
+
+	return scanner.Err()
+}
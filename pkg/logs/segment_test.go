@@ -0,0 +1,102 @@
+package logs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const segmentLog = "preamble\n" +
+	"2024-06-20T10:00:00Z starting\n" +
+	"continued   \n" +
+	"Build-agent version 1.0.123 (2024-06-01)\n" +
+	"\x1b[32mStarting container cimg/go:1.22\x1b[0m\n" +
+	"  using image cimg/go@sha256:abc\n" +
+	"10:00:05 done\n" +
+	"Exited with code exit status 1\n"
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{line: "2024-06-20T10:00:00Z starting", want: time.Date(2024, 6, 20, 10, 0, 0, 0, time.UTC), ok: true},
+		{line: "2024-06-20T12:00:00.5+02:00 starting", want: time.Date(2024, 6, 20, 10, 0, 0, 500000000, time.UTC), ok: true},
+		{line: "[2024-06-20 10:00:00] starting", want: time.Date(2024, 6, 20, 10, 0, 0, 0, time.UTC), ok: true},
+		{line: "10:00:05 done", want: time.Date(0, 1, 1, 10, 0, 5, 0, time.UTC), ok: true},
+		{line: "version 2024-06-20T10:00:00Z", ok: false},
+		{line: "2024-06-20T10:00:00Z", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseTimestamp(tt.line)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("ParseTimestamp = %v, %t, want %v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	segments, err := Split(strings.NewReader(segmentLog), DefaultMarkers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		section   string
+		firstLine int
+		start     time.Time
+		lines     []string
+	}{
+		{"", 1, time.Time{}, []string{"preamble"}},
+		{"", 2, time.Date(2024, 6, 20, 10, 0, 0, 0, time.UTC), []string{"2024-06-20T10:00:00Z starting", "continued"}},
+		{"build-agent", 4, time.Time{}, []string{"Build-agent version 1.0.123 (2024-06-01)"}},
+		{"starting-container", 5, time.Time{}, []string{"Starting container cimg/go:1.22"}},
+		{"image", 6, time.Time{}, []string{"  using image cimg/go@sha256:abc"}},
+		{"", 7, time.Date(0, 1, 1, 10, 0, 5, 0, time.UTC), []string{"10:00:05 done"}},
+		{"exit-code", 8, time.Time{}, []string{"Exited with code exit status 1"}},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d: %+v", len(segments), len(want), segments)
+	}
+	for i, w := range want {
+		s := segments[i]
+		if s.Section != w.section || s.FirstLine != w.firstLine || !s.Start.Equal(w.start) || !reflect.DeepEqual(s.Lines, w.lines) {
+			t.Errorf("segment %d = %+v, want %+v", i, s, w)
+		}
+	}
+}
+
+func TestSplitEmpty(t *testing.T) {
+	segments, err := Split(strings.NewReader(""), DefaultMarkers)
+	if err != nil || len(segments) != 0 {
+		t.Errorf("Split of an empty log = %+v, %v, want no segments", segments, err)
+	}
+}
+
+func TestDetectSections(t *testing.T) {
+	sections, err := DetectSections(strings.NewReader(segmentLog), DefaultMarkers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Section{
+		{Name: "build-agent", Line: 4, Text: "Build-agent version 1.0.123 (2024-06-01)", Values: []string{"1.0.123"}},
+		{Name: "starting-container", Line: 5, Text: "Starting container cimg/go:1.22", Values: []string{"cimg/go:1.22"}},
+		{Name: "image", Line: 6, Text: "  using image cimg/go@sha256:abc", Values: []string{"cimg/go@sha256:abc"}},
+		{Name: "exit-code", Line: 8, Text: "Exited with code exit status 1", Values: []string{"1"}},
+	}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("DetectSections =\n%+v\nwant\n%+v", sections, want)
+	}
+
+	// markers without capture groups report no values
+	sections, err = DetectSections(strings.NewReader("Too long with no output (exceeded 10m0s)\n"), DefaultMarkers)
+	if err != nil || len(sections) != 1 || sections[0].Name != "too-long-no-output" || len(sections[0].Values) != 0 {
+		t.Errorf("DetectSections = %+v, %v", sections, err)
+	}
+}
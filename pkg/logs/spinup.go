@@ -0,0 +1,161 @@
+package logs
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ExecutorDocker  = "docker"
+	ExecutorMachine = "machine"
+	ExecutorMacos   = "macos"
+	ExecutorRunner  = "runner"
+)
+
+// SpinUpImage is a container image started by a docker executor
+type SpinUpImage struct {
+	Name       string        `json:"name"`
+	Reference  string        `json:"reference"`
+	Digest     string        `json:"digest"`
+	PullStats  string        `json:"pull_stats,omitempty"`
+	CreateTime time.Duration `json:"create_time,omitempty"`
+}
+
+// SpinUp is what the "Spin up environment" step reports about the executor a job ran on
+type SpinUp struct {
+	ExecutorType       string                   `json:"executor_type"`
+	ResourceClass      string                   `json:"resource_class,omitempty"`
+	BuildAgentVersion  string                   `json:"build_agent_version"`
+	LaunchAgentVersion string                   `json:"launch_agent_version,omitempty"`
+	Images             []SpinUpImage            `json:"images"`
+	MachineImage       string                   `json:"machine_image,omitempty"`
+	VMID               string                   `json:"vm_id,omitempty"`
+	Volumes            []string                 `json:"volumes"`
+	Runner             string                   `json:"runner,omitempty"`
+	System             map[string]string        `json:"system"`
+	Timings            map[string]time.Duration `json:"timings"`
+}
+
+var (
+	spinUpBuildAgent    = regexp.MustCompile(`^Build-agent version (\S+)`)
+	spinUpLaunchAgent   = regexp.MustCompile(`^Launch-agent version (\S+)`)
+	spinUpStarting      = regexp.MustCompile(`^Starting container (\S+)`)
+	spinUpUsingImage    = regexp.MustCompile(`^\s+using image (\S+)`)
+	spinUpPullStats     = regexp.MustCompile(`^\s+pull stats: (.*)`)
+	spinUpCreateTime    = regexp.MustCompile(`^\s+time to create container: (\S+)`)
+	spinUpDedicatedVM   = regexp.MustCompile(`^Creating a dedicated VM with (\S+) image`)
+	spinUpVMCreated     = regexp.MustCompile(`^VM '([^']+)' has been created`)
+	spinUpVolume        = regexp.MustCompile(`^Using volume:\s*(\S+)`)
+	spinUpResourceClass = regexp.MustCompile(`(?i)^\s*resource[ _-]class:\s*(\S+)`)
+	spinUpRunner        = regexp.MustCompile(`(?i)^\s*runner(?: name| hostname)?:\s*(.+)$`)
+	spinUpTiming        = regexp.MustCompile(`(?i)^\s*(time to [^:]+|waited)[: ]\s*(\S+)`)
+	spinUpSystemLine    = regexp.MustCompile(`^ ([A-Z][\w ]+): (.+)$`)
+	spinUpDigest        = regexp.MustCompile(`sha256:[0-9a-f]{64}`)
+)
+
+// ParseSpinUp parses the output of the "Spin up environment" step of docker, machine, macOS and runner jobs.
+// Lines are matched anchored on their prefix after normalization, so unrelated lines mentioning
+// "default" or an image name no longer overwrite the VM or image.
+func ParseSpinUp(r io.Reader) (SpinUp, error) {
+	s := SpinUp{
+		Images:  make([]SpinUpImage, 0),
+		Volumes: make([]string, 0),
+		System:  make(map[string]string),
+		Timings: make(map[string]time.Duration),
+	}
+
+	inSystem := false
+	err := eachLine(r, func(_ int, line string) {
+		if line == "System information:" {
+			inSystem = true
+			return
+		}
+		if inSystem {
+			if m := spinUpSystemLine.FindStringSubmatch(line); m != nil {
+				s.System[m[1]] = strings.TrimSpace(m[2])
+				return
+			}
+			// nested lines such as "  Backing Filesystem: xfs" stay part of the block
+			if strings.HasPrefix(line, "  ") {
+				return
+			}
+			inSystem = false
+		}
+
+		switch {
+		case match(spinUpBuildAgent, line) != nil:
+			s.BuildAgentVersion = match(spinUpBuildAgent, line)[1]
+		case match(spinUpLaunchAgent, line) != nil:
+			s.LaunchAgentVersion = match(spinUpLaunchAgent, line)[1]
+		case match(spinUpStarting, line) != nil:
+			s.Images = append(s.Images, SpinUpImage{Name: match(spinUpStarting, line)[1]})
+		case match(spinUpUsingImage, line) != nil:
+			if len(s.Images) > 0 {
+				ref := match(spinUpUsingImage, line)[1]
+				s.Images[len(s.Images)-1].Reference = ref
+				s.Images[len(s.Images)-1].Digest = spinUpDigest.FindString(ref)
+			}
+		case match(spinUpPullStats, line) != nil:
+			if len(s.Images) > 0 {
+				s.Images[len(s.Images)-1].PullStats = match(spinUpPullStats, line)[1]
+			}
+		case match(spinUpCreateTime, line) != nil:
+			if len(s.Images) > 0 {
+				s.Images[len(s.Images)-1].CreateTime, _ = time.ParseDuration(match(spinUpCreateTime, line)[1])
+			}
+		case match(spinUpDedicatedVM, line) != nil:
+			s.MachineImage = match(spinUpDedicatedVM, line)[1]
+		case match(spinUpVMCreated, line) != nil:
+			s.VMID = match(spinUpVMCreated, line)[1]
+		case match(spinUpVolume, line) != nil:
+			s.Volumes = append(s.Volumes, match(spinUpVolume, line)[1])
+		case match(spinUpResourceClass, line) != nil:
+			s.ResourceClass = match(spinUpResourceClass, line)[1]
+		case match(spinUpRunner, line) != nil:
+			s.Runner = strings.TrimSpace(match(spinUpRunner, line)[1])
+		case match(spinUpTiming, line) != nil:
+			m := match(spinUpTiming, line)
+			if d, err := time.ParseDuration(m[2]); err == nil {
+				s.Timings[strings.ToLower(m[1])] = d
+			}
+		}
+	})
+	s.ExecutorType = s.executorType()
+
+	return s, err
+}
+
+func match(re *regexp.Regexp, line string) []string {
+	return re.FindStringSubmatch(line)
+}
+
+// executorType infers the executor from what the step printed. Runners are the only executors
+// started by a launch agent, macOS VMs are created from an xcode image.
+func (s SpinUp) executorType() string {
+	switch {
+	case s.LaunchAgentVersion != "" || s.Runner != "" || strings.Contains(s.ResourceClass, "/"):
+		return ExecutorRunner
+	case strings.HasPrefix(s.MachineImage, "xcode"):
+		return ExecutorMacos
+	case len(s.Images) > 0:
+		return ExecutorDocker
+	case s.MachineImage != "" || s.VMID != "":
+		return ExecutorMachine
+	}
+
+	return ""
+}
+
+// PrimaryImage returns the image a job ran in: the first container's image reference or the VM image
+func (s SpinUp) PrimaryImage() string {
+	if len(s.Images) > 0 {
+		if s.Images[0].Reference != "" {
+			return s.Images[0].Reference
+		}
+		return s.Images[0].Name
+	}
+
+	return s.MachineImage
+}
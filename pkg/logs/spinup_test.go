@@ -0,0 +1,177 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpinUp(t *testing.T) {
+	tests := []struct {
+		fixture            string
+		executorType       string
+		resourceClass      string
+		images             []SpinUpImage
+		machineImage       string
+		buildAgentVersion  string
+		launchAgentVersion string
+		vmID               string
+		volumes            []string
+		runner             string
+		timings            map[string]time.Duration
+	}{
+		{
+			fixture:      "docker.txt",
+			executorType: ExecutorDocker,
+			images: []SpinUpImage{
+				{
+					Name:       "cimg/go:1.21",
+					Reference:  "cimg/go@sha256:6c3d9f0e21b2c6f0b6b1f0c1f9b33e5b8d1f7a3d2c4e5f60718293a4b5c6d7e8",
+					Digest:     "sha256:6c3d9f0e21b2c6f0b6b1f0c1f9b33e5b8d1f7a3d2c4e5f60718293a4b5c6d7e8",
+					PullStats:  "download 262.5MiB in 2.766s (94.91MiB/s), extract 262.5MiB in 8.452s (31.06MiB/s)",
+					CreateTime: 10640 * time.Millisecond,
+				},
+				{
+					Name:       "cimg/postgres:15.4",
+					Reference:  "cimg/postgres@sha256:0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+					Digest:     "sha256:0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+					PullStats:  "Image was already available so the image was not pulled",
+					CreateTime: 1215 * time.Millisecond,
+				},
+			},
+			buildAgentVersion: "1.0.190455-4e1ce0a5",
+			volumes:           []string{},
+			timings: map[string]time.Duration{
+				"time to upload agent and config": 671807476 * time.Nanosecond,
+				"time to start containers":        328632213 * time.Nanosecond,
+			},
+		},
+		{
+			fixture:           "machine.txt",
+			executorType:      ExecutorMachine,
+			images:            []SpinUpImage{},
+			machineImage:      "ubuntu-2204:2023.07.2",
+			buildAgentVersion: "1.0.190455-4e1ce0a5",
+			vmID:              "default-7f3c2a10-5b8e-4d61-9c0a-2e4f6b8d0a1c",
+			volumes:           []string{"1d5c0a7e-3b2f-4e8d-a6c9-0f1e2d3c4b5a"},
+			timings:           map[string]time.Duration{"time to upload agent and config": 1042371 * time.Microsecond},
+		},
+		{
+			fixture:           "macos.txt",
+			executorType:      ExecutorMacos,
+			resourceClass:     "macos.m1.medium.gen1",
+			images:            []SpinUpImage{},
+			machineImage:      "xcode:14.3.1",
+			buildAgentVersion: "1.0.190455-4e1ce0a5",
+			vmID:              "default-0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9",
+			volumes:           []string{},
+			timings:           map[string]time.Duration{"time to upload agent and config": 2318 * time.Millisecond},
+		},
+		{
+			fixture:            "runner.txt",
+			executorType:       ExecutorRunner,
+			resourceClass:      "bldmgr/windows",
+			images:             []SpinUpImage{},
+			buildAgentVersion:  "1.0.190455-4e1ce0a5",
+			launchAgentVersion: "1.1.73544-bc2d1a4",
+			volumes:            []string{},
+			runner:             "win-runner-02",
+			timings:            map[string]time.Duration{"waited": 3400 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "spinup", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			s, err := ParseSpinUp(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ExecutorType != tt.executorType {
+				t.Errorf("ExecutorType = %q, want %q", s.ExecutorType, tt.executorType)
+			}
+			if s.ResourceClass != tt.resourceClass {
+				t.Errorf("ResourceClass = %q, want %q", s.ResourceClass, tt.resourceClass)
+			}
+			if !reflect.DeepEqual(s.Images, tt.images) {
+				t.Errorf("Images = %+v, want %+v", s.Images, tt.images)
+			}
+			if s.MachineImage != tt.machineImage {
+				t.Errorf("MachineImage = %q, want %q", s.MachineImage, tt.machineImage)
+			}
+			if s.BuildAgentVersion != tt.buildAgentVersion {
+				t.Errorf("BuildAgentVersion = %q, want %q", s.BuildAgentVersion, tt.buildAgentVersion)
+			}
+			if s.LaunchAgentVersion != tt.launchAgentVersion {
+				t.Errorf("LaunchAgentVersion = %q, want %q", s.LaunchAgentVersion, tt.launchAgentVersion)
+			}
+			if s.VMID != tt.vmID {
+				t.Errorf("VMID = %q, want %q", s.VMID, tt.vmID)
+			}
+			if !reflect.DeepEqual(s.Volumes, tt.volumes) {
+				t.Errorf("Volumes = %v, want %v", s.Volumes, tt.volumes)
+			}
+			if s.Runner != tt.runner {
+				t.Errorf("Runner = %q, want %q", s.Runner, tt.runner)
+			}
+			if !reflect.DeepEqual(s.Timings, tt.timings) {
+				t.Errorf("Timings = %v, want %v", s.Timings, tt.timings)
+			}
+		})
+	}
+}
+
+func TestParseSpinUpDockerSystem(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "spinup", "docker.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s, err := ParseSpinUp(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Server Version":   "20.10.24",
+		"Storage Driver":   "overlay2",
+		"Cgroup Driver":    "cgroupfs",
+		"Cgroup Version":   "1",
+		"Kernel Version":   "5.15.0-1040-aws",
+		"Operating System": "Ubuntu 20.04.6 LTS",
+		"OSType":           "linux",
+		"Architecture":     "x86_64",
+	}
+	if !reflect.DeepEqual(s.System, want) {
+		t.Errorf("System = %v, want %v", s.System, want)
+	}
+}
+
+// Lines mentioning "default" used to be taken for the VM ID
+func TestParseSpinUpDefaultDoesNotOverwriteVM(t *testing.T) {
+	output := strings.Join([]string{
+		"Creating a dedicated VM with ubuntu-2204:2023.07.2 image",
+		"VM 'default-7f3c2a10-5b8e-4d61-9c0a-2e4f6b8d0a1c' has been created",
+		"Using default shell /bin/bash",
+		"Setting default region us-east-1",
+	}, "\n")
+
+	s, err := ParseSpinUp(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "default-7f3c2a10-5b8e-4d61-9c0a-2e4f6b8d0a1c"; s.VMID != want {
+		t.Errorf("VMID = %q, want %q", s.VMID, want)
+	}
+	if s.ExecutorType != ExecutorMachine {
+		t.Errorf("ExecutorType = %q, want %q", s.ExecutorType, ExecutorMachine)
+	}
+}
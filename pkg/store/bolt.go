@@ -0,0 +1,119 @@
+package store
+
+import (
+	"strconv"
+
+	"github.com/bldmgr/circleci"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketNumbers    = []byte("pipeline_numbers")
+	bucketUnfinished = []byte("unfinished_pipelines")
+	bucketStatuses   = []byte("statuses")
+	bucketLogs       = []byte("logs")
+)
+
+// Bolt is a circleci.Store kept in a single bbolt file
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the store file at path
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketNumbers, bucketUnfinished, bucketStatuses, bucketLogs} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+func (s *Bolt) get(bucket []byte, key string) (value []byte, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key)); v != nil {
+			value, ok = append([]byte(nil), v...), true
+		}
+		return nil
+	})
+
+	return value, ok, err
+}
+
+func (s *Bolt) put(bucket []byte, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+}
+
+func (s *Bolt) LastPipelineNumber(projectSlug string) (int, error) {
+	v, ok, err := s.get(bucketNumbers, projectSlug)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	return strconv.Atoi(string(v))
+}
+
+func (s *Bolt) SetLastPipelineNumber(projectSlug string, number int) error {
+	return s.put(bucketNumbers, projectSlug, []byte(strconv.Itoa(number)))
+}
+
+// unfinished pipelines are keyed "<project slug>\x00<pipeline id>" so a prefix scan lists a project
+func (s *Bolt) UnfinishedPipelines(projectSlug string) ([]string, error) {
+	ids := make([]string, 0)
+	prefix := []byte(projectSlug + "\x00")
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketUnfinished).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && len(k) >= len(prefix) && string(k[:len(prefix)]) == string(prefix); k, _ = c.Next() {
+			ids = append(ids, string(k[len(prefix):]))
+		}
+		return nil
+	})
+
+	return ids, err
+}
+
+func (s *Bolt) SetPipelineFinished(projectSlug string, pipelineId string, finished bool) error {
+	key := []byte(projectSlug + "\x00" + pipelineId)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if finished {
+			return tx.Bucket(bucketUnfinished).Delete(key)
+		}
+		return tx.Bucket(bucketUnfinished).Put(key, []byte{1})
+	})
+}
+
+func (s *Bolt) Status(kind string, id string) (string, bool, error) {
+	v, ok, err := s.get(bucketStatuses, kind+"/"+id)
+	return string(v), ok, err
+}
+
+func (s *Bolt) SetStatus(kind string, id string, status string) error {
+	return s.put(bucketStatuses, kind+"/"+id, []byte(status))
+}
+
+func (s *Bolt) Log(job circleci.JobRef, step string, node int) ([]byte, bool, error) {
+	return s.get(bucketLogs, circleci.LogKey(job, step, node))
+}
+
+func (s *Bolt) PutLog(job circleci.JobRef, step string, node int, data []byte) error {
+	return s.put(bucketLogs, circleci.LogKey(job, step, node), data)
+}
+
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,10 @@
+// Package store provides persistent implementations of circleci.Store: Bolt keeps everything in a
+// single bbolt file, SQLite in a SQLite database.
+package store
+
+import "github.com/bldmgr/circleci"
+
+var (
+	_ circleci.Store = (*Bolt)(nil)
+	_ circleci.Store = (*SQLite)(nil)
+)
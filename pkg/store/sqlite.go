@@ -0,0 +1,147 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bldmgr/circleci"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS pipeline_numbers (
+	project_slug TEXT PRIMARY KEY,
+	number       INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS unfinished_pipelines (
+	project_slug TEXT NOT NULL,
+	pipeline_id  TEXT NOT NULL,
+	PRIMARY KEY (project_slug, pipeline_id)
+);
+CREATE TABLE IF NOT EXISTS statuses (
+	kind   TEXT NOT NULL,
+	id     TEXT NOT NULL,
+	status TEXT NOT NULL,
+	PRIMARY KEY (kind, id)
+);
+CREATE TABLE IF NOT EXISTS logs (
+	project_slug TEXT NOT NULL,
+	job_number   INTEGER NOT NULL,
+	step         TEXT NOT NULL,
+	node         INTEGER NOT NULL,
+	output       BLOB NOT NULL,
+	PRIMARY KEY (project_slug, job_number, step, node)
+);
+`
+
+// SQLite is a circleci.Store kept in a SQLite database
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database at path
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// a single connection serializes writers instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+func (s *SQLite) LastPipelineNumber(projectSlug string) (int, error) {
+	var number int
+	err := s.db.QueryRow(`SELECT number FROM pipeline_numbers WHERE project_slug = ?`, projectSlug).Scan(&number)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+
+	return number, err
+}
+
+func (s *SQLite) SetLastPipelineNumber(projectSlug string, number int) error {
+	_, err := s.db.Exec(`INSERT INTO pipeline_numbers (project_slug, number) VALUES (?, ?)
+		ON CONFLICT (project_slug) DO UPDATE SET number = excluded.number`, projectSlug, number)
+
+	return err
+}
+
+func (s *SQLite) UnfinishedPipelines(projectSlug string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT pipeline_id FROM unfinished_pipelines WHERE project_slug = ? ORDER BY pipeline_id`, projectSlug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *SQLite) SetPipelineFinished(projectSlug string, pipelineId string, finished bool) error {
+	var err error
+	if finished {
+		_, err = s.db.Exec(`DELETE FROM unfinished_pipelines WHERE project_slug = ? AND pipeline_id = ?`, projectSlug, pipelineId)
+	} else {
+		_, err = s.db.Exec(`INSERT OR IGNORE INTO unfinished_pipelines (project_slug, pipeline_id) VALUES (?, ?)`, projectSlug, pipelineId)
+	}
+
+	return err
+}
+
+func (s *SQLite) Status(kind string, id string) (string, bool, error) {
+	var status string
+	err := s.db.QueryRow(`SELECT status FROM statuses WHERE kind = ? AND id = ?`, kind, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+
+	return status, err == nil, err
+}
+
+func (s *SQLite) SetStatus(kind string, id string, status string) error {
+	_, err := s.db.Exec(`INSERT INTO statuses (kind, id, status) VALUES (?, ?, ?)
+		ON CONFLICT (kind, id) DO UPDATE SET status = excluded.status`, kind, id, status)
+
+	return err
+}
+
+func (s *SQLite) Log(job circleci.JobRef, step string, node int) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT output FROM logs WHERE project_slug = ? AND job_number = ? AND step = ? AND node = ?`,
+		job.ProjectSlug, job.JobNumber, step, node).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+
+	return data, err == nil, err
+}
+
+func (s *SQLite) PutLog(job circleci.JobRef, step string, node int, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	_, err := s.db.Exec(`INSERT INTO logs (project_slug, job_number, step, node, output) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (project_slug, job_number, step, node) DO UPDATE SET output = excluded.output`,
+		job.ProjectSlug, job.JobNumber, step, node, data)
+
+	return err
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
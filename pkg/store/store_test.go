@@ -0,0 +1,97 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bldmgr/circleci"
+)
+
+type closingStore interface {
+	circleci.Store
+	Close() error
+}
+
+var backends = []struct {
+	name string
+	open func(path string) (closingStore, error)
+}{
+	{"bolt", func(path string) (closingStore, error) { return OpenBolt(path) }},
+	{"sqlite", func(path string) (closingStore, error) { return OpenSQLite(path) }},
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	job := circleci.JobRef{ProjectSlug: "gh/bldmgr/circleci", JobNumber: 7}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state")
+			s, err := b.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if last, err := s.LastPipelineNumber("gh/bldmgr/circleci"); err != nil || last != 0 {
+				t.Errorf("LastPipelineNumber of an empty store = %d, %v, want 0", last, err)
+			}
+			if _, ok, err := s.Status(circleci.StatusJob, "j1"); ok || err != nil {
+				t.Errorf("Status of an empty store = %v, %v, want not found", ok, err)
+			}
+			if _, ok, err := s.Log(job, "101", 0); ok || err != nil {
+				t.Errorf("Log of an empty store = %v, %v, want not found", ok, err)
+			}
+
+			must(t, s.SetLastPipelineNumber("gh/bldmgr/circleci", 42))
+			must(t, s.SetLastPipelineNumber("gh/bldmgr/other", 3))
+			must(t, s.SetPipelineFinished("gh/bldmgr/circleci", "p1", false))
+			must(t, s.SetPipelineFinished("gh/bldmgr/circleci", "p2", false))
+			must(t, s.SetPipelineFinished("gh/bldmgr/circleci", "p2", true))
+			must(t, s.SetPipelineFinished("gh/bldmgr/other", "p3", false))
+			must(t, s.SetStatus(circleci.StatusJob, "j1", "running"))
+			must(t, s.SetStatus(circleci.StatusJob, "j1", "success"))
+			must(t, s.SetStatus(circleci.StatusWorkflow, "j1", "failed"))
+			must(t, s.PutLog(job, "101", 0, []byte("line 1\nline 2\n")))
+			must(t, s.PutLog(job, "101", 1, []byte{}))
+			must(t, s.Close())
+
+			// everything survives reopening the file
+			s, err = b.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if last, err := s.LastPipelineNumber("gh/bldmgr/circleci"); err != nil || last != 42 {
+				t.Errorf("LastPipelineNumber = %d, %v, want 42", last, err)
+			}
+			if last, err := s.LastPipelineNumber("gh/bldmgr/other"); err != nil || last != 3 {
+				t.Errorf("LastPipelineNumber of another project = %d, %v, want 3", last, err)
+			}
+			if unfinished, err := s.UnfinishedPipelines("gh/bldmgr/circleci"); err != nil || len(unfinished) != 1 || unfinished[0] != "p1" {
+				t.Errorf("UnfinishedPipelines = %v, %v, want [p1]", unfinished, err)
+			}
+			if status, ok, err := s.Status(circleci.StatusJob, "j1"); err != nil || !ok || status != "success" {
+				t.Errorf("job Status = %q, %v, %v, want success", status, ok, err)
+			}
+			if status, ok, err := s.Status(circleci.StatusWorkflow, "j1"); err != nil || !ok || status != "failed" {
+				t.Errorf("workflow Status = %q, %v, %v, want failed, kinds are kept apart", status, ok, err)
+			}
+			if data, ok, err := s.Log(job, "101", 0); err != nil || !ok || string(data) != "line 1\nline 2\n" {
+				t.Errorf("Log = %q, %v, %v", data, ok, err)
+			}
+			if data, ok, err := s.Log(job, "101", 1); err != nil || !ok || len(data) != 0 {
+				t.Errorf("empty Log = %q, %v, %v, want stored and empty", data, ok, err)
+			}
+			if _, ok, err := s.Log(job, "102", 0); ok || err != nil {
+				t.Errorf("Log of another step = %v, %v, want not found", ok, err)
+			}
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,240 @@
+package circleci
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bldmgr/circleci/pkg/logs"
+)
+
+// SearchOptions narrows down and shapes a log search. The zero value searches every step of every
+// job with 4 concurrent streams and no context lines. MaxMatches keeps the first matches in the order
+// SearchLogs returns them, the search stops once every job before them was searched.
+type SearchOptions struct {
+	Concurrency int
+	Context     int
+	Jobs        *regexp.Regexp
+	Steps       *regexp.Regexp
+	FailedOnly  bool
+	MaxMatches  int
+}
+
+// LogMatch is a line of step output matching a search, with Context lines before and after it
+type LogMatch struct {
+	Workflow string   `json:"workflow"`
+	Job      JobRef   `json:"job"`
+	JobName  string   `json:"job_name"`
+	Step     string   `json:"step"`
+	StepName string   `json:"step_name"`
+	Node     int      `json:"node"`
+	Line     int      `json:"line"`
+	Text     string   `json:"text"`
+	Before   []string `json:"before,omitempty"`
+	After    []string `json:"after,omitempty"`
+
+	jobOrder  int
+	stepOrder int
+}
+
+// LogSearch is the result of SearchLogs. Outputs that could not be read are listed in Errors.
+type LogSearch struct {
+	PipelineID string      `json:"pipeline_id"`
+	Pattern    string      `json:"pattern"`
+	Matches    []LogMatch  `json:"matches"`
+	Errors     []ScanError `json:"errors"`
+}
+
+type searchJob struct {
+	workflow string
+	job      WorkflowItem
+	order    int
+}
+
+// SearchLogs searches the output of every step on every parallel node of a pipeline for pattern.
+// Outputs are streamed concurrently and matched after ANSI sequences are stripped. Matches are
+// ordered by workflow, job, step, node and line regardless of the order the outputs arrived in.
+func SearchLogs(ctx context.Context, ci CI, pipelineId string, pattern *regexp.Regexp, opts SearchOptions) (LogSearch, error) {
+	search := LogSearch{
+		PipelineID: pipelineId,
+		Pattern:    pattern.String(),
+		Matches:    make([]LogMatch, 0),
+		Errors:     make([]ScanError, 0),
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+
+	jobs := make([]searchJob, 0)
+	for _, workflow := range GetPipelineWorkflows(ci, pipelineId, "none") {
+		for _, job := range GetWorkflowJob(ci, workflow.ID, "none", "", "") {
+			if job.JobNumber == 0 || (opts.Jobs != nil && !opts.Jobs.MatchString(job.Name)) {
+				continue
+			}
+			if opts.FailedOnly && job.Status != "failed" {
+				continue
+			}
+			jobs = append(jobs, searchJob{workflow: workflow.Name, job: job, order: len(jobs)})
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// results are kept per job, the search only stops early once the jobs searched without a gap
+	// from the first one hold MaxMatches, so the matches kept do not depend on which stream was faster
+	results := make([][]LogMatch, len(jobs))
+	searched := make([]bool, len(jobs))
+	complete, completeMatches := 0, 0
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan searchJob)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sj := range queue {
+				matches, errs := searchJobLogs(ctx, ci, sj, pattern, opts)
+				mu.Lock()
+				results[sj.order] = matches
+				searched[sj.order] = ctx.Err() == nil
+				for _, err := range errs {
+					search.Errors = append(search.Errors, ScanError{ProjectSlug: sj.job.ProjectSlug, PipelineID: pipelineId, Message: err.Error()})
+				}
+				for complete < len(jobs) && searched[complete] {
+					completeMatches += len(results[complete])
+					complete++
+				}
+				if opts.MaxMatches > 0 && completeMatches >= opts.MaxMatches {
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, sj := range jobs {
+		select {
+		case queue <- sj:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, matches := range results {
+		search.Matches = append(search.Matches, matches...)
+	}
+	sort.SliceStable(search.Matches, func(i, j int) bool {
+		a, b := search.Matches[i], search.Matches[j]
+		if a.jobOrder != b.jobOrder {
+			return a.jobOrder < b.jobOrder
+		}
+		if a.stepOrder != b.stepOrder {
+			return a.stepOrder < b.stepOrder
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.Line < b.Line
+	})
+	if opts.MaxMatches > 0 && len(search.Matches) > opts.MaxMatches {
+		search.Matches = search.Matches[:opts.MaxMatches]
+	}
+
+	return search, parent.Err()
+}
+
+// searchJobLogs searches every step action of one job
+func searchJobLogs(ctx context.Context, ci CI, sj searchJob, pattern *regexp.Regexp, opts SearchOptions) ([]LogMatch, []error) {
+	matches := make([]LogMatch, 0)
+	errs := make([]error, 0)
+	job := sj.job.Ref()
+
+	for s, step := range GetBuildDetails(ci, job, "none").Steps {
+		if opts.Steps != nil && !opts.Steps.MatchString(step.Name) {
+			continue
+		}
+		for _, action := range step.Actions {
+			if ctx.Err() != nil {
+				return matches, errs
+			}
+			if !action.HasOutput {
+				continue
+			}
+			if opts.FailedOnly && action.Status != "failed" && action.Status != "timedout" {
+				continue
+			}
+			template := LogMatch{
+				Workflow:  sj.workflow,
+				Job:       job,
+				JobName:   sj.job.Name,
+				Step:      strconv.Itoa(action.Step),
+				StepName:  step.Name,
+				Node:      action.Index,
+				jobOrder:  sj.order,
+				stepOrder: s,
+			}
+			found, err := searchStep(ctx, ci, template, pattern, opts.Context)
+			matches = append(matches, found...)
+			if err != nil && ctx.Err() == nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return matches, errs
+}
+
+// searchStep streams one step output keeping the last lines to fill in Before and
+// completing After of the pending matches as later lines arrive
+func searchStep(ctx context.Context, ci CI, template LogMatch, pattern *regexp.Regexp, lines int) ([]LogMatch, error) {
+	r, err := OpenStepOutput(ctx, ci, template.Job, template.Step, template.Node)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	matches := make([]LogMatch, 0)
+	before := make([]string, 0, lines)
+	pending := make([]int, 0)
+	err = ForEachLine(r, func(n int, raw string) bool {
+		line := logs.Normalize(raw)
+
+		open := pending[:0]
+		for _, i := range pending {
+			matches[i].After = append(matches[i].After, line)
+			if len(matches[i].After) < lines {
+				open = append(open, i)
+			}
+		}
+		pending = open
+
+		if pattern.MatchString(line) {
+			m := template
+			m.Line = n
+			m.Text = line
+			if lines > 0 {
+				m.Before = append([]string(nil), before...)
+				pending = append(pending, len(matches))
+			}
+			matches = append(matches, m)
+		}
+
+		if lines > 0 {
+			if len(before) == lines {
+				before = before[1:]
+			}
+			before = append(before, line)
+		}
+		return ctx.Err() == nil
+	})
+
+	return matches, err
+}
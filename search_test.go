@@ -0,0 +1,120 @@
+package circleci
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowCI delays the step outputs of one job so it finishes after the jobs listed after it
+type slowCI struct {
+	*fakeCI
+	slow string
+}
+
+func (c *slowCI) Open(ctx context.Context, endpoint string) (io.ReadCloser, *http.Response, error) {
+	if strings.Contains(endpoint, c.slow) {
+		select {
+		case <-time.After(20 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	return c.fakeCI.Open(ctx, endpoint)
+}
+
+func searchRoutes() map[string]string {
+	details := `{"steps":[` +
+		`{"name":"Spin up environment","actions":[{"index":0,"step":0,"has_output":true}]},` +
+		`{"name":"make test","actions":[{"index":0,"step":101,"has_output":true,"status":"failed"},{"index":1,"step":101,"has_output":true,"status":"success"}]},` +
+		`{"name":"notify","actions":[{"index":0,"step":102,"has_output":false}]}]}`
+
+	return map[string]string{
+		"api/v2/pipeline/p1/workflow": `{"items":[{"id":"w1","name":"ci"}]}`,
+		"api/v2/workflow/w1/job": `{"items":[` +
+			`{"id":"j11","job_number":11,"name":"lint","status":"success","project_slug":"gh/bldmgr/circleci"},` +
+			`{"id":"hold","job_number":0,"name":"hold","type":"approval"},` +
+			`{"id":"j12","job_number":12,"name":"test","status":"failed","project_slug":"gh/bldmgr/circleci"},` +
+			`{"id":"j13","job_number":13,"name":"test-race","status":"success","project_slug":"gh/bldmgr/circleci"}]}`,
+
+		"api/v1.1/project/gh/bldmgr/circleci/11": details,
+		"api/v1.1/project/gh/bldmgr/circleci/12": details,
+		"api/v1.1/project/gh/bldmgr/circleci/13": details,
+
+		"api/v1.1/project/gh/bldmgr/circleci/11/output/0/0":   "spin up\n",
+		"api/v1.1/project/gh/bldmgr/circleci/11/output/101/0": "ok\n\x1b[31mFAIL\x1b[0m lint\nafter\n",
+		"api/v1.1/project/gh/bldmgr/circleci/12/output/101/0": "FAIL one\nFAIL two\n",
+		"api/v1.1/project/gh/bldmgr/circleci/12/output/101/1": "FAIL three\n",
+		"api/v1.1/project/gh/bldmgr/circleci/13/output/101/0": "FAIL four\nFAIL five\n",
+		"api/v1.1/project/gh/bldmgr/circleci/13/output/102/0": "FAIL not streamed\n",
+	}
+}
+
+func matchTexts(matches []LogMatch) []string {
+	texts := make([]string, len(matches))
+	for i, m := range matches {
+		texts[i] = m.JobName + ":" + m.Text
+	}
+
+	return texts
+}
+
+func TestSearchLogs(t *testing.T) {
+	ci := &fakeCI{routes: searchRoutes()}
+	search, err := SearchLogs(context.Background(), ci, "p1", regexp.MustCompile(`^FAIL`), SearchOptions{Context: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"lint:FAIL lint", "test:FAIL one", "test:FAIL two", "test:FAIL three", "test-race:FAIL four", "test-race:FAIL five"}
+	if got := matchTexts(search.Matches); !reflect.DeepEqual(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+	lint := search.Matches[0]
+	if lint.Line != 2 || lint.Step != "101" || lint.StepName != "make test" || !reflect.DeepEqual(lint.Before, []string{"ok"}) || !reflect.DeepEqual(lint.After, []string{"after"}) {
+		t.Errorf("first match = %+v, want line 2 with one line of context", lint)
+	}
+	if search.Matches[3].Node != 1 {
+		t.Errorf("match on node 1 = %+v", search.Matches[3])
+	}
+	if n := ci.requested("api/v1.1/project/gh/bldmgr/circleci/13/output/102"); n != 0 {
+		t.Errorf("streamed an action without output %d times", n)
+	}
+	if len(search.Errors) != 0 {
+		t.Errorf("errors = %+v", search.Errors)
+	}
+}
+
+func TestSearchLogsFailedOnly(t *testing.T) {
+	ci := &fakeCI{routes: searchRoutes()}
+	search, err := SearchLogs(context.Background(), ci, "p1", regexp.MustCompile(`^FAIL`), SearchOptions{FailedOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the failed job and its failed action on node 0
+	if got, want := matchTexts(search.Matches), []string{"test:FAIL one", "test:FAIL two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+}
+
+func TestSearchLogsMaxMatchesKeepsTheFirst(t *testing.T) {
+	want := []string{"lint:FAIL lint", "test:FAIL one", "test:FAIL two"}
+	for i := 0; i < 10; i++ {
+		// the first job finishes last, the matches of the later jobs must not take its place
+		ci := &slowCI{fakeCI: &fakeCI{routes: searchRoutes()}, slow: "/11/output/"}
+		search, err := SearchLogs(context.Background(), ci, "p1", regexp.MustCompile(`^FAIL`), SearchOptions{Concurrency: 3, MaxMatches: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchTexts(search.Matches); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: matches = %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package circleci
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+const (
+	SnapshotStagePipeline  = "pipeline"
+	SnapshotStageConfig    = "config"
+	SnapshotStageWorkflows = "workflows"
+	SnapshotStageJobs      = "jobs"
+	SnapshotStageDetails   = "details"
+	SnapshotStageSteps     = "steps"
+	SnapshotStageTests     = "tests"
+	SnapshotStageArtifacts = "artifacts"
+)
+
+// SnapshotOptions selects what SnapshotPipeline fetches besides workflows, jobs and steps. Logs keeps
+// the output of every step and parses the spin up and environment steps into the job environment.
+// Concurrency bounds the jobs fetched at once and defaults to 4.
+type SnapshotOptions struct {
+	Concurrency int
+	Logs        bool
+	Tests       bool
+	Artifacts   bool
+}
+
+// SnapshotError is a part of the pipeline SnapshotPipeline could not fetch
+type SnapshotError struct {
+	Stage      string `json:"stage"`
+	WorkflowID string `json:"workflow_id,omitempty"`
+	JobNumber  int    `json:"job_number,omitempty"`
+	JobName    string `json:"job_name,omitempty"`
+	Message    string `json:"message"`
+}
+
+// PipelineSnapshot is the full tree of a pipeline. Errors lists what is missing from it.
+type PipelineSnapshot struct {
+	Pipeline  PipelineItem    `json:"pipeline"`
+	Workflows []AllData       `json:"workflows"`
+	Errors    []SnapshotError `json:"errors"`
+}
+
+type snapshotTask struct {
+	workflow int
+	job      int
+}
+
+// SnapshotPipeline builds the AllData tree of a pipeline, fetching jobs with a bounded worker pool.
+// The compiled config is fetched once for every job. Workflows are ordered by creation time and jobs by
+// job number, so two snapshots of a finished pipeline are identical. A part that cannot be fetched is
+// recorded in Errors and the rest of the tree is still returned; the error is only set when ctx ends.
+func SnapshotPipeline(ctx context.Context, ci CI, pipelineId string, opts SnapshotOptions) (PipelineSnapshot, error) {
+	pipeline := GetPipelineById(ci, pipelineId, "none")
+	snapshot, err := snapshotPipeline(ctx, ci, pipelineId, opts)
+	snapshot.Pipeline = pipeline
+	if pipeline.ID == "" {
+		snapshot.Errors = append([]SnapshotError{{Stage: SnapshotStagePipeline, Message: "pipeline unavailable"}}, snapshot.Errors...)
+	}
+
+	return snapshot, err
+}
+
+// snapshotPipeline builds the snapshot of a pipeline without the pipeline itself, the export already
+// holds it from the listing
+func snapshotPipeline(ctx context.Context, ci CI, pipelineId string, opts SnapshotOptions) (PipelineSnapshot, error) {
+	snapshot := PipelineSnapshot{
+		Workflows: make([]AllData, 0),
+		Errors:    make([]SnapshotError, 0),
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	output := "none"
+	if opts.Logs {
+		output = "data"
+	}
+
+	config, err := fetchPipelineConfig(ctx, ci, pipelineId)
+	if err != nil {
+		snapshot.Errors = append(snapshot.Errors, SnapshotError{Stage: SnapshotStageConfig, Message: err.Error()})
+	}
+
+	workflows, err := listPipelineWorkflows(ctx, ci, pipelineId)
+	if err != nil {
+		snapshot.Errors = append(snapshot.Errors, SnapshotError{Stage: SnapshotStageWorkflows, Message: err.Error()})
+	} else if len(workflows) == 0 {
+		snapshot.Errors = append(snapshot.Errors, SnapshotError{Stage: SnapshotStageWorkflows, Message: "no workflows found"})
+	}
+	sort.SliceStable(workflows, func(i, j int) bool {
+		if !workflows[i].CreatedAt.Equal(workflows[j].CreatedAt) {
+			return workflows[i].CreatedAt.Before(workflows[j].CreatedAt)
+		}
+		return workflows[i].ID < workflows[j].ID
+	})
+
+	tasks := make([]snapshotTask, 0)
+	for w, workflow := range workflows {
+		jobs, err := listWorkflowJobs(ctx, ci, workflow.ID)
+		if err != nil {
+			snapshot.Errors = append(snapshot.Errors, SnapshotError{Stage: SnapshotStageJobs, WorkflowID: workflow.ID, Message: err.Error()})
+		} else if len(jobs) == 0 {
+			snapshot.Errors = append(snapshot.Errors, SnapshotError{Stage: SnapshotStageJobs, WorkflowID: workflow.ID, Message: "no jobs found"})
+		}
+		sort.SliceStable(jobs, func(i, j int) bool {
+			if jobs[i].JobNumber != jobs[j].JobNumber {
+				return jobs[i].JobNumber < jobs[j].JobNumber
+			}
+			return jobs[i].Name < jobs[j].Name
+		})
+
+		data := AllData{
+			PipelineID:       workflow.PipelineID,
+			ID:               workflow.ID,
+			Name:             workflow.Name,
+			ProjectSlug:      workflow.ProjectSlug,
+			Status:           workflow.Status,
+			StartedBy:        workflow.StartedBy,
+			PipelineNumber:   workflow.PipelineNumber,
+			CreatedAt:        workflow.CreatedAt,
+			StoppedAt:        workflow.StoppedAt,
+			Tag:              workflow.Tag,
+			WorkflowPipeline: make([]WorkflowPipeline, len(jobs)),
+		}
+		for j, job := range jobs {
+			data.WorkflowPipeline[j] = WorkflowPipeline{
+				JobNumber:    job.JobNumber,
+				Id:           job.Id,
+				StartedAt:    job.StartedAt,
+				Name:         job.Name,
+				ProjectSlug:  job.ProjectSlug,
+				Status:       job.Status,
+				Type:         job.Type,
+				StoppedAt:    job.StoppedAt,
+				JobDataSteps: make([]JobDataSteps, 0),
+			}
+			// approval jobs have nothing else to fetch
+			if job.JobNumber != 0 {
+				tasks = append(tasks, snapshotTask{workflow: w, job: j})
+			}
+		}
+		snapshot.Workflows = append(snapshot.Workflows, data)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan snapshotTask)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range queue {
+				// every task owns its WorkflowPipeline entry, only the error list is shared
+				job := &snapshot.Workflows[task.workflow].WorkflowPipeline[task.job]
+				errs := snapshotJob(ctx, ci, config, job, output, opts)
+				mu.Lock()
+				for _, e := range errs {
+					e.WorkflowID = snapshot.Workflows[task.workflow].ID
+					snapshot.Errors = append(snapshot.Errors, e)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	sort.SliceStable(snapshot.Errors, func(i, j int) bool {
+		a, b := snapshot.Errors[i], snapshot.Errors[j]
+		if a.WorkflowID != b.WorkflowID {
+			return a.WorkflowID < b.WorkflowID
+		}
+		return a.JobNumber < b.JobNumber
+	})
+
+	return snapshot, ctx.Err()
+}
+
+// snapshotJob fills in the steps and the optional parts of one job
+func snapshotJob(ctx context.Context, ci CI, config PipelineConfig, job *WorkflowPipeline, output string, opts SnapshotOptions) []SnapshotError {
+	errs := make([]SnapshotError, 0)
+	fail := func(stage string, message string) {
+		errs = append(errs, SnapshotError{Stage: stage, JobNumber: job.JobNumber, JobName: job.Name, Message: message})
+	}
+
+	ref := JobRef{ProjectSlug: job.ProjectSlug, JobNumber: job.JobNumber}
+	details := GetJobDetailsRef(ci, ref)
+	if details.Number == 0 {
+		fail(SnapshotStageDetails, "job details unavailable")
+	} else {
+		job.Details = &details
+	}
+
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	steps, env := processJobs(ctx, ci, job.Name, job.JobNumber, project, namespace, vcs, output, opts.Logs, selectCompiledConfig(config, job.Name))
+	if len(steps) == 0 {
+		fail(SnapshotStageSteps, "no steps found")
+	}
+	job.JobDataSteps = steps
+	job.Environment = env
+
+	// tests and artifacts stay nil when they could not be fetched, an empty list means the job has none
+	if opts.Tests {
+		if tests, err := fetchTestMetadata(ctx, ci, ref); err != nil {
+			fail(SnapshotStageTests, err.Error())
+		} else {
+			job.Tests = tests
+		}
+	}
+	if opts.Artifacts {
+		if artifacts, err := fetchJobArtifacts(ctx, ci, ref); err != nil {
+			fail(SnapshotStageArtifacts, err.Error())
+		} else {
+			job.Artifacts = artifacts
+		}
+	}
+
+	return errs
+}
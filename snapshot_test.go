@@ -0,0 +1,150 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const snapshotCompiled = `
+version: 2.1
+jobs:
+  build:
+    docker:
+      - image: cimg/go:1.22
+    steps:
+      - checkout
+      - run: make build
+  test:
+    docker:
+      - image: cimg/go:1.22
+    steps:
+      - checkout
+      - run: make test
+`
+
+// concurrencyCI records how many job detail requests are in flight at once
+type concurrencyCI struct {
+	*fakeCI
+
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *concurrencyCI) Get(endpoint string) ([]byte, *http.Response, error) {
+	if !strings.Contains(endpoint, "/job/") {
+		return c.fakeCI.Get(endpoint)
+	}
+
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.max {
+		c.max = c.inFlight
+	}
+	c.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	return c.fakeCI.Get(endpoint)
+}
+
+func snapshotRoutes() map[string]string {
+	return map[string]string{
+		"api/v2/pipeline/p1":          `{"id":"p1","number":3,"project_slug":"gh/bldmgr/circleci"}`,
+		"api/v2/pipeline/p1/config":   `{"source":"version: 2.1","compiled":` + jsonString(snapshotCompiled) + `}`,
+		"api/v2/pipeline/p1/workflow": `{"items":[{"id":"w2","name":"deploy","created_at":"2024-06-20T10:05:00Z"},{"id":"w1","name":"ci","created_at":"2024-06-20T10:00:00Z"}]}`,
+		"api/v2/workflow/w1/job": `{"items":[` +
+			`{"id":"j12","job_number":12,"name":"test","project_slug":"gh/bldmgr/circleci"},` +
+			`{"id":"hold","job_number":0,"name":"hold","type":"approval","project_slug":"gh/bldmgr/circleci"},` +
+			`{"id":"j11","job_number":11,"name":"build","project_slug":"gh/bldmgr/circleci"}]}`,
+		"api/v2/workflow/w2/job": `{"items":[{"id":"j13","job_number":13,"name":"build","project_slug":"gh/bldmgr/circleci"}]}`,
+
+		"api/v2/project/gh/bldmgr/circleci/job/11": `{"number":11}`,
+		"api/v2/project/gh/bldmgr/circleci/job/12": `{"number":12}`,
+		"api/v1.1/project/gh/bldmgr/circleci/11":   `{"steps":[{"name":"Checkout code","actions":[{"index":0,"step":101}]},{"name":"make build","actions":[{"index":0,"step":102}]}]}`,
+		"api/v1.1/project/gh/bldmgr/circleci/12":   `{"steps":[{"name":"Checkout code","actions":[{"index":0,"step":101}]},{"name":"make test","actions":[{"index":0,"step":102}]}]}`,
+
+		"api/v2/project/gh/bldmgr/circleci/11/tests":     `{"items":[]}`,
+		"api/v2/project/gh/bldmgr/circleci/13/tests":     `{"items":[{"name":"TestBuild","result":"success"}]}`,
+		"api/v2/project/gh/bldmgr/circleci/11/artifacts": `{"items":[{"path":"bin/app"}]}`,
+		"api/v2/project/gh/bldmgr/circleci/12/artifacts": `{"items":[]}`,
+	}
+}
+
+func TestSnapshotPipeline(t *testing.T) {
+	var first []byte
+	for _, concurrency := range []int{1, 2, 8} {
+		ci := &concurrencyCI{fakeCI: &fakeCI{routes: snapshotRoutes()}}
+		snapshot, err := SnapshotPipeline(context.Background(), ci, "p1", SnapshotOptions{Concurrency: concurrency, Tests: true, Artifacts: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ci.max == 0 || ci.max > concurrency {
+			t.Errorf("concurrency %d: %d jobs fetched at once", concurrency, ci.max)
+		}
+
+		if snapshot.Pipeline.ID != "p1" {
+			t.Errorf("Pipeline = %+v", snapshot.Pipeline)
+		}
+		order := make([]string, 0)
+		for _, w := range snapshot.Workflows {
+			for _, j := range w.WorkflowPipeline {
+				order = append(order, w.ID+"/"+j.Name)
+			}
+		}
+		if want := []string{"w1/hold", "w1/build", "w1/test", "w2/build"}; !reflect.DeepEqual(order, want) {
+			t.Errorf("concurrency %d: jobs in order %v, want %v", concurrency, order, want)
+		}
+
+		jobs := snapshot.Workflows[0].WorkflowPipeline
+		if jobs[1].Details == nil || jobs[1].Tests == nil || len(jobs[1].Artifacts) != 1 || len(jobs[1].JobDataSteps) != 2 {
+			t.Errorf("build job = %+v, want details, an empty test list, one artifact and two steps", jobs[1])
+		}
+		if jobs[2].Tests != nil || jobs[2].Artifacts == nil {
+			t.Errorf("test job tests = %v artifacts = %v, want tests left nil after the failed fetch", jobs[2].Tests, jobs[2].Artifacts)
+		}
+
+		stages := make([]string, 0)
+		for _, e := range snapshot.Errors {
+			stages = append(stages, e.WorkflowID+"/"+e.Stage)
+		}
+		want := []string{"w1/tests", "w2/details", "w2/artifacts"}
+		if !reflect.DeepEqual(stages, want) {
+			t.Errorf("concurrency %d: errors %+v, want stages %v", concurrency, snapshot.Errors, want)
+		}
+
+		// the same pipeline gives the same snapshot whatever the concurrency
+		out, _ := json.Marshal(snapshot)
+		if first == nil {
+			first = out
+		} else if string(out) != string(first) {
+			t.Errorf("concurrency %d: snapshot differs from concurrency 1\n%s\n%s", concurrency, out, first)
+		}
+	}
+}
+
+func TestSnapshotPipelineUnavailable(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{}}
+	snapshot, err := SnapshotPipeline(context.Background(), ci, "p1", SnapshotOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := make([]string, 0)
+	for _, e := range snapshot.Errors {
+		stages = append(stages, e.Stage)
+	}
+	if want := []string{SnapshotStagePipeline, SnapshotStageConfig, SnapshotStageWorkflows}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("errors %+v, want stages %v", snapshot.Errors, want)
+	}
+}
@@ -0,0 +1,267 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StepSpinUp     = "Spin up environment"
+	StepPrepareEnv = "Preparing environment variables"
+)
+
+// BuildAction is one executed action of a step on one parallel node (v1.1 build details)
+type BuildAction struct {
+	Index         int       `json:"index"`
+	Step          int       `json:"step"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Status        string    `json:"status"`
+	Failed        *bool     `json:"failed"`
+	ExitCode      *int      `json:"exit_code"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	RunTimeMillis int64     `json:"run_time_millis"`
+	OutputURL     string    `json:"output_url"`
+	HasOutput     bool      `json:"has_output"`
+	BashCommand   *string   `json:"bash_command"`
+	Background    bool      `json:"background"`
+	Parallel      bool      `json:"parallel"`
+	Truncated     bool      `json:"truncated"`
+	Timedout      *bool     `json:"timedout"`
+}
+
+// BuildStep groups the actions of one step across parallel nodes
+type BuildStep struct {
+	Name    string        `json:"name"`
+	Actions []BuildAction `json:"actions"`
+}
+
+// BuildDetails is the subset of the v1.1 build details used to discover executed steps
+type BuildDetails struct {
+	BuildNum  int         `json:"build_num"`
+	Status    string      `json:"status"`
+	Outcome   string      `json:"outcome"`
+	Lifecycle string      `json:"lifecycle"`
+	Parallel  int         `json:"parallel"`
+	Steps     []BuildStep `json:"steps"`
+}
+
+// ExecutedStep is an executed action mapped back to the config step that produced it.
+// ConfigIndex is -1 for steps CircleCI adds on its own such as spin up or environment preparation.
+type ExecutedStep struct {
+	ID          string      `json:"id"`
+	Node        int         `json:"node"`
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Status      string      `json:"status"`
+	ExitCode    *int        `json:"exit_code"`
+	StartTime   time.Time   `json:"start_time"`
+	EndTime     time.Time   `json:"end_time"`
+	OutputURL   string      `json:"output_url"`
+	Background  bool        `json:"background"`
+	ConfigIndex int         `json:"config_index"`
+	ConfigStep  interface{} `json:"config_step,omitempty"`
+}
+
+// GetBuildDetails returns the v1.1 build details of a job, including its executed steps
+func GetBuildDetails(ci CI, job JobRef, output string) (items BuildDetails) {
+	var p BuildDetails
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	url := fmt.Sprintf(restGetBuildDetail, vcs, namespace, project, strconv.Itoa(job.JobNumber))
+	body, resp, err := ci.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		fmt.Printf("could not read items from response: %v", err)
+	}
+
+	if output == "json" {
+		fmt.Printf(string(body) + "\n")
+	}
+
+	return p
+}
+
+// fetchBuildDetails returns the v1.1 details of a job, unlike GetBuildDetails a failed request is reported
+func fetchBuildDetails(ctx context.Context, ci CI, job JobRef) (details BuildDetails, err error) {
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	url := fmt.Sprintf(restGetBuildDetail, vcs, namespace, project, strconv.Itoa(job.JobNumber))
+	if err := getPage(ctx, ci, url, &details); err != nil {
+		return details, fmt.Errorf("job %d details: %w", job.JobNumber, err)
+	}
+
+	return details, nil
+}
+
+// GetExecutedSteps returns the steps a job actually ran on one node, mapped to the job's config steps.
+// configSteps are the steps of the job definition, e.g. ResolvedJob.Steps.
+func GetExecutedSteps(ci CI, job JobRef, node int, configSteps []interface{}) []ExecutedStep {
+	return MapExecutedSteps(GetBuildDetails(ci, job, "none"), node, configSteps)
+}
+
+// MapExecutedSteps maps the actions of one node to config steps. Actions and config steps are walked in
+// order and an action is matched to the next config step whose display name it carries, so steps skipped
+// by `when` conditions or added by CircleCI are left unmatched rather than shifting every later step.
+func MapExecutedSteps(details BuildDetails, node int, configSteps []interface{}) []ExecutedStep {
+	flat := flattenSteps(configSteps)
+	names := make([]string, len(flat))
+	for i := range flat {
+		names[i] = stepDisplayName(flat[i])
+	}
+
+	executed := make([]ExecutedStep, 0, len(details.Steps))
+	next := 0
+	for _, step := range details.Steps {
+		action, ok := nodeAction(step, node)
+		if !ok {
+			continue
+		}
+		e := ExecutedStep{
+			ID:          strconv.Itoa(action.Step),
+			Node:        action.Index,
+			Name:        action.Name,
+			Type:        action.Type,
+			Status:      action.Status,
+			ExitCode:    action.ExitCode,
+			StartTime:   action.StartTime,
+			EndTime:     action.EndTime,
+			OutputURL:   action.OutputURL,
+			Background:  action.Background,
+			ConfigIndex: -1,
+		}
+		if i := matchConfigStep(action.Name, names, next); i >= 0 {
+			e.ConfigIndex = i
+			e.ConfigStep = flat[i]
+			next = i + 1
+		}
+		executed = append(executed, e)
+	}
+
+	return executed
+}
+
+// matchConfigStep returns the index of the first config step from next on that an action belongs to,
+// -1 when there is none. Exact names are tried before prefixes, so "run tests (integration)" is not taken
+// for an earlier "run tests" step and the steps in between stay available to the following actions.
+func matchConfigStep(action string, names []string, next int) int {
+	a := headLine(action)
+	for i := next; i < len(names); i++ {
+		if e := headLine(names[i]); e != "" && a == e {
+			return i
+		}
+	}
+	for i := next; i < len(names); i++ {
+		if stepNameMatches(action, names[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// stepNameMatches compares the first line of an action name with the expected step name,
+// long unnamed run commands are shown truncated
+func stepNameMatches(action string, expected string) bool {
+	a, e := headLine(action), headLine(expected)
+	if e == "" {
+		return false
+	}
+
+	return a == e || strings.HasPrefix(a, e) || (len(a) >= 32 && strings.HasPrefix(e, a))
+}
+
+func headLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+
+	return strings.TrimSpace(s)
+}
+
+// nodeAction returns the action of a step that ran on node, steps that are not parallel only run on node 0
+func nodeAction(step BuildStep, node int) (BuildAction, bool) {
+	for _, a := range step.Actions {
+		if a.Index == node {
+			return a, true
+		}
+	}
+	if len(step.Actions) == 1 && !step.Actions[0].Parallel {
+		return step.Actions[0], true
+	}
+
+	return BuildAction{}, false
+}
+
+// flattenSteps inlines the steps of `when` and `unless` blocks
+func flattenSteps(steps []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(steps))
+	for _, step := range steps {
+		if m, ok := step.(map[string]interface{}); ok && len(m) == 1 {
+			for kind, v := range m {
+				if kind == "when" || kind == "unless" {
+					if body, ok := v.(map[string]interface{}); ok {
+						inner, _ := body["steps"].([]interface{})
+						out = append(out, flattenSteps(inner)...)
+						continue
+					}
+				}
+				out = append(out, step)
+			}
+			continue
+		}
+		out = append(out, step)
+	}
+
+	return out
+}
+
+// builtinStepNames are the names CircleCI shows for built-in steps that were not given a name
+var builtinStepNames = map[string]string{
+	"checkout":             "Checkout code",
+	"setup_remote_docker":  "Setup a remote Docker engine",
+	"save_cache":           "Saving cache",
+	"restore_cache":        "Restoring cache",
+	"store_artifacts":      "Uploading artifacts",
+	"store_test_results":   "Uploading test results",
+	"persist_to_workspace": "Persisting to Workspace",
+	"attach_workspace":     "Attaching workspace",
+	"add_ssh_keys":         "Install additional SSH keys",
+}
+
+// stepDisplayName returns the name CircleCI gives an executed config step
+func stepDisplayName(step interface{}) string {
+	switch v := step.(type) {
+	case string:
+		return builtinStepNames[v]
+	case map[string]interface{}:
+		for kind, value := range v {
+			switch d := value.(type) {
+			case string:
+				if kind == "run" {
+					return strings.TrimSpace(d)
+				}
+				return builtinStepNames[kind]
+			case map[string]interface{}:
+				if name, ok := d["name"]; ok {
+					return strings.TrimSpace(fmt.Sprint(name))
+				}
+				if command, ok := d["command"]; ok && kind == "run" {
+					return strings.TrimSpace(fmt.Sprint(command))
+				}
+				return builtinStepNames[kind]
+			}
+		}
+	}
+
+	return ""
+}
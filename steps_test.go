@@ -0,0 +1,119 @@
+package circleci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func buildSteps(names ...string) BuildDetails {
+	details := BuildDetails{Steps: make([]BuildStep, 0, len(names))}
+	for i, name := range names {
+		details.Steps = append(details.Steps, BuildStep{Name: name, Actions: []BuildAction{{Step: 100 + i, Name: name}}})
+	}
+
+	return details
+}
+
+func runStep(name string) map[string]interface{} {
+	return map[string]interface{}{"run": map[string]interface{}{"name": name, "command": "true"}}
+}
+
+func TestMapExecutedSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []string
+		config  []interface{}
+		want    []int
+	}{
+		{
+			name:    "built-in and added steps",
+			actions: []string{StepSpinUp, StepPrepareEnv, "Checkout code", "make build"},
+			config:  []interface{}{"checkout", map[string]interface{}{"run": "make build"}},
+			want:    []int{-1, -1, 0, 1},
+		},
+		{
+			name:    "exact name before an earlier prefix",
+			actions: []string{"run tests (integration)", "upload"},
+			config:  []interface{}{runStep("run tests"), runStep("run tests (integration)"), runStep("upload")},
+			want:    []int{1, 2},
+		},
+		{
+			name:    "both steps ran",
+			actions: []string{"run tests", "run tests (integration)"},
+			config:  []interface{}{runStep("run tests"), runStep("run tests (integration)")},
+			want:    []int{0, 1},
+		},
+		{
+			name:    "skipped when block",
+			actions: []string{"Checkout code", "deploy"},
+			config: []interface{}{
+				"checkout",
+				map[string]interface{}{"when": map[string]interface{}{"condition": false, "steps": []interface{}{runStep("notify")}}},
+				runStep("deploy"),
+			},
+			want: []int{0, 2},
+		},
+		{
+			name:    "unmatched action does not advance",
+			actions: []string{"Checkout code", "mystery", "make build"},
+			config:  []interface{}{"checkout", map[string]interface{}{"run": "make build"}},
+			want:    []int{0, -1, 1},
+		},
+		{
+			name:    "truncated long command",
+			actions: []string{"go test -race -coverprofile=cove"},
+			config:  []interface{}{map[string]interface{}{"run": "go test -race -coverprofile=coverage.out ./..."}},
+			want:    []int{0},
+		},
+		{
+			name:    "short names are not truncations",
+			actions: []string{"go"},
+			config:  []interface{}{map[string]interface{}{"run": "go test ./..."}},
+			want:    []int{-1},
+		},
+		{
+			name:    "multi line command",
+			actions: []string{"make deps\nmake test"},
+			config:  []interface{}{map[string]interface{}{"run": "make deps\nmake test\n"}},
+			want:    []int{0},
+		},
+		{
+			name:    "repeated names in order",
+			actions: []string{"Restoring cache", "Restoring cache"},
+			config:  []interface{}{map[string]interface{}{"restore_cache": map[string]interface{}{"key": "a"}}, map[string]interface{}{"restore_cache": map[string]interface{}{"key": "b"}}},
+			want:    []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executed := MapExecutedSteps(buildSteps(tt.actions...), 0, tt.config)
+			got := make([]int, len(executed))
+			for i, e := range executed {
+				got[i] = e.ConfigIndex
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config indexes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapExecutedStepsNode(t *testing.T) {
+	details := BuildDetails{Steps: []BuildStep{
+		{Name: "Checkout code", Actions: []BuildAction{{Step: 101, Name: "Checkout code"}}},
+		{Name: "make test", Actions: []BuildAction{
+			{Step: 102, Index: 0, Name: "make test", Parallel: true, Status: "success"},
+			{Step: 102, Index: 1, Name: "make test", Parallel: true, Status: "failed"},
+		}},
+		{Name: "upload", Actions: []BuildAction{{Step: 103, Index: 0, Name: "upload", Parallel: true}}},
+	}}
+
+	executed := MapExecutedSteps(details, 1, []interface{}{"checkout", map[string]interface{}{"run": "make test"}})
+	if len(executed) != 2 {
+		t.Fatalf("executed = %+v, want checkout and the node 1 action of make test", executed)
+	}
+	if executed[1].Node != 1 || executed[1].Status != "failed" || executed[1].ID != "102" || executed[1].ConfigIndex != 1 {
+		t.Errorf("node 1 action = %+v", executed[1])
+	}
+}
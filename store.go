@@ -0,0 +1,402 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	StatusWorkflow = "workflow"
+	StatusJob      = "job"
+)
+
+// Store remembers what a sync or a Watcher has already seen so later runs only fetch what changed.
+// MemoryStore keeps state for the life of the process, pkg/store has bolt and SQLite implementations.
+type Store interface {
+	// LastPipelineNumber returns the highest pipeline number synced for a project, 0 when none was
+	LastPipelineNumber(projectSlug string) (int, error)
+	SetLastPipelineNumber(projectSlug string, number int) error
+	// UnfinishedPipelines returns the pipelines of a project that still had running workflows
+	UnfinishedPipelines(projectSlug string) ([]string, error)
+	SetPipelineFinished(projectSlug string, pipelineId string, finished bool) error
+	// Status returns the last status recorded for a workflow or job, kind is StatusWorkflow or StatusJob
+	Status(kind string, id string) (status string, ok bool, err error)
+	SetStatus(kind string, id string, status string) error
+	// Log returns the stored output of a step on one node
+	Log(job JobRef, step string, node int) (data []byte, ok bool, err error)
+	PutLog(job JobRef, step string, node int, data []byte) error
+	Close() error
+}
+
+// LogKey returns the key the stores file step output under
+func LogKey(job JobRef, step string, node int) string {
+	return job.ProjectSlug + "/" + strconv.Itoa(job.JobNumber) + "/" + step + "/" + strconv.Itoa(node)
+}
+
+// MemoryStore is a Store that lives in memory
+type MemoryStore struct {
+	mu         sync.Mutex
+	numbers    map[string]int
+	unfinished map[string]map[string]bool
+	statuses   map[string]string
+	logs       map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		numbers:    make(map[string]int),
+		unfinished: make(map[string]map[string]bool),
+		statuses:   make(map[string]string),
+		logs:       make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) LastPipelineNumber(projectSlug string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.numbers[projectSlug], nil
+}
+
+func (s *MemoryStore) SetLastPipelineNumber(projectSlug string, number int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.numbers[projectSlug] = number
+	return nil
+}
+
+func (s *MemoryStore) UnfinishedPipelines(projectSlug string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortedKeys(s.unfinished[projectSlug]), nil
+}
+
+func (s *MemoryStore) SetPipelineFinished(projectSlug string, pipelineId string, finished bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if finished {
+		delete(s.unfinished[projectSlug], pipelineId)
+		return nil
+	}
+	if s.unfinished[projectSlug] == nil {
+		s.unfinished[projectSlug] = make(map[string]bool)
+	}
+	s.unfinished[projectSlug][pipelineId] = true
+	return nil
+}
+
+func (s *MemoryStore) Status(kind string, id string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[kind+"/"+id]
+	return status, ok, nil
+}
+
+func (s *MemoryStore) SetStatus(kind string, id string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses[kind+"/"+id] = status
+	return nil
+}
+
+func (s *MemoryStore) Log(job JobRef, step string, node int) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.logs[LogKey(job, step, node)]
+	return data, ok, nil
+}
+
+func (s *MemoryStore) PutLog(job JobRef, step string, node int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logs[LogKey(job, step, node)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// SyncOptions controls SyncProject. Pages bounds how far back pipelines are looked for on the first sync,
+// later syncs page back to the last pipeline synced. With FetchLogs the output of every step of
+// finished jobs is stored once.
+type SyncOptions struct {
+	Branch    string
+	Pages     int
+	FetchLogs bool
+}
+
+// SyncResult lists what changed since the previous sync
+type SyncResult struct {
+	ProjectSlug string              `json:"project_slug"`
+	Pipelines   []PipelineItem      `json:"pipelines"`
+	Workflows   []PipelineWorkflows `json:"workflows"`
+	Jobs        []WorkflowItem      `json:"jobs"`
+	Logs        int                 `json:"logs"`
+	Errors      []ScanError         `json:"errors"`
+}
+
+// SyncProject fetches the pipelines of a project created since the last sync together with the pipelines
+// that were still running then, and records workflow and job statuses in store. Workflows that had already
+// finished with the same status are not fetched again, neither are stored logs.
+func SyncProject(ctx context.Context, ci CI, store Store, projectSlug string, opts SyncOptions) (SyncResult, error) {
+	result := SyncResult{
+		ProjectSlug: projectSlug,
+		Pipelines:   make([]PipelineItem, 0),
+		Workflows:   make([]PipelineWorkflows, 0),
+		Jobs:        make([]WorkflowItem, 0),
+		Errors:      make([]ScanError, 0),
+	}
+	if opts.Pages <= 0 {
+		opts.Pages = 1
+	}
+
+	last, err := store.LastPipelineNumber(projectSlug)
+	if err != nil {
+		return result, err
+	}
+	unfinished, err := store.UnfinishedPipelines(projectSlug)
+	if err != nil {
+		return result, err
+	}
+
+	// pipelines are listed newest first, page until the last synced one shows up
+	pipelines := make([]PipelineItem, 0)
+	pageToken := ""
+	for page := 1; ; page++ {
+		list, err := listProjectPipelines(ctx, ci, projectSlug, opts.Branch, pageToken)
+		if err != nil {
+			return result, err
+		}
+		reached := false
+		for _, p := range list.Items {
+			if p.Number <= last {
+				reached = true
+				continue
+			}
+			pipelines = append(pipelines, p)
+			result.Pipelines = append(result.Pipelines, p)
+		}
+		if reached || list.ContinuationToken == "" || (last == 0 && page >= opts.Pages) {
+			break
+		}
+		pageToken = list.ContinuationToken
+	}
+	for _, id := range unfinished {
+		if p := GetPipelineById(ci, id, "none"); p.ID != "" {
+			pipelines = append(pipelines, p)
+		}
+	}
+	// oldest first so an interrupted sync resumes from the right pipeline number
+	sort.SliceStable(pipelines, func(i, j int) bool {
+		return pipelines[i].Number < pipelines[j].Number
+	})
+
+	for _, p := range pipelines {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		finished, err := syncPipeline(ctx, ci, store, p, opts, &result)
+		if err != nil {
+			return result, err
+		}
+		if err := store.SetPipelineFinished(projectSlug, p.ID, finished); err != nil {
+			return result, err
+		}
+		if p.Number > last {
+			last = p.Number
+			if err := store.SetLastPipelineNumber(projectSlug, last); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func syncPipeline(ctx context.Context, ci CI, store Store, p PipelineItem, opts SyncOptions, result *SyncResult) (finished bool, err error) {
+	workflows, err := listPipelineWorkflows(ctx, ci, p.ID)
+	if err != nil {
+		result.Errors = append(result.Errors, ScanError{ProjectSlug: p.ProjectSlug, PipelineID: p.ID, Message: err.Error()})
+		return false, nil
+	}
+	// a pipeline past created without workflows never gets any, e.g. an errored config
+	finished = len(workflows) > 0 || p.State != "created"
+
+	for _, workflow := range workflows {
+		previous, seen, err := store.Status(StatusWorkflow, workflow.ID)
+		if err != nil {
+			return false, err
+		}
+		if !isTerminalStatus(workflow.Status) {
+			finished = false
+		}
+		if seen && previous == workflow.Status && isTerminalStatus(previous) {
+			continue
+		}
+		if !seen || previous != workflow.Status {
+			result.Workflows = append(result.Workflows, workflow)
+		}
+
+		jobs, err := listWorkflowJobs(ctx, ci, workflow.ID)
+		if err != nil {
+			result.Errors = append(result.Errors, ScanError{ProjectSlug: p.ProjectSlug, PipelineID: p.ID, Message: err.Error()})
+			finished = false
+			continue
+		}
+		// the workflow status is only recorded once every job is, so a failed job is retried next sync
+		complete := true
+		for _, job := range jobs {
+			previous, seen, err := store.Status(StatusJob, job.Id)
+			if err != nil {
+				return false, err
+			}
+			if seen && previous == job.Status {
+				continue
+			}
+			result.Jobs = append(result.Jobs, job)
+			if opts.FetchLogs && job.JobNumber != 0 && isTerminalStatus(job.Status) {
+				if !syncLogs(ctx, ci, store, job.Ref(), p.ID, result) {
+					complete = false
+					continue
+				}
+			}
+			if err := store.SetStatus(StatusJob, job.Id, job.Status); err != nil {
+				return false, err
+			}
+		}
+		if !complete {
+			finished = false
+			continue
+		}
+
+		if err := store.SetStatus(StatusWorkflow, workflow.ID, workflow.Status); err != nil {
+			return false, err
+		}
+	}
+
+	return finished, nil
+}
+
+// syncLogs stores the output of every step action of a finished job that is not stored yet.
+// It returns false when a log could not be fetched or stored.
+func syncLogs(ctx context.Context, ci CI, store Store, job JobRef, pipelineId string, result *SyncResult) bool {
+	fail := func(err error) {
+		result.Errors = append(result.Errors, ScanError{ProjectSlug: job.ProjectSlug, PipelineID: pipelineId, Message: err.Error()})
+	}
+
+	details, err := fetchBuildDetails(ctx, ci, job)
+	if err != nil {
+		fail(err)
+		return false
+	}
+
+	ok := true
+	for _, step := range details.Steps {
+		for _, action := range step.Actions {
+			id := strconv.Itoa(action.Step)
+			_, stored, err := store.Log(job, id, action.Index)
+			if err != nil {
+				fail(err)
+				ok = false
+				continue
+			}
+			if stored {
+				continue
+			}
+			r, err := OpenStepOutput(ctx, ci, job, id, action.Index)
+			if err == nil {
+				var data []byte
+				data, err = io.ReadAll(r)
+				r.Close()
+				if err == nil {
+					err = store.PutLog(job, id, action.Index, data)
+				}
+			}
+			if err != nil {
+				fail(err)
+				ok = false
+				continue
+			}
+			result.Logs++
+		}
+	}
+
+	return ok
+}
+
+// listPipelineWorkflows returns every workflow of a pipeline, unlike GetPipelineWorkflows a failed
+// request is reported instead of ending the list early
+func listPipelineWorkflows(ctx context.Context, ci CI, pipelineId string) ([]PipelineWorkflows, error) {
+	items := make([]PipelineWorkflows, 0)
+	pageToken := ""
+	for {
+		var page listGetPipelineWorkflowsResponse
+		url := fmt.Sprintf(restPipelineWorkflows, pipelineId)
+		if pageToken != "" {
+			url = withPageToken(url, pageToken)
+		}
+		if err := getPage(ctx, ci, url, &page); err != nil {
+			return items, fmt.Errorf("listing workflows of pipeline %s: %w", pipelineId, err)
+		}
+		items = append(items, page.Items...)
+		if page.ContinuationToken == "" {
+			return items, nil
+		}
+		pageToken = page.ContinuationToken
+	}
+}
+
+// listWorkflowJobs returns every job of a workflow, a failed request is reported
+func listWorkflowJobs(ctx context.Context, ci CI, workflowId string) ([]WorkflowItem, error) {
+	items := make([]WorkflowItem, 0)
+	pageToken := ""
+	for {
+		var page listAssetsResponse
+		url := fmt.Sprintf(restWorkflowJob, workflowId)
+		if pageToken != "" {
+			url = withPageToken(url, pageToken)
+		}
+		if err := getPage(ctx, ci, url, &page); err != nil {
+			return items, fmt.Errorf("listing jobs of workflow %s: %w", workflowId, err)
+		}
+		items = append(items, page.Items...)
+		if page.ContinuationToken == "" {
+			return items, nil
+		}
+		pageToken = page.ContinuationToken
+	}
+}
+
+// listProjectPipelines returns one page of the pipelines of a project, newest first
+func listProjectPipelines(ctx context.Context, ci CI, projectSlug string, branch string, pageToken string) (listGetPipeline, error) {
+	var page listGetPipeline
+	url := projectPipelinesURL(projectSlug, branch)
+	if pageToken != "" {
+		url = withPageToken(url, pageToken)
+	}
+
+	body, resp, err := getWithContext(ctx, ci, url)
+	if err != nil {
+		return page, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return page, fmt.Errorf("listing pipelines of %s: %s", projectSlug, resp.Status)
+	}
+
+	return page, json.Unmarshal(body, &page)
+}
@@ -0,0 +1,113 @@
+package circleci
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSyncProjectPagesToLastPipeline(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/pipeline":               `{"items":[{"id":"p9","number":9},{"id":"p8","number":8}],"next_page_token":"t2"}`,
+		"api/v2/project/gh/bldmgr/circleci/pipeline?page-token=t2": `{"items":[{"id":"p7","number":7},{"id":"p6","number":6}],"next_page_token":"t3"}`,
+		"api/v2/project/gh/bldmgr/circleci/pipeline?page-token=t3": `{"items":[{"id":"p5","number":5},{"id":"p4","number":4}],"next_page_token":"t4"}`,
+	}}
+	store := NewMemoryStore()
+	store.SetLastPipelineNumber("gh/bldmgr/circleci", 5)
+
+	result, err := SyncProject(context.Background(), ci, store, "gh/bldmgr/circleci", SyncOptions{Pages: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := make([]int, 0)
+	for _, p := range result.Pipelines {
+		numbers = append(numbers, p.Number)
+	}
+	if len(numbers) != 4 || numbers[0] != 9 || numbers[3] != 6 {
+		t.Errorf("synced pipelines %v, want [9 8 7 6]", numbers)
+	}
+	if n := ci.requested("api/v2/project/gh/bldmgr/circleci/pipeline?page-token=t4"); n != 0 {
+		t.Errorf("listed past the last synced pipeline")
+	}
+	if last, _ := store.LastPipelineNumber("gh/bldmgr/circleci"); last != 9 {
+		t.Errorf("LastPipelineNumber = %d, want 9", last)
+	}
+}
+
+func TestSyncProjectFirstSyncStopsAtPages(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/pipeline":               `{"items":[{"id":"p9","number":9}],"next_page_token":"t2"}`,
+		"api/v2/project/gh/bldmgr/circleci/pipeline?page-token=t2": `{"items":[{"id":"p8","number":8}],"next_page_token":"t3"}`,
+	}}
+
+	result, err := SyncProject(context.Background(), ci, NewMemoryStore(), "gh/bldmgr/circleci", SyncOptions{Pages: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Pipelines) != 1 || result.Pipelines[0].Number != 9 {
+		t.Errorf("synced %+v, want only pipeline 9", result.Pipelines)
+	}
+}
+
+func TestSyncProjectRecordsStatusOnlyOnceLogsAreStored(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/pipeline": `{"items":[{"id":"p1","number":1,"state":"created","project_slug":"gh/bldmgr/circleci"}]}`,
+		"api/v2/pipeline/p1/workflow":                `{"items":[{"id":"w1","status":"success"}]}`,
+		"api/v2/workflow/w1/job":                     `{"items":[{"id":"j1","job_number":7,"status":"success","project_slug":"gh/bldmgr/circleci"}]}`,
+	}}
+	store := NewMemoryStore()
+	opts := SyncOptions{FetchLogs: true}
+
+	// the build details are missing, nothing may be recorded as done
+	result, err := SyncProject(context.Background(), ci, store, "gh/bldmgr/circleci", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 1 {
+		t.Errorf("errors = %+v, want the failed build details", result.Errors)
+	}
+	if _, seen, _ := store.Status(StatusJob, "j1"); seen {
+		t.Errorf("job status recorded without its logs")
+	}
+	if _, seen, _ := store.Status(StatusWorkflow, "w1"); seen {
+		t.Errorf("workflow status recorded without the logs of its jobs")
+	}
+	if unfinished, _ := store.UnfinishedPipelines("gh/bldmgr/circleci"); len(unfinished) != 1 {
+		t.Errorf("UnfinishedPipelines = %v, want [p1]", unfinished)
+	}
+
+	ci.routes["api/v1.1/project/gh/bldmgr/circleci/7"] = `{"steps":[{"name":"test","actions":[{"index":0,"step":101,"has_output":true}]}]}`
+	ci.routes["api/v2/pipeline/p1"] = `{"id":"p1","number":1,"state":"created","project_slug":"gh/bldmgr/circleci"}`
+	ci.routes["api/v1.1/project/gh/bldmgr/circleci/7/output/101/0"] = "ok\n"
+
+	result, err = SyncProject(context.Background(), ci, store, "gh/bldmgr/circleci", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 0 || result.Logs != 1 {
+		t.Errorf("second sync stored %d logs with errors %+v, want 1 log", result.Logs, result.Errors)
+	}
+	if status, _, _ := store.Status(StatusWorkflow, "w1"); status != "success" {
+		t.Errorf("workflow status = %q, want success", status)
+	}
+	if unfinished, _ := store.UnfinishedPipelines("gh/bldmgr/circleci"); len(unfinished) != 0 {
+		t.Errorf("UnfinishedPipelines = %v, want none", unfinished)
+	}
+}
+
+func TestSyncProjectPipelineWithoutWorkflows(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/project/gh/bldmgr/circleci/pipeline": `{"items":[{"id":"p2","number":2,"state":"created"},{"id":"p1","number":1,"state":"errored"}]}`,
+		"api/v2/pipeline/p1/workflow":                `{"items":[]}`,
+		"api/v2/pipeline/p2/workflow":                `{"items":[]}`,
+	}}
+	store := NewMemoryStore()
+
+	if _, err := SyncProject(context.Background(), ci, store, "gh/bldmgr/circleci", SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	unfinished, _ := store.UnfinishedPipelines("gh/bldmgr/circleci")
+	if len(unfinished) != 1 || unfinished[0] != "p2" {
+		t.Errorf("UnfinishedPipelines = %v, want only the created pipeline p2", unfinished)
+	}
+}
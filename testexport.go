@@ -0,0 +1,277 @@
+package circleci
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// junitTestSuites is the JUnit XML layout most dashboards accept: one suite per classname
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr,omitempty"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string       `xml:"name,attr"`
+	Classname string       `xml:"classname,attr"`
+	File      string       `xml:"file,attr,omitempty"`
+	Time      string       `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure"`
+	Error     *junitResult `xml:"error"`
+	Skipped   *junitResult `xml:"skipped"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+// WriteJUnit writes tests as JUnit XML with one testsuite per classname, or per file for tests without
+// a classname, in the order the groups first appear. Message becomes the failure text. Source is not exported.
+func WriteJUnit(w io.Writer, name string, tests []TestMetadata) error {
+	out := junitTestSuites{Name: name, Suites: make([]junitTestSuite, 0)}
+	index := make(map[string]int)
+	total := 0.0
+
+	for _, t := range tests {
+		group := t.Classname
+		if group == "" {
+			group = t.File
+		}
+		i, ok := index[group]
+		if !ok {
+			i = len(out.Suites)
+			index[group] = i
+			out.Suites = append(out.Suites, junitTestSuite{Name: group, Time: "0"})
+		}
+		suite := &out.Suites[i]
+
+		c := junitTestCase{Name: t.Name, Classname: t.Classname, File: t.File, Time: formatSeconds(t.RunTime)}
+		result := &junitResult{Message: firstLine(t.Message), Text: t.Message}
+		switch t.Result {
+		case TestFailure:
+			c.Failure = result
+			suite.Failures++
+		case TestError:
+			c.Error = result
+			suite.Errors++
+		case TestSkipped:
+			c.Skipped = result
+			suite.Skipped++
+		}
+		suite.Tests++
+		suiteTime, _ := strconv.ParseFloat(suite.Time, 64)
+		suite.Time = formatSeconds(suiteTime + t.RunTime)
+		suite.Cases = append(suite.Cases, c)
+
+		out.Tests++
+		total += t.RunTime
+	}
+	for _, suite := range out.Suites {
+		out.Failures += suite.Failures
+		out.Errors += suite.Errors
+		out.Skipped += suite.Skipped
+	}
+	out.Time = formatSeconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// ParseJUnit reads JUnit XML with either a testsuites or a single testsuite root back into TestMetadata
+func ParseJUnit(r io.Reader) ([]TestMetadata, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		var suite junitTestSuite
+		if err2 := xml.Unmarshal(data, &suite); err2 != nil {
+			return nil, err
+		}
+		suites.Suites = []junitTestSuite{suite}
+	}
+
+	tests := make([]TestMetadata, 0)
+	for _, suite := range suites.Suites {
+		for _, c := range suite.Cases {
+			t := TestMetadata{Classname: c.Classname, File: c.File, Name: c.Name, Result: TestSuccess}
+			t.RunTime, _ = strconv.ParseFloat(c.Time, 64)
+			var result *junitResult
+			switch {
+			case c.Failure != nil:
+				t.Result, result = TestFailure, c.Failure
+			case c.Error != nil:
+				t.Result, result = TestError, c.Error
+			case c.Skipped != nil:
+				t.Result, result = TestSkipped, c.Skipped
+			}
+			if result != nil {
+				t.Message = result.Text
+				if t.Message == "" {
+					t.Message = result.Message
+				}
+			}
+			tests = append(tests, t)
+		}
+	}
+
+	return tests, nil
+}
+
+// tapDiagnostic is the YAML block written after every TAP test line
+type tapDiagnostic struct {
+	Classname string  `yaml:"classname,omitempty"`
+	File      string  `yaml:"file,omitempty"`
+	Name      string  `yaml:"name"`
+	Result    string  `yaml:"result"`
+	RunTime   float64 `yaml:"run_time"`
+	Message   string  `yaml:"message,omitempty"`
+}
+
+// WriteTAP writes tests as TAP version 13. Every test line is followed by a YAML diagnostic block
+// holding its fields so ParseTAP can read them back, skipped tests carry the SKIP directive.
+func WriteTAP(w io.Writer, tests []TestMetadata) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "TAP version 13\n1..%d\n", len(tests))
+
+	for i, t := range tests {
+		status := "ok"
+		if t.Failed() {
+			status = "not ok"
+		}
+		fmt.Fprintf(bw, "%s %d - %s", status, i+1, tapEscape(testLabel(t)))
+		if t.Skipped() {
+			bw.WriteString(" # SKIP")
+			if t.Message != "" {
+				bw.WriteString(" " + tapEscape(firstLine(t.Message)))
+			}
+		}
+		bw.WriteByte('\n')
+
+		block, err := yaml.Marshal(tapDiagnostic{
+			Classname: t.Classname,
+			File:      t.File,
+			Name:      t.Name,
+			Result:    t.Result,
+			RunTime:   t.RunTime,
+			Message:   t.Message,
+		})
+		if err != nil {
+			return err
+		}
+		bw.WriteString("  ---\n")
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(string(block), "\n"), "\n") {
+			bw.WriteString("  " + line)
+		}
+		bw.WriteString("\n  ...\n")
+	}
+
+	return bw.Flush()
+}
+
+// tapEscape keeps a description on one line and escapes the directive separator
+func tapEscape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "#", "\\#")
+
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+var tapTestLine = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*-?\s*(.*)$`)
+
+// ParseTAP reads TAP back into TestMetadata. Diagnostic blocks written by WriteTAP restore every field,
+// plain TAP producers give tests named after their description.
+func ParseTAP(r io.Reader) ([]TestMetadata, error) {
+	tests := make([]TestMetadata, 0)
+	var block []string
+	inBlock := false
+
+	err := ForEachLine(r, func(_ int, line string) bool {
+		trimmed := strings.TrimSpace(line)
+		if inBlock {
+			if trimmed == "..." {
+				inBlock = false
+				var d tapDiagnostic
+				if yaml.Unmarshal([]byte(strings.Join(block, "\n")), &d) == nil && len(tests) > 0 {
+					t := &tests[len(tests)-1]
+					if d.Name != "" {
+						t.Classname, t.File, t.Name = d.Classname, d.File, d.Name
+					}
+					if d.Result != "" {
+						t.Result = d.Result
+					}
+					t.RunTime = d.RunTime
+					t.Message = d.Message
+				}
+				return true
+			}
+			block = append(block, strings.TrimPrefix(line, "  "))
+			return true
+		}
+		if trimmed == "---" && len(tests) > 0 {
+			inBlock = true
+			block = block[:0]
+			return true
+		}
+
+		m := tapTestLine.FindStringSubmatch(line)
+		if m == nil {
+			return true
+		}
+		t := TestMetadata{Name: m[3], Result: TestSuccess}
+		if desc, directive, ok := strings.Cut(m[3], " # "); ok {
+			t.Name = desc
+			if strings.HasPrefix(strings.ToUpper(directive), "SKIP") {
+				t.Result = TestSkipped
+				t.Message = strings.TrimSpace(directive[4:])
+			}
+		}
+		if m[1] == "not ok" && t.Result != TestSkipped {
+			t.Result = TestFailure
+		}
+		t.Name = strings.ReplaceAll(strings.ReplaceAll(t.Name, "\\#", "#"), "\\\\", "\\")
+		tests = append(tests, t)
+		return true
+	})
+
+	return tests, err
+}
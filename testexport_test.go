@@ -0,0 +1,83 @@
+package circleci
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadTestMetadata(t *testing.T) []TestMetadata {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "tests", "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page listTestMetadata
+	if err := json.Unmarshal(b, &page); err != nil {
+		t.Fatal(err)
+	}
+
+	return page.Items
+}
+
+// withoutSource returns tests as the exports read them back, they do not carry the source
+func withoutSource(tests []TestMetadata) []TestMetadata {
+	out := make([]TestMetadata, 0, len(tests))
+	for _, t := range tests {
+		t.Source = ""
+		out = append(out, t)
+	}
+
+	return out
+}
+
+func TestWriteJUnit(t *testing.T) {
+	tests := loadTestMetadata(t)
+	want, err := os.ReadFile(filepath.Join("testdata", "tests", "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, "circleci", tests); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("WriteJUnit output differs from junit.xml:\n%s", buf.String())
+	}
+
+	got, err := ParseJUnit(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, withoutSource(tests)) {
+		t.Errorf("ParseJUnit = %+v, want %+v", got, withoutSource(tests))
+	}
+}
+
+func TestWriteTAP(t *testing.T) {
+	tests := loadTestMetadata(t)
+	want, err := os.ReadFile(filepath.Join("testdata", "tests", "tap.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTAP(&buf, tests); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("WriteTAP output differs from tap.txt:\n%s", buf.String())
+	}
+
+	got, err := ParseTAP(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, withoutSource(tests)) {
+		t.Errorf("ParseTAP = %+v, want %+v", got, withoutSource(tests))
+	}
+}
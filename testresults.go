@@ -0,0 +1,307 @@
+package circleci
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TestSuccess = "success"
+	TestFailure = "failure"
+	TestError   = "error"
+	TestSkipped = "skipped"
+)
+
+// Key identifies a test across jobs by its file, classname and name
+func (t TestMetadata) Key() string {
+	return t.File + "|" + t.Classname + "|" + t.Name
+}
+
+// Failed reports whether the test failed or errored
+func (t TestMetadata) Failed() bool {
+	return t.Result == TestFailure || t.Result == TestError
+}
+
+// Skipped reports whether the test was skipped
+func (t TestMetadata) Skipped() bool {
+	return t.Result == TestSkipped
+}
+
+// Duration returns RunTime, which the API reports in seconds
+func (t TestMetadata) Duration() time.Duration {
+	return time.Duration(t.RunTime * float64(time.Second))
+}
+
+// TestGroup aggregates the results of the tests of one classname or file
+type TestGroup struct {
+	Name    string  `json:"name"`
+	Tests   int     `json:"tests"`
+	Passed  int     `json:"passed"`
+	Failed  int     `json:"failed"`
+	Skipped int     `json:"skipped"`
+	RunTime float64 `json:"run_time"`
+}
+
+func (g *TestGroup) add(t TestMetadata) {
+	g.Tests++
+	g.RunTime += t.RunTime
+	switch {
+	case t.Failed():
+		g.Failed++
+	case t.Skipped():
+		g.Skipped++
+	default:
+		g.Passed++
+	}
+}
+
+// TestSummary aggregates the test results of a job
+type TestSummary struct {
+	Job      JobRef         `json:"job"`
+	Total    TestGroup      `json:"total"`
+	ByClass  []TestGroup    `json:"by_class"`
+	ByFile   []TestGroup    `json:"by_file"`
+	Slowest  []TestMetadata `json:"slowest"`
+	Failures []TestMetadata `json:"failures"`
+}
+
+// SummarizeTests counts passed, failed and skipped tests and their runtime per classname and per file,
+// keeping the slowest n tests. Groups are ordered by name.
+func SummarizeTests(tests []TestMetadata, slowest int) TestSummary {
+	summary := TestSummary{
+		Total:    TestGroup{Name: "total"},
+		Failures: make([]TestMetadata, 0),
+	}
+
+	classes := make(map[string]*TestGroup)
+	files := make(map[string]*TestGroup)
+	for _, t := range tests {
+		summary.Total.add(t)
+		testGroup(classes, t.Classname).add(t)
+		if t.File != "" {
+			testGroup(files, t.File).add(t)
+		}
+		if t.Failed() {
+			summary.Failures = append(summary.Failures, t)
+		}
+	}
+	summary.ByClass = testGroups(classes)
+	summary.ByFile = testGroups(files)
+	summary.Slowest = SlowestTests(tests, slowest)
+
+	return summary
+}
+
+func testGroup(groups map[string]*TestGroup, name string) *TestGroup {
+	g, ok := groups[name]
+	if !ok {
+		g = &TestGroup{Name: name}
+		groups[name] = g
+	}
+
+	return g
+}
+
+func testGroups(groups map[string]*TestGroup) []TestGroup {
+	out := make([]TestGroup, 0, len(groups))
+	for _, name := range sortedKeys(groups) {
+		out = append(out, *groups[name])
+	}
+
+	return out
+}
+
+// SlowestTests returns the n tests with the longest run time, slowest first
+func SlowestTests(tests []TestMetadata, n int) []TestMetadata {
+	sorted := append([]TestMetadata(nil), tests...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RunTime > sorted[j].RunTime
+	})
+	if n >= 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	if sorted == nil {
+		sorted = make([]TestMetadata, 0)
+	}
+
+	return sorted
+}
+
+// TestComparison lists how the results of a head job differ from a base job
+type TestComparison struct {
+	Base         JobRef         `json:"base"`
+	Head         JobRef         `json:"head"`
+	NewlyFailing []TestMetadata `json:"newly_failing"`
+	NewlyFixed   []TestMetadata `json:"newly_fixed"`
+	StillFailing []TestMetadata `json:"still_failing"`
+	Added        []TestMetadata `json:"added"`
+	Removed      []TestMetadata `json:"removed"`
+}
+
+// CompareTests compares two sets of results by Key. A test reported more than once counts as failed
+// when any of its results failed. Tests that are new and fail are listed as both added and newly failing.
+func CompareTests(base []TestMetadata, head []TestMetadata) TestComparison {
+	c := TestComparison{
+		NewlyFailing: make([]TestMetadata, 0),
+		NewlyFixed:   make([]TestMetadata, 0),
+		StillFailing: make([]TestMetadata, 0),
+		Added:        make([]TestMetadata, 0),
+		Removed:      make([]TestMetadata, 0),
+	}
+
+	a, b := testResults(base), testResults(head)
+	for _, key := range unionKeys(a, b) {
+		before, inA := a[key]
+		after, inB := b[key]
+		switch {
+		case !inA:
+			c.Added = append(c.Added, after)
+			if after.Failed() {
+				c.NewlyFailing = append(c.NewlyFailing, after)
+			}
+		case !inB:
+			c.Removed = append(c.Removed, before)
+		case !before.Failed() && after.Failed():
+			c.NewlyFailing = append(c.NewlyFailing, after)
+		case before.Failed() && !after.Failed() && !after.Skipped():
+			c.NewlyFixed = append(c.NewlyFixed, after)
+		case before.Failed() && after.Failed():
+			c.StillFailing = append(c.StillFailing, after)
+		}
+	}
+
+	return c
+}
+
+// testResults indexes tests by Key, a failed result wins over any other
+func testResults(tests []TestMetadata) map[string]TestMetadata {
+	results := make(map[string]TestMetadata)
+	for _, t := range tests {
+		if prev, ok := results[t.Key()]; ok && prev.Failed() {
+			continue
+		}
+		results[t.Key()] = t
+	}
+
+	return results
+}
+
+// GetTestMetadataRef returns every test result of the job identified by job
+func GetTestMetadataRef(ci CI, job JobRef) []TestMetadata {
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	return GetTestMetadata(ci, strconv.Itoa(job.JobNumber), vcs, namespace, project, "none", 1)
+}
+
+// fetchTestMetadata returns every test result of a job, unlike GetTestMetadata a failed request is reported
+func fetchTestMetadata(ctx context.Context, ci CI, job JobRef) ([]TestMetadata, error) {
+	project, vcs, namespace := formatProjectSlug(job.ProjectSlug)
+	items := make([]TestMetadata, 0)
+	pageToken := ""
+	for {
+		var page listTestMetadata
+		url := fmt.Sprintf(restGetTestMetadata, vcs, namespace, project, strconv.Itoa(job.JobNumber))
+		if pageToken != "" {
+			url = withPageToken(url, pageToken)
+		}
+		if err := getPage(ctx, ci, url, &page); err != nil {
+			return items, fmt.Errorf("tests of job %d: %w", job.JobNumber, err)
+		}
+		items = append(items, page.Items...)
+		if page.ContinuationToken == "" {
+			return items, nil
+		}
+		pageToken = page.ContinuationToken
+	}
+}
+
+// GetTestSummary summarizes the test results of a job
+func GetTestSummary(ci CI, job JobRef, slowest int) TestSummary {
+	summary := SummarizeTests(GetTestMetadataRef(ci, job), slowest)
+	summary.Job = job
+
+	return summary
+}
+
+// CompareJobTests compares the test results of two jobs, typically the same job on the base branch and on a PR
+func CompareJobTests(ci CI, base JobRef, head JobRef) TestComparison {
+	c := CompareTests(GetTestMetadataRef(ci, base), GetTestMetadataRef(ci, head))
+	c.Base = base
+	c.Head = head
+
+	return c
+}
+
+// Markdown renders the summary as a PR comment
+func (s TestSummary) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%d tests**: %d passed, %d failed, %d skipped in %s\n",
+		s.Total.Tests, s.Total.Passed, s.Total.Failed, s.Total.Skipped, s.Total.duration())
+
+	if len(s.Failures) > 0 {
+		sb.WriteString("\n#### Failures\n\n")
+		for _, t := range s.Failures {
+			writeTestLine(&sb, t, true)
+		}
+	}
+
+	if len(s.Slowest) > 0 {
+		sb.WriteString("\n#### Slowest tests\n\n| Test | Time |\n| --- | --- |\n")
+		for _, t := range s.Slowest {
+			fmt.Fprintf(&sb, "| `%s` | %s |\n", testLabel(t), t.Duration().Round(time.Millisecond))
+		}
+	}
+
+	return sb.String()
+}
+
+// Markdown renders the comparison as a PR comment
+func (c TestComparison) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%d newly failing**, %d newly fixed, %d still failing, %d added, %d removed\n",
+		len(c.NewlyFailing), len(c.NewlyFixed), len(c.StillFailing), len(c.Added), len(c.Removed))
+
+	sections := []struct {
+		title   string
+		tests   []TestMetadata
+		message bool
+	}{
+		{"Newly failing", c.NewlyFailing, true},
+		{"Newly fixed", c.NewlyFixed, false},
+		{"Still failing", c.StillFailing, false},
+	}
+	for _, section := range sections {
+		if len(section.tests) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n#### %s\n\n", section.title)
+		for _, t := range section.tests {
+			writeTestLine(&sb, t, section.message)
+		}
+	}
+
+	return sb.String()
+}
+
+func (g TestGroup) duration() time.Duration {
+	return time.Duration(g.RunTime * float64(time.Second)).Round(time.Millisecond)
+}
+
+func testLabel(t TestMetadata) string {
+	if t.Classname == "" {
+		return t.Name
+	}
+
+	return t.Classname + " " + t.Name
+}
+
+func writeTestLine(sb *strings.Builder, t TestMetadata, message bool) {
+	fmt.Fprintf(sb, "- `%s`", testLabel(t))
+	if message && t.Message != "" {
+		fmt.Fprintf(sb, ": %s", firstLine(t.Message))
+	}
+	sb.WriteByte('\n')
+}
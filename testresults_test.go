@@ -0,0 +1,150 @@
+package circleci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeTests(t *testing.T) {
+	summary := SummarizeTests(loadTestMetadata(t), 2)
+
+	if g := summary.Total; g.Tests != 5 || g.Passed != 2 || g.Failed != 2 || g.Skipped != 1 {
+		t.Errorf("Total = %+v, want 5 tests: 2 passed, 2 failed, 1 skipped", g)
+	}
+	if d := summary.Total.duration().String(); d != "2.512s" {
+		t.Errorf("total run time = %s, want 2.512s", d)
+	}
+
+	groups := func(gs []TestGroup) []string {
+		out := make([]string, len(gs))
+		for i, g := range gs {
+			out[i] = g.Name
+		}
+		return out
+	}
+	if got, want := groups(summary.ByClass), []string{"", "github.com/bldmgr/circleci", "github.com/bldmgr/circleci/pkg/logs"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByClass = %v, want %v", got, want)
+	}
+	if g := summary.ByClass[2]; g.Tests != 2 || g.Failed != 1 || g.Skipped != 1 || g.Passed != 0 {
+		t.Errorf("pkg/logs group = %+v, want one failure and one skip", g)
+	}
+	if got, want := groups(summary.ByFile), []string{"ansi_test.go", "pipeline_test.go", "spec/models/user_spec.rb", "spinup_test.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ByFile = %v, want %v", got, want)
+	}
+
+	names := func(tests []TestMetadata) []string {
+		out := make([]string, len(tests))
+		for i, t := range tests {
+			out[i] = t.Name
+		}
+		return out
+	}
+	if got, want := names(summary.Slowest), []string{"TestProcessJobs", "User validates email <a@b>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Slowest = %v, want %v", got, want)
+	}
+	if got, want := names(summary.Failures), []string{"TestProcessJobs", "TestToHTML"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Failures = %v, want %v", got, want)
+	}
+
+	empty := SummarizeTests(nil, 3)
+	if empty.Total.Tests != 0 || empty.Slowest == nil || empty.Failures == nil || len(empty.ByClass) != 0 {
+		t.Errorf("summary of no tests = %+v", empty)
+	}
+}
+
+func TestSummaryMarkdown(t *testing.T) {
+	want := "**5 tests**: 2 passed, 2 failed, 1 skipped in 2.512s\n" +
+		"\n#### Failures\n\n" +
+		"- `github.com/bldmgr/circleci TestProcessJobs`: pipeline_test.go:88: expected 4 steps, got 3 ...\n" +
+		"- `github.com/bldmgr/circleci/pkg/logs TestToHTML`: panic: runtime error: index out of range [3] with length 3\n" +
+		"\n#### Slowest tests\n\n| Test | Time |\n| --- | --- |\n" +
+		"| `github.com/bldmgr/circleci TestProcessJobs` | 1.5s |\n" +
+		"| `User validates email <a@b>` | 750ms |\n"
+
+	if got := SummarizeTests(loadTestMetadata(t), 2).Markdown(); got != want {
+		t.Errorf("Markdown =\n%s\nwant\n%s", got, want)
+	}
+	if got := SummarizeTests(nil, 0).Markdown(); got != "**0 tests**: 0 passed, 0 failed, 0 skipped in 0s\n" {
+		t.Errorf("Markdown of no tests = %q", got)
+	}
+}
+
+func TestCompareTests(t *testing.T) {
+	result := func(results ...string) []TestMetadata {
+		tests := make([]TestMetadata, 0, len(results))
+		for _, r := range results {
+			tests = append(tests, TestMetadata{Classname: "pkg", Name: "TestA", Result: r})
+		}
+		return tests
+	}
+
+	tests := []struct {
+		name string
+		base []TestMetadata
+		head []TestMetadata
+		want string
+	}{
+		{"still passing", result(TestSuccess), result(TestSuccess), ""},
+		{"newly failing", result(TestSuccess), result(TestFailure), "newly_failing"},
+		{"newly erroring", result(TestSuccess), result(TestError), "newly_failing"},
+		{"skipped then failing", result(TestSkipped), result(TestFailure), "newly_failing"},
+		{"fixed", result(TestFailure), result(TestSuccess), "newly_fixed"},
+		{"failing then skipped", result(TestFailure), result(TestSkipped), ""},
+		{"still failing", result(TestError), result(TestFailure), "still_failing"},
+		{"added", nil, result(TestSuccess), "added"},
+		{"added failing", nil, result(TestFailure), "added newly_failing"},
+		{"removed", result(TestFailure), nil, "removed"},
+		{"retried and failed once", result(TestSuccess), result(TestSuccess, TestFailure), "newly_failing"},
+		{"failed once before a retry", result(TestFailure, TestSuccess), result(TestSuccess), "newly_fixed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CompareTests(tt.base, tt.head)
+			got := ""
+			for _, list := range []struct {
+				name  string
+				tests []TestMetadata
+			}{
+				{"added", c.Added}, {"removed", c.Removed},
+				{"newly_failing", c.NewlyFailing}, {"newly_fixed", c.NewlyFixed}, {"still_failing", c.StillFailing},
+			} {
+				if len(list.tests) > 1 {
+					t.Errorf("%s lists the test %d times", list.name, len(list.tests))
+				}
+				if len(list.tests) > 0 {
+					if got != "" {
+						got += " "
+					}
+					got += list.name
+				}
+			}
+			if got != tt.want {
+				t.Errorf("classified as %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComparisonMarkdown(t *testing.T) {
+	base := []TestMetadata{
+		{Classname: "pkg", Name: "TestA", Result: TestSuccess},
+		{Classname: "pkg", Name: "TestB", Result: TestFailure, Message: "old failure"},
+		{Classname: "pkg", Name: "TestC", Result: TestFailure},
+		{Classname: "pkg", Name: "TestD", Result: TestSuccess},
+	}
+	head := []TestMetadata{
+		{Classname: "pkg", Name: "TestA", Result: TestFailure, Message: "boom\nstack"},
+		{Classname: "pkg", Name: "TestB", Result: TestSuccess},
+		{Classname: "pkg", Name: "TestC", Result: TestFailure, Message: "still broken"},
+		{Name: "TestE", Result: TestError, Message: "panic"},
+	}
+
+	want := "**2 newly failing**, 1 newly fixed, 1 still failing, 1 added, 1 removed\n" +
+		"\n#### Newly failing\n\n- `pkg TestA`: boom ...\n- `TestE`: panic\n" +
+		"\n#### Newly fixed\n\n- `pkg TestB`\n" +
+		"\n#### Still failing\n\n- `pkg TestC`\n"
+	if got := CompareTests(base, head).Markdown(); got != want {
+		t.Errorf("Markdown =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+package circleci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SplitByFilename  = "filename"
+	SplitByClassname = "classname"
+	SplitByName      = "name"
+)
+
+// TimingEntry is the timing history of one file, classname or test. Times are in seconds like TestMetadata.RunTime.
+type TimingEntry struct {
+	Name    string  `json:"name"`
+	Samples int     `json:"samples"`
+	Total   float64 `json:"total"`
+	Last    float64 `json:"last"`
+}
+
+// Average returns the mean run time of the entry
+func (e TimingEntry) Average() float64 {
+	if e.Samples == 0 {
+		return 0
+	}
+
+	return e.Total / float64(e.Samples)
+}
+
+// TimingDB holds historical run times keyed the same way as `circleci tests split --split-by=timings --timings-type`
+type TimingDB struct {
+	By      string                  `json:"by"`
+	Runs    int                     `json:"runs"`
+	Entries map[string]*TimingEntry `json:"entries"`
+}
+
+// NewTimingDB returns an empty database keyed by SplitByFilename, SplitByClassname or SplitByName
+func NewTimingDB(by string) *TimingDB {
+	return &TimingDB{By: by, Entries: make(map[string]*TimingEntry)}
+}
+
+// key returns the name a test is timed under, tests without one are skipped
+func (db *TimingDB) key(t TestMetadata) string {
+	switch db.By {
+	case SplitByClassname:
+		return t.Classname
+	case SplitByName:
+		return testLabel(t)
+	}
+
+	return t.File
+}
+
+// Add records the results of one job run, summing the tests that share a key before averaging across runs
+func (db *TimingDB) Add(tests []TestMetadata) {
+	run := make(map[string]float64)
+	for _, t := range tests {
+		if k := db.key(t); k != "" && !t.Skipped() {
+			run[k] += t.RunTime
+		}
+	}
+	if len(run) == 0 {
+		return
+	}
+
+	db.Runs++
+	for k, v := range run {
+		e, ok := db.Entries[k]
+		if !ok {
+			e = &TimingEntry{Name: k}
+			db.Entries[k] = e
+		}
+		e.Samples++
+		e.Total += v
+		e.Last = v
+	}
+}
+
+// Estimate returns the expected run time of name, unknown names are given the average of all known entries
+func (db *TimingDB) Estimate(name string) (seconds float64, known bool) {
+	if e, ok := db.Entries[name]; ok {
+		return e.Average(), true
+	}
+	if len(db.Entries) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, k := range sortedKeys(db.Entries) {
+		total += db.Entries[k].Average()
+	}
+
+	return total / float64(len(db.Entries)), false
+}
+
+// Save writes the database to path as JSON
+func (db *TimingDB) Save(path string) error {
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadTimingDB reads a database written by Save
+func LoadTimingDB(path string) (*TimingDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := NewTimingDB(SplitByFilename)
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, err
+	}
+	if db.Entries == nil {
+		db.Entries = make(map[string]*TimingEntry)
+	}
+
+	return db, nil
+}
+
+// BuildTimingDB collects the test timings of every run of jobName in the last n pipelines of a project branch
+func BuildTimingDB(ctx context.Context, ci CI, projectSlug string, branch string, jobName string, n int, by string) (*TimingDB, error) {
+	db := NewTimingDB(by)
+
+	pipelines := GetProjectPipelines(ci, projectSlug, branch, "none", (n+19)/20)
+	if len(pipelines) > n {
+		pipelines = pipelines[:n]
+	}
+	for _, pipeline := range pipelines {
+		if err := ctx.Err(); err != nil {
+			return db, err
+		}
+		for _, workflow := range GetPipelineWorkflows(ci, pipeline.ID, "none") {
+			for _, job := range GetWorkflowJob(ci, workflow.ID, "none", "", "") {
+				if job.JobNumber == 0 || job.Name != jobName || job.Status != "success" {
+					continue
+				}
+				db.Add(GetTestMetadataRef(ci, job.Ref()))
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// SplitNode is the share of the tests one parallel node runs
+type SplitNode struct {
+	Index     int           `json:"index"`
+	Items     []string      `json:"items"`
+	Predicted time.Duration `json:"predicted"`
+}
+
+// TestSplit assigns tests to parallel nodes. Predicted is the duration of the slowest node.
+type TestSplit struct {
+	Nodes     []SplitNode   `json:"nodes"`
+	Predicted time.Duration `json:"predicted"`
+	Unknown   []string      `json:"unknown"`
+}
+
+// Split distributes items over parallelism nodes by timing, placing the slowest remaining item on the
+// least loaded node (longest processing time first), equally loaded nodes take the one with fewer items.
+// Items without history are listed in Unknown and placed with the database average, with an empty
+// database every item weighs nothing and they are spread evenly. Ties are broken by name so the split
+// is reproducible.
+func (db *TimingDB) Split(items []string, parallelism int) TestSplit {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	split := TestSplit{Nodes: make([]SplitNode, parallelism), Unknown: make([]string, 0)}
+	for i := range split.Nodes {
+		split.Nodes[i] = SplitNode{Index: i, Items: make([]string, 0)}
+	}
+
+	type timed struct {
+		name    string
+		seconds float64
+	}
+	sorted := make([]timed, 0, len(items))
+	for _, name := range items {
+		seconds, known := db.Estimate(name)
+		if !known {
+			split.Unknown = append(split.Unknown, name)
+		}
+		sorted = append(sorted, timed{name, seconds})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].seconds != sorted[j].seconds {
+			return sorted[i].seconds > sorted[j].seconds
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	load := make([]float64, parallelism)
+	for _, t := range sorted {
+		node := 0
+		for i := 1; i < parallelism; i++ {
+			if load[i] < load[node] || (load[i] == load[node] && len(split.Nodes[i].Items) < len(split.Nodes[node].Items)) {
+				node = i
+			}
+		}
+		load[node] += t.seconds
+		split.Nodes[node].Items = append(split.Nodes[node].Items, t.name)
+	}
+
+	for i := range split.Nodes {
+		split.Nodes[i].Predicted = time.Duration(load[i] * float64(time.Second))
+		if split.Nodes[i].Predicted > split.Predicted {
+			split.Predicted = split.Nodes[i].Predicted
+		}
+	}
+
+	return split
+}
+
+// SplitAll splits every entry of the database
+func (db *TimingDB) SplitAll(parallelism int) TestSplit {
+	return db.Split(sortedKeys(db.Entries), parallelism)
+}
+
+// String prints the predicted duration and the items of every node
+func (s TestSplit) String() string {
+	var sb strings.Builder
+	for _, node := range s.Nodes {
+		fmt.Fprintf(&sb, "node %d (%s): %s\n", node.Index, node.Predicted.Round(time.Millisecond), strings.Join(node.Items, " "))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,132 @@
+package circleci
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func timingDB(seconds map[string]float64) *TimingDB {
+	db := NewTimingDB(SplitByFilename)
+	tests := make([]TestMetadata, 0, len(seconds))
+	for file, s := range seconds {
+		tests = append(tests, TestMetadata{File: file, Name: "Test", RunTime: s})
+	}
+	db.Add(tests)
+
+	return db
+}
+
+func TestTimingDBAdd(t *testing.T) {
+	db := NewTimingDB(SplitByFilename)
+	db.Add([]TestMetadata{
+		{File: "a_test.go", Name: "TestOne", RunTime: 1},
+		{File: "a_test.go", Name: "TestTwo", RunTime: 2},
+		{File: "b_test.go", Name: "TestSkipped", RunTime: 9, Result: TestSkipped},
+		{Name: "TestWithoutFile", RunTime: 9},
+	})
+	db.Add([]TestMetadata{{File: "a_test.go", Name: "TestOne", RunTime: 5}})
+	db.Add([]TestMetadata{{File: "b_test.go", Result: TestSkipped}})
+
+	if db.Runs != 2 {
+		t.Errorf("Runs = %d, want runs without timings not counted", db.Runs)
+	}
+	if len(db.Entries) != 1 {
+		t.Errorf("entries = %v, want only a_test.go", sortedKeys(db.Entries))
+	}
+	if e := db.Entries["a_test.go"]; e.Samples != 2 || e.Average() != 4 || e.Last != 5 {
+		t.Errorf("a_test.go = %+v average %v, want the tests of a run summed before averaging", e, e.Average())
+	}
+
+	byName := NewTimingDB(SplitByName)
+	byName.Add([]TestMetadata{{Classname: "pkg", Name: "TestOne", RunTime: 1}, {Name: "TestTwo", RunTime: 2}})
+	if got := sortedKeys(byName.Entries); !reflect.DeepEqual(got, []string{"TestTwo", "pkg TestOne"}) {
+		t.Errorf("entries by name = %v", got)
+	}
+}
+
+func TestTimingDBEstimate(t *testing.T) {
+	if seconds, known := NewTimingDB(SplitByFilename).Estimate("a_test.go"); seconds != 0 || known {
+		t.Errorf("estimate from an empty database = %v, %t, want 0, false", seconds, known)
+	}
+
+	db := timingDB(map[string]float64{"a_test.go": 4, "b_test.go": 2})
+	if seconds, known := db.Estimate("a_test.go"); seconds != 4 || !known {
+		t.Errorf("estimate of a known file = %v, %t, want 4, true", seconds, known)
+	}
+	if seconds, known := db.Estimate("new_test.go"); seconds != 3 || known {
+		t.Errorf("estimate of an unknown file = %v, %t, want the average 3, false", seconds, known)
+	}
+}
+
+func TestTimingDBSplit(t *testing.T) {
+	nodeItems := func(s TestSplit) [][]string {
+		out := make([][]string, len(s.Nodes))
+		for i, n := range s.Nodes {
+			out[i] = n.Items
+		}
+		return out
+	}
+
+	t.Run("empty database", func(t *testing.T) {
+		split := NewTimingDB(SplitByFilename).Split([]string{"a", "b", "c", "d", "e"}, 2)
+		if got, want := nodeItems(split), [][]string{{"a", "c", "e"}, {"b", "d"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("nodes = %v, want items spread evenly", got)
+		}
+		if len(split.Unknown) != 5 || split.Predicted != 0 {
+			t.Errorf("unknown = %v, predicted %v", split.Unknown, split.Predicted)
+		}
+	})
+
+	t.Run("balanced", func(t *testing.T) {
+		db := timingDB(map[string]float64{"a": 5, "b": 4, "c": 3, "d": 3, "e": 2, "f": 1})
+		split := db.SplitAll(2)
+		if got, want := nodeItems(split), [][]string{{"a", "d", "f"}, {"b", "c", "e"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("nodes = %v, want %v", got, want)
+		}
+		for _, n := range split.Nodes {
+			if n.Predicted != 9*time.Second {
+				t.Errorf("node %d predicted %v, want 9s", n.Index, n.Predicted)
+			}
+		}
+		if split.Predicted != 9*time.Second || len(split.Unknown) != 0 {
+			t.Errorf("predicted %v, unknown %v", split.Predicted, split.Unknown)
+		}
+	})
+
+	t.Run("unknown items weigh the average", func(t *testing.T) {
+		db := timingDB(map[string]float64{"a": 4, "b": 2})
+		split := db.Split([]string{"a", "b", "new"}, 2)
+		if got, want := nodeItems(split), [][]string{{"a"}, {"new", "b"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("nodes = %v, want %v", got, want)
+		}
+		if !reflect.DeepEqual(split.Unknown, []string{"new"}) || split.Predicted != 5*time.Second {
+			t.Errorf("unknown = %v, predicted %v, want [new] and 5s", split.Unknown, split.Predicted)
+		}
+	})
+
+	t.Run("parallelism below one", func(t *testing.T) {
+		split := timingDB(map[string]float64{"a": 1}).Split([]string{"a", "b"}, 0)
+		if len(split.Nodes) != 1 || len(split.Nodes[0].Items) != 2 {
+			t.Errorf("nodes = %v, want a single node", nodeItems(split))
+		}
+	})
+}
+
+func TestTimingDBSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timings.json")
+	db := timingDB(map[string]float64{"a_test.go": 1.5})
+	db.By = SplitByClassname
+	if err := db.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadTimingDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, db) {
+		t.Errorf("loaded %+v, want %+v", loaded, db)
+	}
+}
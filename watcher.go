@@ -0,0 +1,360 @@
+package circleci
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	EventPipelineCreated       = "PipelineCreated"
+	EventWorkflowStatusChanged = "WorkflowStatusChanged"
+	EventJobStatusChanged      = "JobStatusChanged"
+	EventJobFailed             = "JobFailed"
+	EventApprovalPending       = "ApprovalPending"
+	EventStoreFailed           = "StoreFailed"
+)
+
+// WatchEvent is delivered by a Watcher, switch on the concrete type or on Kind
+type WatchEvent interface {
+	Kind() string
+}
+
+// PipelineCreated is sent for every pipeline that appears after the watcher started
+type PipelineCreated struct {
+	Pipeline PipelineItem
+}
+
+// WorkflowStatusChanged is sent when a workflow appears or its status changes. Previous is empty for new workflows.
+type WorkflowStatusChanged struct {
+	Pipeline PipelineItem
+	Workflow PipelineWorkflows
+	Previous string
+}
+
+// JobStatusChanged is sent when a job appears or its status changes. Previous is empty for new jobs.
+type JobStatusChanged struct {
+	Pipeline PipelineItem
+	Workflow PipelineWorkflows
+	Job      WorkflowItem
+	Previous string
+}
+
+// JobFailed is sent once when a job reaches the failed status
+type JobFailed struct {
+	Pipeline PipelineItem
+	Workflow PipelineWorkflows
+	Job      WorkflowItem
+}
+
+// ApprovalPending is sent once when an approval job starts waiting
+type ApprovalPending struct {
+	Pipeline PipelineItem
+	Workflow PipelineWorkflows
+	Job      WorkflowItem
+}
+
+// StoreFailed is sent when the Store could not record what the watcher saw, the watcher keeps polling
+// but a restarted watcher may report those changes again
+type StoreFailed struct {
+	Err error
+}
+
+func (PipelineCreated) Kind() string       { return EventPipelineCreated }
+func (WorkflowStatusChanged) Kind() string { return EventWorkflowStatusChanged }
+func (JobStatusChanged) Kind() string      { return EventJobStatusChanged }
+func (JobFailed) Kind() string             { return EventJobFailed }
+func (ApprovalPending) Kind() string       { return EventApprovalPending }
+func (StoreFailed) Kind() string           { return EventStoreFailed }
+
+// Watcher polls an org or a project for pipelines and reports their state transitions. The first poll
+// only records the current state, events are sent for what changes afterwards. The poll interval starts
+// at MinInterval, doubles up to MaxInterval while nothing changes and falls back once something does.
+// A pipeline is followed until all of its workflows have finished. With a Store the watcher also reports
+// what changed while it was not running: pipelines newer than the stored pipeline number and workflows or
+// jobs whose status differs from the stored one. A caching client revalidates every response of the
+// watcher so polls never see a status older than the poll.
+type Watcher struct {
+	Org         string
+	ProjectSlug string
+	Branch      string
+	Pages       int
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	Store       Store
+
+	ci        CI
+	pipelines map[string]*watchedPipeline
+	events    chan WatchEvent
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+type watchedPipeline struct {
+	item      PipelineItem
+	workflows map[string]string
+	jobs      map[string]string
+	finished  bool
+	// replay is set for pipelines created while the watcher was not running
+	replay bool
+}
+
+// NewWatcher returns a watcher for every pipeline of an org
+func NewWatcher(ci CI, org string) *Watcher {
+	return &Watcher{
+		Org:         org,
+		Pages:       1,
+		MinInterval: 10 * time.Second,
+		MaxInterval: 2 * time.Minute,
+		ci:          revalidating(ci),
+	}
+}
+
+// NewProjectWatcher returns a watcher for the pipelines of one project, an empty branch watches every branch
+func NewProjectWatcher(ci CI, projectSlug string, branch string) *Watcher {
+	w := NewWatcher(ci, "")
+	w.ProjectSlug = projectSlug
+	w.Branch = branch
+
+	return w
+}
+
+// Start begins polling and returns the event channel, which is closed once the watcher stops.
+// The watcher stops when ctx is done or Stop is called, a stopped watcher can be started again.
+func (w *Watcher) Start(ctx context.Context) <-chan WatchEvent {
+	w.pipelines = make(map[string]*watchedPipeline)
+	w.events = make(chan WatchEvent)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	w.stopOnce = sync.Once{}
+
+	go w.loop(ctx)
+
+	return w.events
+}
+
+// Stop ends polling after the current poll and waits until the event channel is closed.
+// Events not yet received when Stop is called are dropped.
+func (w *Watcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *Watcher) loop(ctx context.Context) {
+	defer close(w.done)
+	defer close(w.events)
+
+	if w.MinInterval <= 0 {
+		w.MinInterval = 10 * time.Second
+	}
+	if w.MaxInterval < w.MinInterval {
+		w.MaxInterval = w.MinInterval
+	}
+
+	interval := w.MinInterval
+	w.poll(ctx, false)
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if w.poll(ctx, true) {
+			interval = w.MinInterval
+			continue
+		}
+		interval *= 2
+		if interval > w.MaxInterval {
+			interval = w.MaxInterval
+		}
+	}
+}
+
+// poll fetches the latest pipelines and the workflows and jobs of unfinished ones. It reports whether
+// anything changed or is still running, in which case the next poll comes sooner.
+func (w *Watcher) poll(ctx context.Context, emit bool) (active bool) {
+	var items []PipelineItem
+	if w.ProjectSlug != "" {
+		items = GetProjectPipelines(w.ci, w.ProjectSlug, w.Branch, "none", w.Pages)
+	} else {
+		items = GetPipeline(w.ci, w.Org, "none", w.Pages)
+	}
+
+	listed := make(map[string]bool)
+	numbers := make(map[string]int)
+	for _, item := range items {
+		listed[item.ID] = true
+		if item.Number > numbers[item.ProjectSlug] {
+			numbers[item.ProjectSlug] = item.Number
+		}
+		p, ok := w.pipelines[item.ID]
+		if !ok {
+			p = &watchedPipeline{item: item, workflows: make(map[string]string), jobs: make(map[string]string)}
+			w.pipelines[item.ID] = p
+			if !emit && w.Store != nil {
+				last, err := w.Store.LastPipelineNumber(item.ProjectSlug)
+				p.replay = err == nil && last > 0 && item.Number > last
+			}
+			if emit || p.replay {
+				active = true
+				if !w.send(ctx, PipelineCreated{Pipeline: item}) {
+					return active
+				}
+			}
+		}
+		p.item = item
+	}
+	if w.Store != nil {
+		for project, number := range numbers {
+			if last, err := w.Store.LastPipelineNumber(project); err == nil && number > last {
+				if err := w.Store.SetLastPipelineNumber(project, number); err != nil && !w.send(ctx, StoreFailed{Err: err}) {
+					return active
+				}
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(w.pipelines) {
+		p := w.pipelines[id]
+		// forget finished pipelines, and those that never started a workflow, once they drop out of the listing
+		if !listed[id] && (p.finished || len(p.workflows) == 0) {
+			delete(w.pipelines, id)
+			continue
+		}
+		if p.finished {
+			continue
+		}
+		changed, running, ok := w.pollPipeline(ctx, p, emit)
+		if !ok {
+			return true
+		}
+		active = active || changed || running
+	}
+
+	return active
+}
+
+// pollPipeline reports whether a workflow or job of the pipeline changed and whether a workflow is
+// still running, workflows on hold are not running
+func (w *Watcher) pollPipeline(ctx context.Context, p *watchedPipeline, emit bool) (changed bool, running bool, ok bool) {
+	emit = emit || p.replay
+	waiting := false
+	workflows := GetPipelineWorkflows(w.ci, p.item.ID, "none")
+	for _, workflow := range workflows {
+		previous, seen, stored := w.previous(StatusWorkflow, p.workflows, workflow.ID)
+		if !seen || previous != workflow.Status {
+			changed = true
+			// a status is only recorded once its event was delivered, so a stopped watcher reports it again
+			if (emit || stored) && !w.send(ctx, WorkflowStatusChanged{Pipeline: p.item, Workflow: workflow, Previous: previous}) {
+				return changed, running, false
+			}
+			if !w.record(ctx, StatusWorkflow, p.workflows, workflow.ID, workflow.Status) {
+				return changed, running, false
+			}
+		}
+		if !seen || previous != workflow.Status || !isTerminalStatus(workflow.Status) {
+			for _, job := range GetWorkflowJob(w.ci, workflow.ID, "none", "", "") {
+				if !w.pollJob(ctx, p, workflow, job, emit) {
+					return changed, running, false
+				}
+			}
+		}
+		// a workflow waiting for approval is followed but does not keep the poll interval short
+		if workflow.Status == "on_hold" {
+			waiting = true
+		} else {
+			running = running || !isTerminalStatus(workflow.Status)
+		}
+	}
+	p.finished = (len(workflows) > 0 && !running && !waiting) || p.item.State == "errored"
+	p.replay = false
+
+	return changed, running, true
+}
+
+func (w *Watcher) pollJob(ctx context.Context, p *watchedPipeline, workflow PipelineWorkflows, job WorkflowItem, emit bool) bool {
+	previous, seen, stored := w.previous(StatusJob, p.jobs, job.Id)
+	if seen && previous == job.Status {
+		return true
+	}
+	if emit || stored {
+		if !w.send(ctx, JobStatusChanged{Pipeline: p.item, Workflow: workflow, Job: job, Previous: previous}) {
+			return false
+		}
+		switch {
+		case job.Status == "failed":
+			if !w.send(ctx, JobFailed{Pipeline: p.item, Workflow: workflow, Job: job}) {
+				return false
+			}
+		case job.Type == "approval" && job.Status == "on_hold":
+			if !w.send(ctx, ApprovalPending{Pipeline: p.item, Workflow: workflow, Job: job}) {
+				return false
+			}
+		}
+	}
+
+	return w.record(ctx, StatusJob, p.jobs, job.Id, job.Status)
+}
+
+// previous returns the last known status of a workflow or job, falling back to the Store for ids
+// not seen since the watcher started. stored reports that the status came from the Store.
+func (w *Watcher) previous(kind string, seen map[string]string, id string) (status string, ok bool, stored bool) {
+	if status, ok = seen[id]; ok || w.Store == nil {
+		return status, ok, false
+	}
+	status, ok, err := w.Store.Status(kind, id)
+	if err != nil || !ok {
+		return "", false, false
+	}
+
+	return status, true, true
+}
+
+// record remembers a status and writes it to the Store. It reports false when the watcher stopped
+// while reporting a Store failure.
+func (w *Watcher) record(ctx context.Context, kind string, seen map[string]string, id string, status string) bool {
+	seen[id] = status
+	if w.Store == nil {
+		return true
+	}
+	if err := w.Store.SetStatus(kind, id, status); err != nil {
+		return w.send(ctx, StoreFailed{Err: fmt.Errorf("recording %s %s: %w", kind, id, err)})
+	}
+
+	return true
+}
+
+// send delivers an event unless the watcher is stopping
+func (w *Watcher) send(ctx context.Context, event WatchEvent) bool {
+	select {
+	case w.events <- event:
+		return true
+	case <-ctx.Done():
+	case <-w.stop:
+	}
+
+	return false
+}
+
+// isTerminalStatus reports whether a workflow or job status is final
+func isTerminalStatus(status string) bool {
+	switch status {
+	case "success", "failed", "error", "canceled", "unauthorized", "not_run", "infrastructure_fail", "timedout":
+		return true
+	}
+
+	return false
+}
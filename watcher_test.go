@@ -0,0 +1,77 @@
+package circleci
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatcherBacksOffOnHold(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline?org-slug=gh/bldmgr": `{"items":[{"id":"p1","number":7,"project_slug":"gh/bldmgr/circleci","state":"created"}]}`,
+		"api/v2/pipeline/p1/workflow":        `{"items":[{"id":"w1","name":"deploy","status":"on_hold","pipeline_id":"p1"}]}`,
+		"api/v2/workflow/w1/job":             `{"items":[{"id":"j1","name":"build","status":"success","job_number":41,"type":"build"},{"id":"j2","name":"hold","status":"on_hold","type":"approval"}]}`,
+	}}
+	w := NewWatcher(ci, "bldmgr")
+	w.pipelines = make(map[string]*watchedPipeline)
+	w.events = make(chan WatchEvent, 16)
+	w.stop = make(chan struct{})
+
+	w.poll(context.Background(), false)
+	if active := w.poll(context.Background(), true); active {
+		t.Error("a pipeline waiting for approval kept the poll interval short")
+	}
+	if w.pipelines["p1"].finished {
+		t.Error("a pipeline waiting for approval is no longer followed")
+	}
+}
+
+func TestWatcherRecordsOnlyDeliveredStatuses(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline/p1/workflow": `{"items":[{"id":"w1","name":"build","status":"success","pipeline_id":"p1"}]}`,
+		"api/v2/workflow/w1/job":      `{"items":[{"id":"j1","name":"build","status":"success","job_number":41,"type":"build"}]}`,
+	}}
+	store := NewMemoryStore()
+	store.SetStatus(StatusWorkflow, "w1", "running")
+	w := NewWatcher(ci, "bldmgr")
+	w.Store = store
+	w.events = make(chan WatchEvent)
+	w.stop = make(chan struct{})
+	close(w.stop)
+
+	p := &watchedPipeline{item: PipelineItem{ID: "p1"}, workflows: make(map[string]string), jobs: make(map[string]string)}
+	if _, _, ok := w.pollPipeline(context.Background(), p, true); ok {
+		t.Fatal("pollPipeline delivered an event to a stopped watcher")
+	}
+	if status, _, _ := store.Status(StatusWorkflow, "w1"); status != "running" {
+		t.Errorf("stored workflow status = %q, want the undelivered change not recorded", status)
+	}
+	if _, seen := p.workflows["w1"]; seen {
+		t.Error("the undelivered workflow status was remembered")
+	}
+}
+
+func TestWatcherRestart(t *testing.T) {
+	ci := &fakeCI{routes: map[string]string{
+		"api/v2/pipeline?org-slug=gh/bldmgr": `{"items":[]}`,
+	}}
+	w := NewWatcher(ci, "bldmgr")
+	w.MinInterval = time.Hour
+
+	for i := 0; i < 2; i++ {
+		events := w.Start(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			w.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Stop after start %d did not return", i+1)
+		}
+		if _, open := <-events; open {
+			t.Errorf("event channel of start %d still open after Stop", i+1)
+		}
+	}
+}
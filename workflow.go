@@ -8,7 +8,6 @@ import (
 
 const (
 	restWorkflowJob = "api/v2/workflow/%s/job"
-	restGetParallel = "api/v2/workflow/%s/job/%s/parallel-runs/1"
 )
 
 type listAssetsResponse struct {
@@ -27,23 +26,14 @@ type WorkflowItem struct {
 	StoppedAt   string `json:"stopped_at"`
 }
 
+// GetJobParallel returns the details of a job with the status of each of its parallel runs
 func GetJobParallel(ci CI, jobId string, vsc string, namespace string, project string, output string) (items JobDetails) {
-	var p JobDetails
-	url := fmt.Sprintf(restGetParallel, "d0c7ccea-144a-411e-b505-359ebfb296ef", "21692")
-	body, resp, err := ci.Get(url)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return
-	}
-
-	err = json.Unmarshal(body, &p)
-	if err != nil {
-		fmt.Printf("could not read items from response: %v", err)
-	}
+	p := GetJobDetails(ci, jobId, vsc, namespace, project, output)
 
-	if output == "json" {
-		fmt.Println("GetJobParallel")
-		fmt.Printf(string(body) + "\n")
-		fmt.Println("GetJobParallel done")
+	if output == "status" {
+		for _, run := range p.ParallelRuns {
+			fmt.Printf("Job %s node %d -> %s \n", jobId, run.Index, run.Status)
+		}
 	}
 
 	return p
@@ -56,7 +46,7 @@ func GetWorkflowJob(ci CI, workflowId string, output string, data string, token
 		url := fmt.Sprintf(restWorkflowJob, workflowId)
 
 		if continuation != "" {
-			url += "&continuationToken=" + continuation
+			url = withPageToken(url, continuation)
 		}
 
 		body, resp, err := ci.Get(url)